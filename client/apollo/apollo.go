@@ -0,0 +1,66 @@
+// Package apollo reads the retry policies of the client from apollo
+// config. The policy of an endpoint is kept under the prefix followed by
+// the service and endpoint, e.g. retry_policy.go.micro.srv.foo.Foo.Bar,
+// the policy of a service under the prefix and the service.
+package apollo
+
+import (
+	"sync"
+	"time"
+
+	apollo "common/apollo-config"
+	"common/client"
+)
+
+var (
+	// DefaultPrefix of the policy keys
+	DefaultPrefix = "retry_policy."
+	// DefaultRefresh is how long a value read from apollo is used
+	DefaultRefresh = time.Second * 10
+)
+
+type value struct {
+	value   string
+	expires time.Time
+}
+
+type policies struct {
+	sync.Mutex
+	prefix string
+	values map[string]value
+}
+
+// get reads the key from apollo at most once per DefaultRefresh,
+// missing keys are logged by apollo on each read
+func (p *policies) get(key string) string {
+	p.Lock()
+	defer p.Unlock()
+
+	if v, ok := p.values[key]; ok && time.Now().Before(v.expires) {
+		return v.value
+	}
+
+	v := apollo.GetStringValue(key, "")
+	p.values[key] = value{v, time.Now().Add(DefaultRefresh)}
+	return v
+}
+
+// Policies returns the retry policies kept in apollo under the
+// prefix, DefaultPrefix when empty
+func Policies(prefix string) client.PolicyFunc {
+	if len(prefix) == 0 {
+		prefix = DefaultPrefix
+	}
+
+	p := &policies{
+		prefix: prefix,
+		values: make(map[string]value),
+	}
+
+	return client.ParsePolicies(func(service, endpoint string) string {
+		if v := p.get(p.prefix + service + "." + endpoint); len(v) > 0 {
+			return v
+		}
+		return p.get(p.prefix + service)
+	})
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"math"
+	"math/rand"
 	"time"
 )
 
@@ -17,5 +18,14 @@ func backoffDo(attempts int) time.Duration {
 	if attempts == 0 {
 		return time.Duration(0)
 	}
-	return time.Duration(math.Pow(10, float64(attempts))) * time.Millisecond
+	return jitter(time.Duration(math.Pow(10, float64(attempts))) * time.Millisecond)
+}
+
+// jitter returns a random duration between d/2 and d so the retries of
+// calls failing together are spread
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
 }
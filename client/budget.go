@@ -0,0 +1,72 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultBudgetWindow is the time over which calls and retries are counted
+	DefaultBudgetWindow = time.Second * 10
+)
+
+// RetryBudget limits the retries to a ratio of the successful calls so
+// retries can not multiply the load on a failing service. It is shared
+// by the calls to all services.
+type RetryBudget struct {
+	sync.Mutex
+	ratio  float64
+	min    int
+	window time.Duration
+
+	// counts of the current and the previous window
+	start   time.Time
+	calls   [2]int
+	retries [2]int
+}
+
+// NewRetryBudget returns a budget allowing ratio retries per successful
+// call, e.g. 0.1, and at least min retries per window
+func NewRetryBudget(ratio float64, min int) *RetryBudget {
+	return &RetryBudget{
+		ratio:  ratio,
+		min:    min,
+		window: DefaultBudgetWindow,
+		start:  time.Now(),
+	}
+}
+
+func (b *RetryBudget) rotate() {
+	switch d := time.Since(b.start); {
+	case d >= 2*b.window:
+		b.calls = [2]int{}
+		b.retries = [2]int{}
+		b.start = time.Now()
+	case d >= b.window:
+		b.calls = [2]int{0, b.calls[0]}
+		b.retries = [2]int{0, b.retries[0]}
+		b.start = b.start.Add(b.window)
+	}
+}
+
+// Deposit counts a successful call
+func (b *RetryBudget) Deposit() {
+	b.Lock()
+	b.rotate()
+	b.calls[0]++
+	b.Unlock()
+}
+
+// Withdraw reports whether a retry is in the budget and counts it
+func (b *RetryBudget) Withdraw() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	b.rotate()
+	allowed := float64(b.min) + b.ratio*float64(b.calls[0]+b.calls[1])
+	if float64(b.retries[0]+b.retries[1]) >= allowed {
+		return false
+	}
+	b.retries[0]++
+	return true
+}
@@ -0,0 +1,188 @@
+// Package cache is a client wrapper caching the responses of calls made
+// with client.WithCache, identical concurrent calls may share one call.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"common/client"
+	"common/codec"
+	raw "common/codec/bytes"
+	"common/codec/json"
+	"common/codec/msgpack"
+	"common/codec/proto"
+	merrors "common/errors"
+)
+
+var (
+	// DefaultSize is the most responses kept by the default store
+	DefaultSize = 1024
+)
+
+type cacheWrapper struct {
+	client.Client
+	opts Options
+
+	sync.Mutex
+	// calls in flight by key
+	calls map[string]*flight
+}
+
+type flight struct {
+	// closed once the call is done
+	done chan struct{}
+	b    []byte
+	err  error
+	// the call ended with the context of its caller
+	canceled bool
+}
+
+// marshaler returns the marshaler of the content type the
+// request and response are keyed and stored with
+func marshaler(contentType string) codec.Marshaler {
+	switch contentType {
+	case "application/protobuf", "application/proto-rpc", "application/octet-stream":
+		return proto.Marshaler{}
+	case "application/msgpack":
+		return msgpack.Marshaler{}
+	}
+	return json.Marshaler{}
+}
+
+// marshal and unmarshal copy the bytes of frames, the
+// callers may change them while they are cached
+func marshal(m codec.Marshaler, v interface{}) ([]byte, error) {
+	if f, ok := v.(*raw.Frame); ok {
+		return append([]byte(nil), f.Data...), nil
+	}
+	return m.Marshal(v)
+}
+
+func unmarshal(m codec.Marshaler, b []byte, v interface{}) error {
+	if f, ok := v.(*raw.Frame); ok {
+		f.Data = append([]byte(nil), b...)
+		return nil
+	}
+	return m.Unmarshal(b, v)
+}
+
+// key is the service, endpoint and hash of the encoded request
+func key(m codec.Marshaler, req client.Request) (string, error) {
+	b, err := marshal(m, req.Body())
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(req.Service()))
+	h.Write([]byte{0})
+	h.Write([]byte(req.Endpoint()))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *cacheWrapper) Call(ctx context.Context, req client.Request, rsp interface{}, opts ...client.CallOption) error {
+	callOpts := c.Client.Options().CallOptions
+	for _, o := range opts {
+		o(&callOpts)
+	}
+
+	// only calls asking for it are cached
+	if callOpts.CacheExpiry <= 0 {
+		return c.Client.Call(ctx, req, rsp, opts...)
+	}
+
+	m := marshaler(req.ContentType())
+	k, err := key(m, req)
+	if err != nil {
+		return c.Client.Call(ctx, req, rsp, opts...)
+	}
+
+	if b, ok := c.opts.Store.Get(k); ok {
+		if err := unmarshal(m, b, rsp); err == nil {
+			return nil
+		}
+	}
+
+	if !c.opts.Singleflight {
+		return c.call(ctx, k, m, req, rsp, callOpts, opts)
+	}
+
+	c.Lock()
+	for {
+		f, ok := c.calls[k]
+		if !ok {
+			break
+		}
+		c.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return merrors.Timeout("go.micro.client", "%v", ctx.Err())
+		}
+		// the deadline or cancel of another caller, call again
+		if f.canceled {
+			c.Lock()
+			continue
+		}
+		if f.err != nil {
+			return f.err
+		}
+		return unmarshal(m, f.b, rsp)
+	}
+	f := &flight{done: make(chan struct{})}
+	c.calls[k] = f
+	c.Unlock()
+
+	f.err = c.call(ctx, k, m, req, rsp, callOpts, opts)
+	if f.err == nil {
+		f.b, f.err = marshal(m, rsp)
+	} else if ctx.Err() != nil {
+		f.canceled = true
+	}
+
+	c.Lock()
+	delete(c.calls, k)
+	c.Unlock()
+	close(f.done)
+
+	return f.err
+}
+
+// call makes the call and stores the response, errors are not cached
+func (c *cacheWrapper) call(ctx context.Context, k string, m codec.Marshaler, req client.Request, rsp interface{}, callOpts client.CallOptions, opts []client.CallOption) error {
+	if err := c.Client.Call(ctx, req, rsp, opts...); err != nil {
+		return err
+	}
+
+	if b, err := marshal(m, rsp); err == nil {
+		c.opts.Store.Set(k, b, callOpts.CacheExpiry)
+	}
+	return nil
+}
+
+// NewClientWrapper returns a wrapper caching the responses of
+// the calls made with client.WithCache
+func NewClientWrapper(opts ...Option) client.Wrapper {
+	options := Options{
+		Size: DefaultSize,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.Store == nil {
+		options.Store = NewLRU(options.Size)
+	}
+
+	return func(c client.Client) client.Client {
+		return &cacheWrapper{
+			Client: c,
+			opts:   options,
+			calls:  make(map[string]*flight),
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"common/client"
+	"common/client/mock"
+	raw "common/codec/bytes"
+	merrors "common/errors"
+)
+
+type testRequest struct {
+	Name string `json:"name"`
+}
+
+type testResponse struct {
+	Message string `json:"message"`
+}
+
+// hello answers the calls with a greeting
+func hello(req client.Request, rsp interface{}) error {
+	rsp.(*testResponse).Message = "Hello " + req.Body().(*testRequest).Name
+	return nil
+}
+
+func newTestClient(delay time.Duration, opts ...Option) (*mock.Client, client.Client) {
+	mc := mock.NewClient(delay, hello)
+	return mc, NewClientWrapper(opts...)(mc)
+}
+
+func call(t *testing.T, c client.Client, name string, opts ...client.CallOption) string {
+	var rsp testResponse
+	if err := mock.Call(c, "Test.Hello", &testRequest{Name: name}, &rsp, opts...); err != nil {
+		t.Fatal(err)
+	}
+	return rsp.Message
+}
+
+func TestCacheExpiry(t *testing.T) {
+	tc, c := newTestClient(0)
+
+	// not cached without an expiry
+	call(t, c, "John")
+	call(t, c, "John")
+	if tc.Calls() != 2 {
+		t.Fatalf("expected 2 calls, got %d", tc.Calls())
+	}
+
+	for i := 0; i < 3; i++ {
+		if msg := call(t, c, "Jane", client.WithCache(time.Millisecond*50)); msg != "Hello Jane" {
+			t.Fatalf("unexpected message %q", msg)
+		}
+	}
+	call(t, c, "Joe", client.WithCache(time.Millisecond*50))
+	if tc.Calls() != 4 {
+		t.Fatalf("expected 4 calls, got %d", tc.Calls())
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	call(t, c, "Jane", client.WithCache(time.Millisecond*50))
+	if tc.Calls() != 5 {
+		t.Fatalf("expected the expired response called again, got %d calls", tc.Calls())
+	}
+
+	// errors are not cached
+	tc.Handler = func(client.Request, interface{}) error {
+		return errors.New("failed")
+	}
+	for i := 0; i < 2; i++ {
+		if err := mock.Call(c, "Test.Hello", &testRequest{Name: "Jim"}, new(testResponse), client.WithCache(time.Second)); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if tc.Calls() != 7 {
+		t.Fatalf("expected 7 calls, got %d", tc.Calls())
+	}
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	tc, c := newTestClient(time.Millisecond*50, Singleflight(true))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if msg := call(t, c, "John", client.WithCache(time.Second)); msg != "Hello John" {
+				t.Errorf("unexpected message %q", msg)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tc.Calls() != 1 {
+		t.Fatalf("expected 1 call, got %d", tc.Calls())
+	}
+}
+
+func TestCacheSingleflightTimeout(t *testing.T) {
+	_, c := newTestClient(time.Millisecond*200, Singleflight(true))
+
+	go mock.Call(c, "Test.Hello", &testRequest{Name: "John"}, new(testResponse), client.WithCache(time.Second))
+	time.Sleep(time.Millisecond * 20)
+
+	// a waiting call gives up at its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	start := time.Now()
+	req := c.NewRequest("test.service", "Test.Hello", &testRequest{Name: "John"})
+	if err := c.Call(ctx, req, new(testResponse), client.WithCache(time.Second)); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if d := time.Since(start); d > time.Millisecond*100 {
+		t.Fatalf("the waiting call took %v", d)
+	}
+}
+
+// ctxClient fails the calls whose context ended, as the rpc client does
+type ctxClient struct {
+	client.Client
+}
+
+func (c ctxClient) Call(ctx context.Context, req client.Request, rsp interface{}, opts ...client.CallOption) error {
+	if err := c.Client.Call(ctx, req, rsp, opts...); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return merrors.Timeout("go.micro.client", "%v", err)
+	}
+	return nil
+}
+
+func TestCacheSingleflightLeaderTimeout(t *testing.T) {
+	mc := mock.NewClient(time.Millisecond*50, hello)
+	c := NewClientWrapper(Singleflight(true))(ctxClient{mc})
+
+	// the first call gives up before the response
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		req := c.NewRequest("test.service", "Test.Hello", &testRequest{Name: "John"})
+		errc <- c.Call(ctx, req, new(testResponse), client.WithCache(time.Second))
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	// a waiting call with a longer deadline is not failed by it
+	if msg := call(t, c, "John", client.WithCache(time.Second)); msg != "Hello John" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("expected the first call to time out")
+	}
+	if mc.Calls() != 2 {
+		t.Fatalf("expected 2 calls, got %d", mc.Calls())
+	}
+}
+
+func TestCacheFrame(t *testing.T) {
+	mc := mock.NewClient(0, func(req client.Request, rsp interface{}) error {
+		rsp.(*raw.Frame).Data = []byte("Hello")
+		return nil
+	})
+	c := NewClientWrapper()(mc)
+
+	get := func() *raw.Frame {
+		rsp := new(raw.Frame)
+		req := c.NewRequest("test.service", "Test.Hello", &raw.Frame{Data: []byte("John")}, client.WithContentType("application/octet-stream"))
+		if err := c.Call(context.Background(), req, rsp, client.WithCache(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		return rsp
+	}
+
+	// changing a frame returned leaves the cached one alone
+	get().Data[0] = 'J'
+	if rsp := get(); string(rsp.Data) != "Hello" || mc.Calls() != 1 {
+		t.Fatalf("unexpected cached frame %q after %d calls", rsp.Data, mc.Calls())
+	}
+}
+
+func TestLRU(t *testing.T) {
+	l := NewLRU(2)
+	l.Set("a", []byte("a"), time.Second)
+	l.Set("b", []byte("b"), time.Second)
+	l.Get("a")
+	l.Set("c", []byte("c"), time.Second)
+
+	if _, ok := l.Get("b"); ok {
+		t.Fatal("expected b evicted")
+	}
+	if b, ok := l.Get("a"); !ok || string(b) != "a" {
+		t.Fatal("expected a kept")
+	}
+}
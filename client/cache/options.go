@@ -0,0 +1,34 @@
+package cache
+
+type Options struct {
+	// Size is the most responses kept by the default LRU store
+	Size int
+	// Store holds the responses, an LRU of Size by default
+	Store Store
+	// Singleflight makes concurrent identical calls share one call
+	Singleflight bool
+}
+
+type Option func(*Options)
+
+// Size sets the most responses kept by the default LRU store
+func Size(n int) Option {
+	return func(o *Options) {
+		o.Size = n
+	}
+}
+
+// WithStore sets the store of the responses, e.g. NewFileStore
+func WithStore(s Store) Option {
+	return func(o *Options) {
+		o.Store = s
+	}
+}
+
+// Singleflight makes concurrent identical calls wait for the first
+// one instead of calling the service each
+func Singleflight(b bool) Option {
+	return func(o *Options) {
+		o.Singleflight = b
+	}
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"common/util/mem/cache"
+)
+
+// Store holds the encoded responses by key
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, b []byte, expiry time.Duration)
+}
+
+type entry struct {
+	key     string
+	b       []byte
+	expires time.Time
+}
+
+type lruStore struct {
+	size int
+
+	sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewLRU returns an in memory store evicting the least recently
+// used response once it holds size of them
+func NewLRU(size int) Store {
+	return &lruStore{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *lruStore) Get(key string) ([]byte, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		l.ll.Remove(el)
+		delete(l.items, key)
+		return nil, false
+	}
+
+	l.ll.MoveToFront(el)
+	return e.b, true
+}
+
+func (l *lruStore) Set(key string, b []byte, expiry time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	expires := time.Now().Add(expiry)
+	if el, ok := l.items[key]; ok {
+		e := el.Value.(*entry)
+		e.b = b
+		e.expires = expires
+		l.ll.MoveToFront(el)
+		return
+	}
+
+	l.items[key] = l.ll.PushFront(&entry{key: key, b: b, expires: expires})
+
+	for l.size > 0 && l.ll.Len() > l.size {
+		el := l.ll.Back()
+		l.ll.Remove(el)
+		delete(l.items, el.Value.(*entry).key)
+	}
+}
+
+type fileStore struct {
+	items *cache.FileItems
+	path  string
+}
+
+// NewFileStore returns a store keeping the responses as files under
+// path with the file backed cache, they survive restarts
+func NewFileStore(items *cache.FileItems, path string) Store {
+	return &fileStore{
+		items: items,
+		path:  path,
+	}
+}
+
+func (f *fileStore) Get(key string) ([]byte, bool) {
+	item, err := f.items.Value(key)
+	if err != nil {
+		return nil, false
+	}
+
+	if time.Since(item.CreatedOn()) > item.LifeSpan() {
+		_, _ = f.items.Delete(key, false)
+		return nil, false
+	}
+
+	b, err := item.Data()
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func (f *fileStore) Set(key string, b []byte, expiry time.Duration) {
+	// items are never replaced in place
+	if f.items.Exists(key) {
+		_, _ = f.items.Delete(key, false)
+	}
+	f.items.Add(key, f.path, expiry, b)
+}
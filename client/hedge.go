@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"common/registry"
+	"common/selector"
+)
+
+const (
+	// hedgeWindow is the number of latencies kept per endpoint
+	hedgeWindow = 128
+	// hedgeSamples is the number of latencies needed for a percentile
+	hedgeSamples = 20
+)
+
+var (
+	// DefaultHedges tracks the hedged calls of the rpc client
+	DefaultHedges = NewHedges()
+)
+
+// HedgeStats are the hedging counters of an endpoint
+type HedgeStats struct {
+	Service  string
+	Endpoint string
+	// Calls made with hedging enabled
+	Calls int64
+	// Hedged copies sent
+	Hedges int64
+	// Calls answered by a hedged copy
+	Wins int64
+}
+
+// Hedges tracks the latency of the endpoints called with hedging enabled
+// and counts the hedged copies sent.
+type Hedges struct {
+	sync.RWMutex
+	endpoints map[string]*hedgeEndpoint
+}
+
+type hedgeEndpoint struct {
+	service  string
+	endpoint string
+
+	calls  int64
+	hedges int64
+	wins   int64
+
+	sync.Mutex
+	latencies []time.Duration
+	pos       int
+}
+
+// NewHedges returns a tracker without endpoints
+func NewHedges() *Hedges {
+	return &Hedges{
+		endpoints: make(map[string]*hedgeEndpoint),
+	}
+}
+
+func (h *Hedges) endpoint(req Request) *hedgeEndpoint {
+	key := req.Service() + "." + req.Endpoint()
+
+	h.RLock()
+	e, ok := h.endpoints[key]
+	h.RUnlock()
+	if ok {
+		return e
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	if e, ok = h.endpoints[key]; !ok {
+		e = &hedgeEndpoint{service: req.Service(), endpoint: req.Endpoint()}
+		h.endpoints[key] = e
+	}
+	return e
+}
+
+// Stats returns the counters keyed by service and endpoint
+func (h *Hedges) Stats() map[string]HedgeStats {
+	h.RLock()
+	defer h.RUnlock()
+
+	stats := make(map[string]HedgeStats, len(h.endpoints))
+	for key, e := range h.endpoints {
+		stats[key] = HedgeStats{
+			Service:  e.service,
+			Endpoint: e.endpoint,
+			Calls:    atomic.LoadInt64(&e.calls),
+			Hedges:   atomic.LoadInt64(&e.hedges),
+			Wins:     atomic.LoadInt64(&e.wins),
+		}
+	}
+	return stats
+}
+
+func (e *hedgeEndpoint) record(d time.Duration) {
+	e.Lock()
+	if len(e.latencies) < hedgeWindow {
+		e.latencies = append(e.latencies, d)
+	} else {
+		e.latencies[e.pos] = d
+		e.pos = (e.pos + 1) % hedgeWindow
+	}
+	e.Unlock()
+}
+
+// percentile returns the p percentile of the recent latencies, zero
+// until enough calls were seen
+func (e *hedgeEndpoint) percentile(p float64) time.Duration {
+	e.Lock()
+	if len(e.latencies) < hedgeSamples {
+		e.Unlock()
+		return 0
+	}
+	latencies := append([]time.Duration(nil), e.latencies...)
+	e.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	i := int(p * float64(len(latencies)))
+	if i >= len(latencies) {
+		i = len(latencies) - 1
+	}
+	return latencies[i]
+}
+
+// delay returns how long to wait for a response before hedging,
+// zero when the call is not hedged
+func (e *hedgeEndpoint) delay(opts CallOptions) time.Duration {
+	if opts.HedgePercentile > 0 {
+		if d := e.percentile(opts.HedgePercentile); d > 0 {
+			return d
+		}
+	}
+	return opts.HedgeDelay
+}
+
+// hedging tells whether the call may be hedged, only idempotent calls
+// whose response can be copied are
+func hedging(rsp interface{}, opts CallOptions) bool {
+	if opts.HedgeDelay <= 0 && opts.HedgePercentile <= 0 {
+		return false
+	}
+	if !opts.Idempotent {
+		return false
+	}
+	v := reflect.ValueOf(rsp)
+	return v.Kind() == reflect.Ptr && !v.IsNil()
+}
+
+// hedgeNode returns the next node not called yet, nil if the selector
+// keeps returning the called nodes. The nodes skipped are released.
+func (r *rpcClient) hedgeNode(service string, next selector.Next, sent map[string]bool) *registry.Node {
+	for i := 0; i < 3; i++ {
+		n, err := next()
+		if err != nil {
+			return nil
+		}
+		if !sent[nodeAddress(n)] {
+			return n
+		}
+		r.release(service, n)
+	}
+	return nil
+}
+
+// release hands back a node returned by the selector but not called
+func (r *rpcClient) release(service string, node *registry.Node) {
+	if rl, ok := r.opts.Selector.(selector.Releaser); ok {
+		rl.Release(service, node)
+	}
+}
+
+// hedge makes the call to node and sends a copy to another node each
+// time the delay passes without a response. The first successful response
+// is copied into rsp and the other calls cancelled.
+func (r *rpcClient) hedge(ctx context.Context, next selector.Next, node *registry.Node, req Request, rsp interface{}, opts CallOptions, rcall CallFunc) error {
+	e := DefaultHedges.endpoint(req)
+	atomic.AddInt64(&e.calls, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	max := opts.MaxHedges
+	if max <= 0 {
+		max = 1
+	}
+
+	type result struct {
+		rsp   interface{}
+		err   error
+		hedge bool
+	}
+
+	ch := make(chan result, max+1)
+	typ := reflect.TypeOf(rsp).Elem()
+	start := time.Now()
+	sent := map[string]bool{}
+
+	send := func(node *registry.Node, hedge bool) {
+		sent[nodeAddress(node)] = true
+		v := reflect.New(typ).Interface()
+
+		go func() {
+			err := rcall(ctx, node, req, v, opts)
+
+			// calls cancelled by the winner did not fail
+			merr := err
+			if err != nil && ctx.Err() == context.Canceled {
+				merr = nil
+			}
+			r.opts.Selector.Mark(req.Service(), node, merr)
+
+			ch <- result{v, err, hedge}
+		}()
+	}
+
+	send(node, false)
+	pending := 1
+	hedges := 0
+
+	// not hedged until the latency of the endpoint is known
+	var timeout <-chan time.Time
+	if d := e.delay(opts); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	for {
+		select {
+		case res := <-ch:
+			pending--
+			if res.err == nil {
+				e.record(time.Since(start))
+				if res.hedge {
+					atomic.AddInt64(&e.wins, 1)
+				}
+				reflect.ValueOf(rsp).Elem().Set(reflect.ValueOf(res.rsp).Elem())
+				return nil
+			}
+
+			// the failed call is left to the retries
+			if pending == 0 {
+				return res.err
+			}
+		case <-timeout:
+			timeout = nil
+
+			// a copy is only sent to a node not called yet
+			n := r.hedgeNode(req.Service(), next, sent)
+			if n == nil {
+				continue
+			}
+
+			// the copies are charged to the retry budget
+			if opts.Budget != nil && !opts.Budget.Withdraw() {
+				r.release(req.Service(), n)
+				continue
+			}
+
+			send(n, true)
+			pending++
+			hedges++
+			atomic.AddInt64(&e.hedges, 1)
+
+			if hedges < max {
+				if d := e.delay(opts); d > 0 {
+					t := time.NewTimer(d)
+					defer t.Stop()
+					timeout = t.C
+				}
+			}
+		}
+	}
+}
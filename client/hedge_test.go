@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"common/registry"
+	"common/registry/memory"
+	"common/selector"
+)
+
+func TestHedge(t *testing.T) {
+	r := memory.NewRegistry()
+	if err := r.Register(&registry.Service{
+		Name:    "test.hedge",
+		Version: "1.0.0",
+		Nodes: []*registry.Node{
+			{Id: "slow", Address: "10.0.0.1", Port: 8080},
+			{Id: "fast", Address: "10.0.0.2", Port: 8080},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the slow node only answers when it is not cancelled
+	stub := func(CallFunc) CallFunc {
+		return func(ctx context.Context, node *registry.Node, req Request, rsp interface{}, opts CallOptions) error {
+			if node.Id == "slow" {
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			*rsp.(*string) = node.Id
+			return nil
+		}
+	}
+
+	// round robin hands the hedge the other node
+	c := NewClient(
+		Selector(selector.NewSelector(selector.Registry(r), selector.SetStrategy(selector.RoundRobin))),
+		WrapCall(stub),
+	)
+
+	before := DefaultHedges.Stats()["test.hedge.Test.Hello"]
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		var rsp string
+		req := c.NewRequest("test.hedge", "Test.Hello", "John")
+		if err := c.Call(context.Background(), req, &rsp, Idempotent(), WithHedge(time.Millisecond*10)); err != nil {
+			t.Fatal(err)
+		}
+		if rsp != "fast" {
+			t.Fatalf("unexpected response %q", rsp)
+		}
+	}
+	if d := time.Since(start); d > time.Millisecond*500 {
+		t.Fatalf("hedged calls took %v", d)
+	}
+
+	// the calls sent to the slow node first were won by a hedge
+	s := DefaultHedges.Stats()["test.hedge.Test.Hello"]
+	if s.Calls-before.Calls != 4 || s.Hedges != s.Wins {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// calls not marked idempotent are not hedged
+	var rsp string
+	req := c.NewRequest("test.hedge", "Test.Hello", "John")
+	if err := c.Call(context.Background(), req, &rsp, WithHedge(time.Millisecond*10)); err != nil {
+		t.Fatal(err)
+	}
+	if s := DefaultHedges.Stats()["test.hedge.Test.Hello"]; s.Calls-before.Calls != 4 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHedgeRelease(t *testing.T) {
+	r := memory.NewRegistry()
+	if err := r.Register(&registry.Service{
+		Name:    "test.hedge",
+		Version: "1.0.0",
+		Nodes:   []*registry.Node{{Id: "only", Address: "10.0.0.1", Port: 8080}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stub := func(CallFunc) CallFunc {
+		return func(ctx context.Context, node *registry.Node, req Request, rsp interface{}, opts CallOptions) error {
+			time.Sleep(time.Millisecond * 20)
+			*rsp.(*string) = node.Id
+			return nil
+		}
+	}
+
+	b := selector.NewBalancer()
+	c := NewClient(
+		Selector(selector.NewSelector(selector.Registry(r), selector.SetBalancer(b), selector.SetStrategy(b.LeastOutstanding))),
+		WrapCall(stub),
+	)
+
+	// the hedge finds no other node, the ones it skipped are released
+	var rsp string
+	req := c.NewRequest("test.hedge", "Test.Hello", "John")
+	if err := c.Call(context.Background(), req, &rsp, Idempotent(), WithHedge(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.Stats()["only"]; s.InFlight != 0 || s.Requests != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	r := memory.NewRegistry()
+	if err := r.Register(&registry.Service{
+		Name:    "test.budget",
+		Version: "1.0.0",
+		Nodes: []*registry.Node{
+			{Id: "a", Address: "10.0.0.1", Port: 8080},
+			{Id: "b", Address: "10.0.0.2", Port: 8080},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stub := func(CallFunc) CallFunc {
+		return func(ctx context.Context, node *registry.Node, req Request, rsp interface{}, opts CallOptions) error {
+			time.Sleep(time.Millisecond * 20)
+			*rsp.(*string) = node.Id
+			return nil
+		}
+	}
+
+	c := NewClient(
+		Selector(selector.NewSelector(selector.Registry(r), selector.SetStrategy(selector.RoundRobin))),
+		WrapCall(stub),
+	)
+
+	before := DefaultHedges.Stats()["test.budget.Test.Hello"]
+
+	// the hedged copies are charged to the budget, one is left
+	b := NewRetryBudget(0, 1)
+	for i := 0; i < 2; i++ {
+		var rsp string
+		req := c.NewRequest("test.budget", "Test.Hello", "John")
+		if err := c.Call(context.Background(), req, &rsp, Idempotent(), WithHedge(time.Millisecond), WithBudget(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := DefaultHedges.Stats()["test.budget.Test.Hello"]; s.Calls-before.Calls != 2 || s.Hedges-before.Hedges != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,305 @@
+// Package limit is a client wrapper limiting the rate and the calls in
+// flight per service and endpoint. Calls over the limits wait for their
+// turn or fail fast with a 429 error, the limits can be changed at runtime.
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"common/client"
+	merrors "common/errors"
+)
+
+const (
+	// ErrorId is the id of the errors of the calls over the limits
+	ErrorId = "go.micro.client.limit"
+)
+
+type key struct {
+	service  string
+	endpoint string
+}
+
+// Stats are the counters of a service or endpoint, the endpoint
+// is empty for the limits of a service
+type Stats struct {
+	Service  string
+	Endpoint string
+	InFlight int
+	// Limit of the calls in flight, zero when unlimited
+	Limit    int
+	Rejected int64
+}
+
+// Limiter holds the limits and the calls in flight of the services
+type Limiter struct {
+	sync.RWMutex
+	opts    Options
+	targets map[key]*target
+}
+
+// NewLimiter returns a limiter of the given limits
+func NewLimiter(opts ...Option) *Limiter {
+	var options Options
+	for _, o := range opts {
+		o(&options)
+	}
+
+	l := &Limiter{
+		opts:    options,
+		targets: make(map[key]*target),
+	}
+
+	for name, limits := range options.Services {
+		k := key{name, ""}
+		l.targets[k] = newTarget(k, limits, true)
+	}
+	for name, endpoints := range options.Endpoints {
+		for endpoint, limits := range endpoints {
+			k := key{name, endpoint}
+			l.targets[k] = newTarget(k, limits, true)
+		}
+	}
+
+	return l
+}
+
+// Set changes the limits of a service, or of an endpoint when not empty
+func (l *Limiter) Set(service, endpoint string, limits Limits) {
+	l.Lock()
+	defer l.Unlock()
+
+	k := key{service, endpoint}
+	if t, ok := l.targets[k]; ok {
+		t.explicit = true
+		t.set(limits)
+		return
+	}
+	l.targets[k] = newTarget(k, limits, true)
+}
+
+// SetDefault changes the limits of the services without limits of their own
+func (l *Limiter) SetDefault(limits Limits) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.opts.Limits = limits
+	for _, t := range l.targets {
+		if !t.explicit {
+			t.set(limits)
+		}
+	}
+}
+
+// Stats returns the counters of the services and endpoints called
+func (l *Limiter) Stats() []Stats {
+	l.RLock()
+	defer l.RUnlock()
+
+	stats := make([]Stats, 0, len(l.targets))
+	for k, t := range l.targets {
+		t.Lock()
+		s := Stats{
+			Service:  k.service,
+			Endpoint: k.endpoint,
+			InFlight: t.inflight,
+			Rejected: t.rejected,
+		}
+		if t.limits.MaxInFlight > 0 {
+			s.Limit = int(t.limit)
+		}
+		t.Unlock()
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// get returns the targets limiting the request, the service followed
+// by the endpoint
+func (l *Limiter) get(req client.Request) []*target {
+	svc := key{req.Service(), ""}
+	ep := key{req.Service(), req.Endpoint()}
+
+	l.RLock()
+	st, ok := l.targets[svc]
+	et, eok := l.targets[ep]
+	defaults := l.opts.Limits
+	l.RUnlock()
+
+	// the service is limited by the defaults
+	if !ok && defaults != (Limits{}) {
+		l.Lock()
+		if st, ok = l.targets[svc]; !ok {
+			st = newTarget(svc, l.opts.Limits, false)
+			l.targets[svc] = st
+			ok = true
+		}
+		l.Unlock()
+	}
+
+	var targets []*target
+	if ok {
+		targets = append(targets, st)
+	}
+	if eok {
+		targets = append(targets, et)
+	}
+	return targets
+}
+
+// wait returns how long the call may wait for its turn
+func (l *Limiter) wait(ctx context.Context, opts client.CallOptions) time.Duration {
+	if l.opts.FailFast {
+		return 0
+	}
+
+	d := l.opts.MaxWait
+	if d <= 0 {
+		d = opts.RequestTimeout
+	}
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) < d {
+		d = time.Until(dl)
+	}
+	return d
+}
+
+// take waits for the tokens of the targets
+func (l *Limiter) take(ctx context.Context, req client.Request, targets []*target, max time.Duration) error {
+	var wait time.Duration
+	for i, t := range targets {
+		d, ok := t.reserve(max)
+		if !ok {
+			for _, t := range targets[:i] {
+				t.unreserve()
+			}
+			return merrors.TooManyRequests(ErrorId, "rate limit of %s exceeded", t.name)
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		for _, t := range targets {
+			t.unreserve()
+		}
+		return merrors.Timeout(ErrorId, "%v waiting for the rate limit of %s", ctx.Err(), req.Service())
+	}
+}
+
+// acquire takes the slots of the targets, the time spent is
+// taken from max
+func (l *Limiter) acquire(ctx context.Context, req client.Request, targets []*target, max time.Duration) error {
+	start := time.Now()
+
+	for i, t := range targets {
+		if t.acquire(ctx, max-time.Since(start)) {
+			continue
+		}
+
+		// the slots taken are given back
+		for _, t := range targets[:i] {
+			t.release(0, false, &l.opts)
+		}
+		return merrors.TooManyRequests(ErrorId, "too many calls in flight to %s", t.name)
+	}
+
+	return nil
+}
+
+// dropped tells the calls the service could not take, they shrink
+// the adaptive limits
+func dropped(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	e := merrors.FromError(err)
+	if e.Id == ErrorId {
+		return false
+	}
+
+	switch e.Code {
+	case 408, 429, 503:
+		return true
+	}
+	return false
+}
+
+type limitWrapper struct {
+	client.Client
+	l *Limiter
+}
+
+func (w *limitWrapper) Call(ctx context.Context, req client.Request, rsp interface{}, opts ...client.CallOption) error {
+	targets := w.l.get(req)
+	if len(targets) == 0 {
+		return w.Client.Call(ctx, req, rsp, opts...)
+	}
+
+	callOpts := w.Client.Options().CallOptions
+	for _, o := range opts {
+		o(&callOpts)
+	}
+
+	max := w.l.wait(ctx, callOpts)
+	start := time.Now()
+
+	if err := w.l.take(ctx, req, targets, max); err != nil {
+		return err
+	}
+	if err := w.l.acquire(ctx, req, targets, max-time.Since(start)); err != nil {
+		return err
+	}
+
+	start = time.Now()
+	err := w.Client.Call(ctx, req, rsp, opts...)
+	rtt, drop := time.Since(start), dropped(err)
+
+	for _, t := range targets {
+		t.release(rtt, drop, &w.l.opts)
+	}
+
+	return err
+}
+
+// Stream is only rate limited, streams are not counted as in flight
+func (w *limitWrapper) Stream(ctx context.Context, req client.Request, opts ...client.CallOption) (client.Stream, error) {
+	targets := w.l.get(req)
+	if len(targets) == 0 {
+		return w.Client.Stream(ctx, req, opts...)
+	}
+
+	callOpts := w.Client.Options().CallOptions
+	for _, o := range opts {
+		o(&callOpts)
+	}
+
+	if err := w.l.take(ctx, req, targets, w.l.wait(ctx, callOpts)); err != nil {
+		return nil, err
+	}
+
+	return w.Client.Stream(ctx, req, opts...)
+}
+
+// NewClientWrapper returns a wrapper limiting the calls by l
+func NewClientWrapper(l *Limiter) client.Wrapper {
+	return func(c client.Client) client.Client {
+		return &limitWrapper{
+			Client: c,
+			l:      l,
+		}
+	}
+}
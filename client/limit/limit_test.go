@@ -0,0 +1,162 @@
+package limit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"common/client"
+	"common/client/mock"
+	merrors "common/errors"
+)
+
+func newTestClient(l *Limiter, delay time.Duration) (*mock.Client, client.Client) {
+	mc := mock.NewClient(delay, nil)
+	return mc, NewClientWrapper(l)(mc)
+}
+
+func call(c client.Client, endpoint string) error {
+	return mock.Call(c, endpoint, "John", new(string))
+}
+
+func TestLimitRate(t *testing.T) {
+	l := NewLimiter(Rate(100, 1), FailFast())
+	_, c := newTestClient(l, 0)
+
+	if err := call(c, "Test.Hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the bucket is empty, the call fails with a typed error
+	err := call(c, "Test.Hello")
+	if e, ok := err.(*merrors.Error); !ok || e.Code != 429 || e.Id != ErrorId {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+
+	// calls waiting for a token are let through at the rate
+	l = NewLimiter(Rate(100, 1), MaxWait(time.Second))
+	_, c = newTestClient(l, 0)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := call(c, "Test.Hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < time.Millisecond*30 || d > time.Millisecond*500 {
+		t.Fatalf("rate limited calls took %v", d)
+	}
+}
+
+func TestLimitInFlight(t *testing.T) {
+	l := NewLimiter(
+		MaxInFlight(4),
+		Endpoint("test.service", "Test.Slow", Limits{MaxInFlight: 2}),
+		MaxWait(time.Second),
+	)
+	tc, c := newTestClient(l, time.Millisecond*20)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- call(c, "Test.Slow")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := tc.MaxInFlight(); n != 2 {
+		t.Fatalf("%d calls were in flight, want 2", n)
+	}
+
+	// the limits are raised at runtime
+	l.Set("test.service", "Test.Slow", Limits{MaxInFlight: 3})
+	tc.Reset()
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			call(c, "Test.Slow")
+		}()
+	}
+	wg.Wait()
+
+	if n := tc.MaxInFlight(); n != 3 {
+		t.Fatalf("%d calls were in flight, want 3", n)
+	}
+}
+
+func TestLimitFailFast(t *testing.T) {
+	l := NewLimiter(MaxInFlight(1), FailFast())
+	_, c := newTestClient(l, time.Millisecond*50)
+
+	done := make(chan error)
+	go func() {
+		done <- call(c, "Test.Hello")
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	err := call(c, "Test.Hello")
+	if e := merrors.FromError(err); e.Code != 429 {
+		t.Fatalf("expected a concurrency limit error, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range l.Stats() {
+		if s.Service == "test.service" && (s.Rejected != 1 || s.InFlight != 0) {
+			t.Fatalf("unexpected stats %+v", s)
+		}
+	}
+}
+
+func TestLimitAdaptive(t *testing.T) {
+	l := NewLimiter(MaxInFlight(10), Adaptive(2, 20), MaxWait(time.Second))
+	tc, c := newTestClient(l, time.Millisecond)
+
+	limit := func() int {
+		for _, s := range l.Stats() {
+			if s.Service == "test.service" {
+				return s.Limit
+			}
+		}
+		return 0
+	}
+
+	// the service dropping calls shrinks the limit
+	tc.Handler = func(client.Request, interface{}) error {
+		return merrors.ServiceUnavailable("test.service", "overloaded")
+	}
+	for i := 0; i < 20; i++ {
+		call(c, "Test.Hello")
+	}
+	if n := limit(); n != 2 {
+		t.Fatalf("unexpected limit %d after dropped calls", n)
+	}
+
+	// and it grows back as the calls succeed under load
+	tc.Handler = nil
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			call(c, "Test.Hello")
+		}()
+	}
+	wg.Wait()
+
+	if n := limit(); n <= 2 {
+		t.Fatalf("unexpected limit %d after successful calls", n)
+	}
+}
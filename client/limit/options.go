@@ -0,0 +1,99 @@
+package limit
+
+import (
+	"time"
+)
+
+// Limits are the limits of the calls to a service or endpoint,
+// zero values are unlimited
+type Limits struct {
+	// Rate of calls per second and the Burst of calls allowed above it,
+	// a burst of one when not set
+	Rate  float64
+	Burst int
+	// MaxInFlight calls, the initial limit in the adaptive mode
+	MaxInFlight int
+}
+
+type Options struct {
+	// Limits of each service without limits of its own
+	Limits Limits
+	// Services and endpoints with limits of their own
+	Services  map[string]Limits
+	Endpoints map[string]map[string]Limits
+	// FailFast rejects the calls over the limits at once rather than
+	// blocking until MaxWait, the request timeout or the call deadline
+	FailFast bool
+	MaxWait  time.Duration
+	// Adaptive moves the in flight limits between MinLimit and MaxLimit,
+	// shrinking them as the latency rises
+	Adaptive bool
+	MinLimit int
+	MaxLimit int
+}
+
+type Option func(*Options)
+
+// Rate limits the calls per second of each service
+func Rate(r float64, burst int) Option {
+	return func(o *Options) {
+		o.Limits.Rate = r
+		o.Limits.Burst = burst
+	}
+}
+
+// MaxInFlight limits the calls in flight to each service
+func MaxInFlight(n int) Option {
+	return func(o *Options) {
+		o.Limits.MaxInFlight = n
+	}
+}
+
+// Service sets the limits of a service
+func Service(name string, l Limits) Option {
+	return func(o *Options) {
+		if o.Services == nil {
+			o.Services = make(map[string]Limits)
+		}
+		o.Services[name] = l
+	}
+}
+
+// Endpoint sets the limits of an endpoint, they apply on top
+// of the limits of its service
+func Endpoint(service, endpoint string, l Limits) Option {
+	return func(o *Options) {
+		if o.Endpoints == nil {
+			o.Endpoints = make(map[string]map[string]Limits)
+		}
+		if o.Endpoints[service] == nil {
+			o.Endpoints[service] = make(map[string]Limits)
+		}
+		o.Endpoints[service][endpoint] = l
+	}
+}
+
+// FailFast rejects the calls over the limits without waiting
+func FailFast() Option {
+	return func(o *Options) {
+		o.FailFast = true
+	}
+}
+
+// MaxWait sets how long calls over the limits wait, the request
+// timeout of the call by default
+func MaxWait(d time.Duration) Option {
+	return func(o *Options) {
+		o.MaxWait = d
+	}
+}
+
+// Adaptive moves the in flight limits between min and max following
+// the latency, the limits shrink as it rises above the lowest seen
+func Adaptive(min, max int) Option {
+	return func(o *Options) {
+		o.Adaptive = true
+		o.MinLimit = min
+		o.MaxLimit = max
+	}
+}
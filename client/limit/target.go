@@ -0,0 +1,214 @@
+package limit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// tolerance is the latency above the lowest seen not
+	// shrinking the adaptive limit
+	tolerance = 1.5
+	// probe is the number of calls after which the lowest
+	// latency is measured again
+	probe = 1000
+	// backoff shrinks the adaptive limit on dropped calls
+	backoff = 0.9
+)
+
+// target holds the token bucket and the calls in flight of a service
+// or endpoint
+type target struct {
+	sync.Mutex
+	name   string
+	limits Limits
+	// limits set explicitly, the others follow the defaults
+	explicit bool
+
+	tokens float64
+	last   time.Time
+
+	inflight int
+	limit    float64
+	waiters  []chan struct{}
+
+	minRTT  time.Duration
+	samples int
+
+	rejected int64
+}
+
+func newTarget(k key, l Limits, explicit bool) *target {
+	name := k.service
+	if len(k.endpoint) > 0 {
+		name += " " + k.endpoint
+	}
+
+	t := &target{
+		name:     name,
+		explicit: explicit,
+		last:     time.Now(),
+	}
+	t.set(l)
+	t.tokens = t.burst()
+	return t
+}
+
+func (t *target) burst() float64 {
+	if t.limits.Burst > 0 {
+		return float64(t.limits.Burst)
+	}
+	return 1
+}
+
+// set applies new limits, the waiters are let through if there is room
+func (t *target) set(l Limits) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.limits = l
+	t.limit = float64(l.MaxInFlight)
+	t.tokens = math.Min(t.tokens, t.burst())
+	t.wake()
+}
+
+// reserve takes a token, telling how long to wait until it is due.
+// No token is taken when that is longer than max.
+func (t *target) reserve(max time.Duration) (time.Duration, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.limits.Rate <= 0 {
+		return 0, true
+	}
+
+	now := time.Now()
+	t.tokens = math.Min(t.burst(), t.tokens+now.Sub(t.last).Seconds()*t.limits.Rate)
+	t.last = now
+
+	var wait time.Duration
+	if t.tokens < 1 {
+		wait = time.Duration((1 - t.tokens) / t.limits.Rate * float64(time.Second))
+	}
+	if wait > max {
+		t.rejected++
+		return 0, false
+	}
+
+	t.tokens--
+	return wait, true
+}
+
+// unreserve returns a token of a call given up
+func (t *target) unreserve() {
+	t.Lock()
+	if t.limits.Rate > 0 {
+		t.tokens++
+	}
+	t.Unlock()
+}
+
+// acquire takes a slot of the calls in flight, waiting at most max
+func (t *target) acquire(ctx context.Context, max time.Duration) bool {
+	t.Lock()
+	if t.limits.MaxInFlight <= 0 || float64(t.inflight) < t.limit {
+		t.inflight++
+		t.Unlock()
+		return true
+	}
+	if max <= 0 {
+		t.rejected++
+		t.Unlock()
+		return false
+	}
+
+	ch := make(chan struct{}, 1)
+	t.waiters = append(t.waiters, ch)
+	t.Unlock()
+
+	timer := time.NewTimer(max)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	for i, w := range t.waiters {
+		if w == ch {
+			t.waiters = append(t.waiters[:i], t.waiters[i+1:]...)
+			t.rejected++
+			return false
+		}
+	}
+
+	// the slot was handed over while giving up
+	return true
+}
+
+// wake hands the free slots to the waiters
+func (t *target) wake() {
+	for len(t.waiters) > 0 && (t.limits.MaxInFlight <= 0 || float64(t.inflight) < t.limit) {
+		t.inflight++
+		t.waiters[0] <- struct{}{}
+		t.waiters = t.waiters[1:]
+	}
+}
+
+// release frees the slot of a call, the adaptive limit follows its
+// latency and whether it was dropped by the service
+func (t *target) release(rtt time.Duration, dropped bool, opts *Options) {
+	t.Lock()
+	defer t.Unlock()
+
+	if opts.Adaptive && t.limits.MaxInFlight > 0 && rtt > 0 {
+		t.adapt(rtt, dropped, opts)
+	}
+
+	t.inflight--
+	t.wake()
+}
+
+// adapt shrinks the limit by the gradient of the latency to the lowest
+// latency seen, the square root of the limit is left for queueing.
+// Dropped calls shrink the limit by backoff.
+func (t *target) adapt(rtt time.Duration, dropped bool, opts *Options) {
+	t.samples++
+	if t.samples >= probe {
+		t.samples = 0
+		t.minRTT = 0
+	}
+	if t.minRTT == 0 || rtt < t.minRTT {
+		t.minRTT = rtt
+	}
+
+	if dropped {
+		t.limit *= backoff
+	} else {
+		gradient := math.Max(0.5, math.Min(1, tolerance*float64(t.minRTT)/float64(rtt)))
+		limit := t.limit*gradient + math.Sqrt(t.limit)
+
+		// too few calls to tell whether the limit can grow
+		if limit > t.limit && float64(t.inflight) < t.limit/2 {
+			return
+		}
+		t.limit = 0.8*t.limit + 0.2*limit
+	}
+
+	if min := float64(opts.MinLimit); t.limit < min {
+		t.limit = min
+	}
+	if max := float64(opts.MaxLimit); max > 0 && t.limit > max {
+		t.limit = max
+	}
+	if t.limit < 1 {
+		t.limit = 1
+	}
+}
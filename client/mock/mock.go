@@ -0,0 +1,75 @@
+// Package mock is a client answering the calls itself, for testing
+// the client wrappers without a server.
+package mock
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"common/client"
+)
+
+// Handler answers a call, rsp is left alone by a nil Handler
+type Handler func(req client.Request, rsp interface{}) error
+
+// Client counts the calls and the most calls in flight at once,
+// every call takes Delay and is answered by Handler
+type Client struct {
+	client.Client
+	Delay   time.Duration
+	Handler Handler
+
+	calls    int32
+	inflight int32
+	max      int32
+}
+
+// NewClient returns a client answering the calls with h after delay
+func NewClient(delay time.Duration, h Handler) *Client {
+	return &Client{
+		Client:  client.NewClient(),
+		Delay:   delay,
+		Handler: h,
+	}
+}
+
+func (c *Client) Call(ctx context.Context, req client.Request, rsp interface{}, opts ...client.CallOption) error {
+	atomic.AddInt32(&c.calls, 1)
+	n := atomic.AddInt32(&c.inflight, 1)
+	defer atomic.AddInt32(&c.inflight, -1)
+	for {
+		max := atomic.LoadInt32(&c.max)
+		if n <= max || atomic.CompareAndSwapInt32(&c.max, max, n) {
+			break
+		}
+	}
+
+	time.Sleep(c.Delay)
+	if c.Handler == nil {
+		return nil
+	}
+	return c.Handler(req, rsp)
+}
+
+// Calls returns the calls made
+func (c *Client) Calls() int {
+	return int(atomic.LoadInt32(&c.calls))
+}
+
+// MaxInFlight returns the most calls in flight at once since the last Reset
+func (c *Client) MaxInFlight() int {
+	return int(atomic.LoadInt32(&c.max))
+}
+
+// Reset sets the counts back to zero
+func (c *Client) Reset() {
+	atomic.StoreInt32(&c.calls, 0)
+	atomic.StoreInt32(&c.max, 0)
+}
+
+// Call calls endpoint of test.service with body
+func Call(c client.Client, endpoint string, body, rsp interface{}, opts ...client.CallOption) error {
+	req := c.NewRequest("test.service", endpoint, body)
+	return c.Call(context.Background(), req, rsp, opts...)
+}
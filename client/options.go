@@ -8,6 +8,7 @@ import (
 	"common/registry"
 	"common/selector"
 	"common/transport"
+	"common/transport/pool"
 	"context"
 	"time"
 )
@@ -32,6 +33,8 @@ type Options struct {
 	// Connection Pool
 	PoolSize int
 	PoolTTL  time.Duration
+	// Pool replaces the pool built from PoolSize and PoolTTL
+	Pool pool.Pool
 
 	// Response cache
 	Cache rcache.Cache
@@ -68,6 +71,22 @@ type CallOptions struct {
 	ServiceToken bool
 	// Duration to cache the response for
 	CacheExpiry time.Duration
+	// Content-Encoding used for bodies above the threshold of
+	// the compressor, e.g. gzip, zstd or snappy
+	Compression string
+	// Idempotent calls may be sent more than once, e.g. hedged
+	Idempotent bool
+	// Delay after which idempotent calls are sent to another node
+	HedgeDelay time.Duration
+	// Latency percentile of the endpoint used as the hedge delay
+	HedgePercentile float64
+	// Number of hedged copies sent, 1 when not set
+	MaxHedges int
+	// Retry policies of the services overriding the retry options
+	// of the client, the options of a call override them
+	Policies PolicyFunc
+	// Budget shared by the retries and hedged copies of all calls
+	Budget *RetryBudget
 
 	// Middleware for low level call func
 	CallWrappers []CallWrapper
@@ -161,6 +180,14 @@ func PoolTTL(d time.Duration) Option {
 	}
 }
 
+// Pool sets the connection pool used for calls, e.g. one created with
+// health checks and limits whose Stats are exported as metrics.
+func Pool(p pool.Pool) Option {
+	return func(o *Options) {
+		o.Pool = p
+	}
+}
+
 // Registry to find nodes for a given service
 func Registry(r registry.Registry) Option {
 	return func(o *Options) {
@@ -221,6 +248,22 @@ func Retry(fn RetryFunc) Option {
 	}
 }
 
+// RetryPolicies sets the source of the per service retry policies,
+// e.g. RegistryPolicies, read on each call
+func RetryPolicies(fn PolicyFunc) Option {
+	return func(o *Options) {
+		o.CallOptions.Policies = fn
+	}
+}
+
+// Budget limits the retries and hedged copies of the client to a
+// ratio of the successful calls
+func Budget(b *RetryBudget) Option {
+	return func(o *Options) {
+		o.CallOptions.Budget = b
+	}
+}
+
 // The request timeout.
 // Should this be a Call Option?
 func RequestTimeout(d time.Duration) Option {
@@ -272,6 +315,12 @@ func WithSelectOption(so ...selector.SelectOption) CallOption {
 	}
 }
 
+// WithHashKey is a CallOption which routes calls with the same key,
+// e.g. a session id, to the same node
+func WithHashKey(key string) CallOption {
+	return WithSelectOption(selector.WithHashKey(key))
+}
+
 // WithCallWrapper is a CallOption which adds to the existing CallFunc wrappers
 func WithCallWrapper(cw ...CallWrapper) CallOption {
 	return func(o *CallOptions) {
@@ -303,6 +352,22 @@ func WithRetries(i int) CallOption {
 	}
 }
 
+// WithRetryPolicies is a CallOption which overrides that which
+// set in Options.CallOptions
+func WithRetryPolicies(fn PolicyFunc) CallOption {
+	return func(o *CallOptions) {
+		o.Policies = fn
+	}
+}
+
+// WithBudget is a CallOption which overrides that which
+// set in Options.CallOptions
+func WithBudget(b *RetryBudget) CallOption {
+	return func(o *CallOptions) {
+		o.Budget = b
+	}
+}
+
 // WithRequestTimeout is a CallOption which overrides that which
 // set in Options.CallOptions
 func WithRequestTimeout(d time.Duration) CallOption {
@@ -342,6 +407,49 @@ func WithCache(c time.Duration) CallOption {
 	}
 }
 
+// WithCompression is a CallOption which asks for a compressed response
+// with the named compressor, the request is compressed the same way when
+// the node advertises the encoding
+func WithCompression(name string) CallOption {
+	return func(o *CallOptions) {
+		o.Compression = name
+	}
+}
+
+// Idempotent is a CallOption which marks the call as safe to send more
+// than once, only idempotent calls are hedged
+func Idempotent() CallOption {
+	return func(o *CallOptions) {
+		o.Idempotent = true
+	}
+}
+
+// WithHedge is a CallOption which sends a copy of idempotent calls to
+// another node when no response came back within d, the first successful
+// response is taken and the other calls cancelled
+func WithHedge(d time.Duration) CallOption {
+	return func(o *CallOptions) {
+		o.HedgeDelay = d
+	}
+}
+
+// WithHedgePercentile is a CallOption which hedges idempotent calls after
+// the p percentile, e.g. 0.95, of the recent latency of the endpoint. The
+// delay of WithHedge is used until enough calls were seen.
+func WithHedgePercentile(p float64) CallOption {
+	return func(o *CallOptions) {
+		o.HedgePercentile = p
+	}
+}
+
+// WithMaxHedges is a CallOption which sets the number of hedged copies
+// sent for a call, each after another delay
+func WithMaxHedges(n int) CallOption {
+	return func(o *CallOptions) {
+		o.MaxHedges = n
+	}
+}
+
 func WithMessageContentType(ct string) MessageOption {
 	return func(o *MessageOptions) {
 		o.ContentType = ct
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"common/log/log"
+	"common/rcache"
+)
+
+const (
+	// RetryPolicyKey is the service or endpoint metadata holding the
+	// json retry policy, e.g.
+	// {"max_attempts": 3, "codes": [500, 503], "backoff": "50ms", "max_backoff": "1s"}
+	RetryPolicyKey = "retry_policy"
+)
+
+// PolicyFunc returns the retry policy of an endpoint, nil when it has none
+type PolicyFunc func(service, endpoint string) *RetryPolicy
+
+// RetryPolicy overrides the retry options of the calls to a service
+// or endpoint, the options left empty are kept.
+type RetryPolicy struct {
+	// MaxAttempts of a call, the first one included
+	MaxAttempts int
+	// Codes are the status codes retried
+	Codes []int32
+	// Backoff before the first retry, doubled for each following
+	// retry up to MaxBackoff and jittered
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+type retryPolicy struct {
+	MaxAttempts int     `json:"max_attempts"`
+	Codes       []int32 `json:"codes"`
+	Backoff     string  `json:"backoff"`
+	MaxBackoff  string  `json:"max_backoff"`
+}
+
+// ParseRetryPolicy parses a json retry policy, see RetryPolicyKey
+func ParseRetryPolicy(s string) (*RetryPolicy, error) {
+	var rp retryPolicy
+	if err := json.Unmarshal([]byte(s), &rp); err != nil {
+		return nil, err
+	}
+
+	p := &RetryPolicy{
+		MaxAttempts: rp.MaxAttempts,
+		Codes:       rp.Codes,
+	}
+
+	var err error
+	if len(rp.Backoff) > 0 {
+		if p.Backoff, err = time.ParseDuration(rp.Backoff); err != nil {
+			return nil, err
+		}
+	}
+	if len(rp.MaxBackoff) > 0 {
+		if p.MaxBackoff, err = time.ParseDuration(rp.MaxBackoff); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Apply returns the call options with the policy applied
+func (p *RetryPolicy) Apply(opts CallOptions) CallOptions {
+	if p.MaxAttempts > 0 {
+		opts.Retries = p.MaxAttempts - 1
+	}
+	if len(p.Codes) > 0 {
+		opts.Retry = p.retry
+	}
+	if p.Backoff > 0 {
+		opts.Backoff = p.backoff
+	}
+	return opts
+}
+
+func (p *RetryPolicy) retry(ctx context.Context, req Request, statusCode int) (bool, error) {
+	for _, code := range p.Codes {
+		if int(code) == statusCode {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *RetryPolicy) backoff(ctx context.Context, req Request, attempts int) (time.Duration, error) {
+	if attempts == 0 {
+		return 0, nil
+	}
+
+	d := p.Backoff
+	for i := 1; i < attempts && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return jitter(d), nil
+}
+
+// policies caches the policies parsed from their json, broken
+// policies are logged once and ignored
+type policies struct {
+	sync.Mutex
+	parsed map[string]*RetryPolicy
+}
+
+func (p *policies) parse(s string) *RetryPolicy {
+	p.Lock()
+	defer p.Unlock()
+
+	if rp, ok := p.parsed[s]; ok {
+		return rp
+	}
+
+	rp, err := ParseRetryPolicy(s)
+	if err != nil {
+		log.Errorf("invalid retry policy %s: %v", s, err)
+	}
+
+	// the policies of services going away are dropped eventually
+	if len(p.parsed) >= 1024 {
+		p.parsed = make(map[string]*RetryPolicy)
+	}
+	p.parsed[s] = rp
+
+	return rp
+}
+
+// ParsePolicies returns a PolicyFunc parsing the json policies returned
+// by fn, used by sources whose policies are read on each call
+func ParsePolicies(fn func(service, endpoint string) string) PolicyFunc {
+	p := &policies{parsed: make(map[string]*RetryPolicy)}
+
+	return func(service, endpoint string) *RetryPolicy {
+		s := fn(service, endpoint)
+		if len(s) == 0 {
+			return nil
+		}
+		return p.parse(s)
+	}
+}
+
+// RegistryPolicies reads the policies from the RetryPolicyKey metadata of
+// the registered endpoints and services, the policy of an endpoint comes
+// first. Changes are picked up as the cache is updated.
+func RegistryPolicies(c rcache.Cache) PolicyFunc {
+	return ParsePolicies(func(service, endpoint string) string {
+		services, err := c.GetService(service)
+		if err != nil {
+			return ""
+		}
+
+		for _, s := range services {
+			for _, ep := range s.Endpoints {
+				if ep.Name == endpoint && len(ep.Metadata[RetryPolicyKey]) > 0 {
+					return ep.Metadata[RetryPolicyKey]
+				}
+			}
+		}
+
+		for _, s := range services {
+			if len(s.Metadata[RetryPolicyKey]) > 0 {
+				return s.Metadata[RetryPolicyKey]
+			}
+		}
+
+		return ""
+	})
+}
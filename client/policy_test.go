@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	merrors "common/errors"
+	"common/rcache"
+	"common/registry"
+	"common/registry/memory"
+	"common/selector"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p, err := ParseRetryPolicy(`{"max_attempts": 3, "codes": [503], "backoff": "10ms", "max_backoff": "30ms"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempts, max := range []time.Duration{0, 10, 20, 30, 30} {
+		max *= time.Millisecond
+		d, _ := p.backoff(context.Background(), nil, attempts)
+		if d > max || d < max/2 {
+			t.Fatalf("backoff %v of attempt %d not within %v", d, attempts, max)
+		}
+	}
+
+	if _, err := ParseRetryPolicy(`{"backoff": "soon"}`); err == nil {
+		t.Fatal("expected an error parsing an invalid backoff")
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := NewRetryBudget(0.5, 1)
+
+	if !b.Withdraw() || b.Withdraw() {
+		t.Fatal("expected the min retries only")
+	}
+
+	b.Deposit()
+	b.Deposit()
+	if !b.Withdraw() || b.Withdraw() {
+		t.Fatal("expected a retry per two successful calls")
+	}
+}
+
+func TestRegistryPolicies(t *testing.T) {
+	r := memory.NewRegistry()
+	service := &registry.Service{
+		Name:     "test.policy",
+		Version:  "1.0.0",
+		Metadata: map[string]string{RetryPolicyKey: `{"max_attempts": 3, "codes": [503]}`},
+		Endpoints: []*registry.Endpoint{
+			{Name: "Test.Once", Metadata: map[string]string{RetryPolicyKey: `{"max_attempts": 1}`}},
+		},
+		Nodes: []*registry.Node{{Id: "a", Address: "10.0.0.1", Port: 8080}},
+	}
+	if err := r.Register(service); err != nil {
+		t.Fatal(err)
+	}
+
+	c := rcache.New(r)
+	defer c.Stop()
+
+	// every attempt fails as unavailable
+	var attempts int
+	stub := func(CallFunc) CallFunc {
+		return func(ctx context.Context, node *registry.Node, req Request, rsp interface{}, opts CallOptions) error {
+			attempts++
+			return merrors.ServiceUnavailable("test.policy", "unavailable")
+		}
+	}
+
+	cl := NewClient(
+		Selector(selector.NewSelector(selector.Registry(r))),
+		WrapCall(stub),
+		Backoff(func(context.Context, Request, int) (time.Duration, error) { return 0, nil }),
+		RetryPolicies(RegistryPolicies(c)),
+	)
+
+	for endpoint, want := range map[string]int{"Test.Hello": 3, "Test.Once": 1} {
+		attempts = 0
+		req := cl.NewRequest("test.policy", endpoint, "John")
+		if err := cl.Call(context.Background(), req, new(string)); err == nil {
+			t.Fatal("expected an error")
+		}
+		if attempts != want {
+			t.Fatalf("%s attempted %d times, want %d", endpoint, attempts, want)
+		}
+	}
+
+	// the options of the call override the policy
+	attempts = 0
+	req := cl.NewRequest("test.policy", "Test.Hello", "John")
+	if err := cl.Call(context.Background(), req, new(string), WithRetries(0)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts != 1 {
+		t.Fatalf("attempted %d times without retries", attempts)
+	}
+
+	// the budget stops the retries
+	attempts = 0
+	if err := cl.Call(context.Background(), req, new(string), WithBudget(NewRetryBudget(0, 1))); err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts != 2 {
+		t.Fatalf("attempted %d times within the budget", attempts)
+	}
+}
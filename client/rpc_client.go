@@ -6,12 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"common/broker"
 	"common/codec"
 	raw "common/codec/bytes"
+	"common/codec/compress"
+	merrors "common/errors"
 	"common/registry"
 	"common/selector"
 	"common/transport"
@@ -29,21 +32,27 @@ type rpcClient struct {
 	once atomic.Value
 	opts Options
 	pool pool.Pool
+	// the pool was built by the client, not passed in
+	ownPool bool
 }
 
 func newRpcClient(opt ...Option) Client {
 	opts := NewOptions(opt...)
 
-	p := pool.NewPool(
-		pool.Size(opts.PoolSize),
-		pool.TTL(opts.PoolTTL),
-		pool.Transport(opts.Transport),
-	)
+	p := opts.Pool
+	if p == nil {
+		p = pool.NewPool(
+			pool.Size(opts.PoolSize),
+			pool.TTL(opts.PoolTTL),
+			pool.Transport(opts.Transport),
+		)
+	}
 
 	rc := &rpcClient{
-		opts: opts,
-		pool: p,
-		seq:  0,
+		opts:    opts,
+		pool:    p,
+		ownPool: opts.Pool == nil,
+		seq:     0,
 	}
 	rc.once.Store(false)
 
@@ -67,9 +76,18 @@ func (r *rpcClient) newCodec(contentType string) (codec.NewCodec, error) {
 	return nil, fmt.Errorf("unsupported Content-Type: %s", contentType)
 }
 
+// nodeAddress returns the dial address of a node, nodes built from
+// WithAddress already carry the port in the address.
+func nodeAddress(node *registry.Node) string {
+	if node.Port == 0 {
+		return node.Address
+	}
+	return node.Address + ":" + strconv.Itoa(node.Port)
+}
+
 // 基于json形式.
 func (r *rpcClient) call(ctx context.Context, node *registry.Node, req Request, resp interface{}, opts CallOptions) error {
-	address := node.Address + ":" + strconv.Itoa(node.Port)
+	address := nodeAddress(node)
 	msg := &transport.Message{
 		Header: make(map[string]string),
 	}
@@ -93,6 +111,11 @@ func (r *rpcClient) call(ctx context.Context, node *registry.Node, req Request,
 	// set the accept header
 	msg.Header["Accept"] = req.ContentType()
 
+	// ask for compressed responses
+	if len(opts.Compression) > 0 {
+		msg.Header["Accept-Encoding"] = opts.Compression
+	}
+
 	// setup old protocol
 	cf := setupProtocol(msg, node)
 
@@ -101,7 +124,7 @@ func (r *rpcClient) call(ctx context.Context, node *registry.Node, req Request,
 		var err error
 		cf, err = r.newCodec(req.ContentType())
 		if err != nil {
-			return errors.New("go.micro.client:" + err.Error())
+			return merrors.InternalServerError("go.micro.client", "%v", err)
 		}
 	}
 
@@ -113,13 +136,13 @@ func (r *rpcClient) call(ctx context.Context, node *registry.Node, req Request,
 		dOpts = append(dOpts, transport.WithTimeout(opts.DialTimeout))
 	}
 
-	c, err := r.pool.Get(address, dOpts...)
+	c, err := r.pool.Get(ctx, address, dOpts...)
 	if err != nil {
-		return errors.New("go.micro.client: connection error: " + err.Error())
+		return merrors.InternalServerError("go.micro.client", "connection error: %v", err)
 	}
 
 	seq := atomic.AddUint64(&r.seq, 1) - 1
-	codec := newRpcCodec(msg, c, cf, "")
+	codec := newRpcCodec(msg, c, cf, "", requestEncoding(opts.Compression, node))
 
 	rsp := &rpcResponse{
 		socket: c,
@@ -145,20 +168,22 @@ func (r *rpcClient) call(ctx context.Context, node *registry.Node, req Request,
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				ch <- errors.New(fmt.Sprintf("go.micro.client panic recovered: %v", r))
+				ch <- merrors.InternalServerError("go.micro.client", "panic recovered: %v", r)
 			}
 		}()
 
 		// send request
 		if err := stream.Send(req.Body()); err != nil {
-			err := errors.New("rpcClient: stream.Send: " + fmt.Sprintf("%v", err))
-			ch <- err
+			ch <- merrors.InternalServerError("go.micro.client", "stream.Send: %v", err)
 			return
 		}
 
 		// recv request
+		// errors returned by the server are passed on as they are
 		if err := stream.Recv(resp); err != nil {
-			err := errors.New("rpcClient: stream.Recv: " + fmt.Sprintf("%v", err))
+			if _, ok := err.(*merrors.Error); !ok {
+				err = merrors.InternalServerError("go.micro.client", "stream.Recv: %v", err)
+			}
 			ch <- err
 			return
 		}
@@ -173,7 +198,7 @@ func (r *rpcClient) call(ctx context.Context, node *registry.Node, req Request,
 	case err := <-ch:
 		return err
 	case <-ctx.Done():
-		grr = errors.New("rpcClient: go.micro.client " + fmt.Sprintf("%v", ctx.Err()))
+		grr = merrors.Timeout("go.micro.client", "%v", ctx.Err())
 	}
 
 	// set the stream error
@@ -189,7 +214,7 @@ func (r *rpcClient) call(ctx context.Context, node *registry.Node, req Request,
 }
 
 func (r *rpcClient) stream(ctx context.Context, node *registry.Node, req Request, opts CallOptions) (Stream, error) {
-	address := node.Address
+	address := nodeAddress(node)
 
 	msg := &transport.Message{
 		Header: make(map[string]string),
@@ -211,6 +236,11 @@ func (r *rpcClient) stream(ctx context.Context, node *registry.Node, req Request
 	// set the accept header
 	msg.Header["Accept"] = req.ContentType()
 
+	// ask for compressed responses
+	if len(opts.Compression) > 0 {
+		msg.Header["Accept-Encoding"] = opts.Compression
+	}
+
 	// set old codecs
 	cf := setupProtocol(msg, node)
 
@@ -219,7 +249,7 @@ func (r *rpcClient) stream(ctx context.Context, node *registry.Node, req Request
 		var err error
 		cf, err = r.newCodec(req.ContentType())
 		if err != nil {
-			return nil, errors.New("go.micro.client " + err.Error())
+			return nil, merrors.InternalServerError("go.micro.client", "%v", err)
 		}
 	}
 
@@ -233,7 +263,7 @@ func (r *rpcClient) stream(ctx context.Context, node *registry.Node, req Request
 
 	c, err := r.opts.Transport.Dial(address, dOpts...)
 	if err != nil {
-		return nil, errors.New("go.micro.client connection error: " + err.Error())
+		return nil, merrors.InternalServerError("go.micro.client", "connection error: %v", err)
 	}
 
 	// increment the sequence number
@@ -241,7 +271,7 @@ func (r *rpcClient) stream(ctx context.Context, node *registry.Node, req Request
 	id := fmt.Sprintf("%v", seq)
 
 	// create codec with stream id
-	codec := newRpcCodec(msg, c, cf, id)
+	codec := newRpcCodec(msg, c, cf, id, requestEncoding(opts.Compression, node))
 
 	rsp := &rpcResponse{
 		socket: c,
@@ -281,7 +311,7 @@ func (r *rpcClient) stream(ctx context.Context, node *registry.Node, req Request
 	case err := <-ch:
 		grr = err
 	case <-ctx.Done():
-		grr = errors.New("go.micro.client " + fmt.Sprintf("%v", ctx.Err()))
+		grr = merrors.Timeout("go.micro.client", "%v", ctx.Err())
 	}
 
 	if grr != nil {
@@ -298,30 +328,57 @@ func (r *rpcClient) stream(ctx context.Context, node *registry.Node, req Request
 	return stream, nil
 }
 
+// requestEncoding returns the encoding of the request body, requests are
+// only compressed when the node advertises the encoding
+func requestEncoding(name string, node *registry.Node) string {
+	if len(name) == 0 {
+		return ""
+	}
+	for _, enc := range strings.Split(node.Metadata[compress.MetadataKey], ",") {
+		if strings.TrimSpace(enc) == name {
+			return name
+		}
+	}
+	return ""
+}
+
 func (r *rpcClient) Init(opts ...Option) error {
 	size := r.opts.PoolSize
 	ttl := r.opts.PoolTTL
 	tr := r.opts.Transport
+	p := r.opts.Pool
 
 	for _, o := range opts {
 		o(&r.opts)
 	}
 
-	// update pool configuration if the options changed
-	if size != r.opts.PoolSize || ttl != r.opts.PoolTTL || tr != r.opts.Transport {
-		// close existing pool
-		r.pool.Close()
-		// create new pool
-		r.pool = pool.NewPool(
+	switch {
+	// use the pool passed in
+	case r.opts.Pool != nil && r.opts.Pool != p:
+		r.setPool(r.opts.Pool, false)
+	// the pool options changed, they apply to a pool of the client's own
+	case size != r.opts.PoolSize || ttl != r.opts.PoolTTL || tr != r.opts.Transport,
+		r.opts.Pool == nil && !r.ownPool:
+		r.opts.Pool = nil
+		r.setPool(pool.NewPool(
 			pool.Size(r.opts.PoolSize),
 			pool.TTL(r.opts.PoolTTL),
 			pool.Transport(r.opts.Transport),
-		)
+		), true)
 	}
 
 	return nil
 }
 
+// setPool replaces the pool, closing the old one only if the client built it
+func (r *rpcClient) setPool(p pool.Pool, own bool) {
+	if r.ownPool {
+		r.pool.Close()
+	}
+	r.pool = p
+	r.ownPool = own
+}
+
 func (r *rpcClient) Options() Options {
 	return r.opts
 }
@@ -355,21 +412,41 @@ func (r *rpcClient) next(request Request, opts CallOptions) (selector.Next, erro
 	next, err := r.opts.Selector.Select(service, opts.SelectOptions...)
 	if err != nil {
 		if err == selector.ErrNotFound {
-			return nil, errors.New("go.micro.client service " + service + ":" + err.Error())
+			return nil, merrors.ServiceUnavailable("go.micro.client", "service %s: %v", service, err)
 		}
-		return nil, errors.New("go.micro.client error selecting " + service + " node: " + err.Error())
+		return nil, merrors.InternalServerError("go.micro.client", "error selecting %s node: %v", service, err)
 	}
 
 	return next, nil
 }
 
-func (r *rpcClient) Call(ctx context.Context, request Request, response interface{}, opts ...CallOption) error {
-	// make a copy of call opts
+// callOptions returns a copy of the call options, the policy of the service
+// overrides the retry options of the client and the options of the call
+// override the policy
+func (r *rpcClient) callOptions(request Request, opts []CallOption) CallOptions {
 	callOpts := r.opts.CallOptions
 	for _, opt := range opts {
 		opt(&callOpts)
 	}
 
+	if callOpts.Policies == nil {
+		return callOpts
+	}
+	p := callOpts.Policies(request.Service(), request.Endpoint())
+	if p == nil {
+		return callOpts
+	}
+
+	callOpts = p.Apply(r.opts.CallOptions)
+	for _, opt := range opts {
+		opt(&callOpts)
+	}
+	return callOpts
+}
+
+func (r *rpcClient) Call(ctx context.Context, request Request, response interface{}, opts ...CallOption) error {
+	callOpts := r.callOptions(request, opts)
+
 	next, err := r.next(request, callOpts)
 	if err != nil {
 		return err
@@ -392,7 +469,7 @@ func (r *rpcClient) Call(ctx context.Context, request Request, response interfac
 	// should we noop right here?
 	select {
 	case <-ctx.Done():
-		return errors.New("go.micro.client" + fmt.Sprintf("%v", ctx.Err()))
+		return merrors.Timeout("go.micro.client", "%v", ctx.Err())
 	default:
 	}
 
@@ -404,12 +481,11 @@ func (r *rpcClient) Call(ctx context.Context, request Request, response interfac
 		rcall = callOpts.CallWrappers[i-1](rcall)
 	}
 
-	// return errors.New("go.micro.client", "request timeout", 408)
 	call := func(i int) error {
 		// call backoff first. Someone may want an initial start delay
 		t, err := callOpts.Backoff(ctx, request, i)
 		if err != nil {
-			return errors.New("go.micro.client backoff error: " + err.Error())
+			return merrors.InternalServerError("go.micro.client", "backoff error: %v", err)
 		}
 
 		// only sleep if greater than 0
@@ -422,9 +498,14 @@ func (r *rpcClient) Call(ctx context.Context, request Request, response interfac
 		service := request.Service()
 		if err != nil {
 			if err == selector.ErrNotFound {
-				return errors.New("go.micro.client service " + service + ":" + err.Error())
+				return merrors.ServiceUnavailable("go.micro.client", "service %s: %v", service, err)
 			}
-			return errors.New("go.micro.client error getting next " + service + " node: " + err.Error())
+			return merrors.InternalServerError("go.micro.client", "error getting next %s node: %v", service, err)
+		}
+
+		// idempotent calls may be raced against other nodes
+		if hedging(response, callOpts) {
+			return r.hedge(ctx, next, node, request, response, callOpts, rcall)
 		}
 
 		// make the call
@@ -451,14 +532,17 @@ func (r *rpcClient) Call(ctx context.Context, request Request, response interfac
 
 		select {
 		case <-ctx.Done():
-			return errors.New("go.micro.client " + fmt.Sprintf("call timeout: %v", ctx.Err()))
+			return merrors.Timeout("go.micro.client", "call timeout: %v", ctx.Err())
 		case err := <-ch:
 			// if the call succeeded lets bail early
 			if err == nil {
+				if callOpts.Budget != nil {
+					callOpts.Budget.Deposit()
+				}
 				return nil
 			}
 
-			retry, rerr := callOpts.Retry(ctx, request, 500)
+			retry, rerr := callOpts.Retry(ctx, request, int(merrors.FromError(err).Code))
 			if rerr != nil {
 				return rerr
 			}
@@ -467,6 +551,11 @@ func (r *rpcClient) Call(ctx context.Context, request Request, response interfac
 				return err
 			}
 
+			// retries beyond the budget would add to the load of the service
+			if i < retries && callOpts.Budget != nil && !callOpts.Budget.Withdraw() {
+				return err
+			}
+
 			gerr = err
 		}
 	}
@@ -475,11 +564,7 @@ func (r *rpcClient) Call(ctx context.Context, request Request, response interfac
 }
 
 func (r *rpcClient) Stream(ctx context.Context, request Request, opts ...CallOption) (Stream, error) {
-	// make a copy of call opts
-	callOpts := r.opts.CallOptions
-	for _, opt := range opts {
-		opt(&callOpts)
-	}
+	callOpts := r.callOptions(request, opts)
 
 	next, err := r.next(request, callOpts)
 	if err != nil {
@@ -489,7 +574,7 @@ func (r *rpcClient) Stream(ctx context.Context, request Request, opts ...CallOpt
 	// should we noop right here?
 	select {
 	case <-ctx.Done():
-		return nil, errors.New("go.micro.client " + fmt.Sprintf("%v", ctx.Err()))
+		return nil, merrors.Timeout("go.micro.client", "%v", ctx.Err())
 	default:
 	}
 
@@ -497,7 +582,7 @@ func (r *rpcClient) Stream(ctx context.Context, request Request, opts ...CallOpt
 		// call backoff first. Someone may want an initial start delay
 		t, err := callOpts.Backoff(ctx, request, i)
 		if err != nil {
-			return nil, errors.New("go.micro.client backoff error: " + err.Error())
+			return nil, merrors.InternalServerError("go.micro.client", "backoff error: %v", err)
 		}
 
 		// only sleep if greater than 0
@@ -509,9 +594,9 @@ func (r *rpcClient) Stream(ctx context.Context, request Request, opts ...CallOpt
 		service := request.Service()
 		if err != nil {
 			if err == selector.ErrNotFound {
-				return nil, errors.New(fmt.Sprintf("go.micro.client service %s: %s", service, err.Error()))
+				return nil, merrors.ServiceUnavailable("go.micro.client", "service %s: %v", service, err)
 			}
-			return nil, errors.New(fmt.Sprintf("go.micro.client error getting next %s node: %s", service, err.Error()))
+			return nil, merrors.InternalServerError("go.micro.client", "error getting next %s node: %v", service, err)
 		}
 
 		stream, err := r.stream(ctx, node, request, callOpts)
@@ -543,14 +628,17 @@ func (r *rpcClient) Stream(ctx context.Context, request Request, opts ...CallOpt
 
 		select {
 		case <-ctx.Done():
-			return nil, errors.New("go.micro.client " + fmt.Sprintf("call timeout: %v", ctx.Err()))
+			return nil, merrors.Timeout("go.micro.client", "call timeout: %v", ctx.Err())
 		case rsp := <-ch:
 			// if the call succeeded lets bail early
 			if rsp.err == nil {
+				if callOpts.Budget != nil {
+					callOpts.Budget.Deposit()
+				}
 				return rsp.stream, nil
 			}
 
-			retry, rerr := callOpts.Retry(ctx, request, 500)
+			retry, rerr := callOpts.Retry(ctx, request, int(merrors.FromError(rsp.err).Code))
 			if rerr != nil {
 				return nil, rerr
 			}
@@ -559,6 +647,11 @@ func (r *rpcClient) Stream(ctx context.Context, request Request, opts ...CallOpt
 				return nil, rsp.err
 			}
 
+			// retries beyond the budget would add to the load of the service
+			if i < retries && callOpts.Budget != nil && !callOpts.Budget.Withdraw() {
+				return nil, rsp.err
+			}
+
 			grr = rsp.err
 		}
 	}
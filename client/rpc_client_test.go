@@ -0,0 +1,42 @@
+package client
+
+import (
+	"testing"
+
+	"common/transport/pool"
+)
+
+// testPool is a pool passed in by the caller, counting Close
+type testPool struct {
+	pool.Pool
+	closed int
+}
+
+func (p *testPool) Close() error {
+	p.closed++
+	return nil
+}
+
+func TestClientInitPool(t *testing.T) {
+	p1 := &testPool{Pool: pool.NewPool()}
+	c := newRpcClient(Pool(p1)).(*rpcClient)
+
+	// the options of the pool are applied to a pool of the client's own
+	c.Init(PoolSize(5))
+	if c.pool == p1 || !c.ownPool || c.opts.PoolSize != 5 {
+		t.Fatal("expected a pool of the client's own")
+	}
+
+	p2 := &testPool{Pool: pool.NewPool()}
+	c.Init(Pool(p2))
+	if c.pool != p2 || c.ownPool {
+		t.Fatal("expected the pool passed in")
+	}
+
+	// the pools passed in are never closed by the client
+	c.Init(Pool(p1))
+	c.Init(PoolTTL(c.opts.PoolTTL * 2))
+	if p1.closed != 0 || p2.closed != 0 {
+		t.Fatalf("closed pools passed in %d and %d times", p1.closed, p2.closed)
+	}
+}
@@ -6,7 +6,11 @@ import (
 
 	"common/codec"
 	raw "common/codec/bytes"
+	"common/codec/compress"
 	"common/codec/json"
+	"common/codec/jsonrpc"
+	"common/codec/msgpack"
+	"common/codec/proto"
 	"common/registry"
 	"common/transport"
 )
@@ -15,14 +19,6 @@ const (
 	lastStreamResponseError = "EOS"
 )
 
-// serverError represents an error that has been returned from
-// the remote side of the RPC connection.
-type serverError string
-
-func (e serverError) Error() string {
-	return string(e)
-}
-
 // errShutdown holds the specific error for closing/closed connections
 var (
 	errShutdown = errs.New("connection is shut down")
@@ -38,6 +34,8 @@ type rpcCodec struct {
 
 	// signify if its a stream
 	stream string
+	// Content-Encoding of the request, empty when sent as is
+	encoding string
 }
 
 type readWriteCloser struct {
@@ -48,9 +46,14 @@ type readWriteCloser struct {
 var (
 	DefaultContentType = "application/json"
 
-	// only support json
+	// codecs keyed by Content-Type, proto-rpc is the protobuf content type
+	// used when talking to nodes without protocol metadata
 	DefaultCodecs = map[string]codec.NewCodec{
-		"application/json": json.NewCodec,
+		"application/json":      json.NewCodec,
+		"application/json-rpc":  jsonrpc.NewCodec,
+		"application/protobuf":  proto.NewCodec,
+		"application/proto-rpc": proto.NewCodec,
+		"application/msgpack":   msgpack.NewCodec,
 	}
 )
 
@@ -134,17 +137,18 @@ func setupProtocol(msg *transport.Message, node *registry.Node) codec.NewCodec {
 	return DefaultCodecs[msg.Header["Content-Type"]]
 }
 
-func newRpcCodec(req *transport.Message, client transport.Client, c codec.NewCodec, stream string) codec.Codec {
+func newRpcCodec(req *transport.Message, client transport.Client, c codec.NewCodec, stream, encoding string) codec.Codec {
 	rwc := &readWriteCloser{
 		wbuf: bytes.NewBuffer(nil),
 		rbuf: bytes.NewBuffer(nil),
 	}
 	r := &rpcCodec{
-		buf:    rwc,
-		client: client,
-		codec:  c(rwc),
-		req:    req,
-		stream: stream,
+		buf:      rwc,
+		client:   client,
+		codec:    c(rwc),
+		req:      req,
+		stream:   stream,
+		encoding: encoding,
 	}
 	return r
 }
@@ -181,6 +185,18 @@ func (c *rpcCodec) Write(m *codec.Message, body interface{}) error {
 		}
 	}
 
+	// compress the body with the encoding the server accepts
+	if len(m.Body) > 0 && len(c.encoding) > 0 {
+		b, enc, err := compress.Compress(c.encoding, c.req.Header["Content-Type"], m.Body)
+		if err != nil {
+			return errs.New("go.micro.client.codec:" + err.Error())
+		}
+		if len(enc) > 0 {
+			m.Body = b
+			m.Header["Content-Encoding"] = enc
+		}
+	}
+
 	// create new transport message
 	msg := transport.Message{
 		Header: m.Header,
@@ -203,14 +219,19 @@ func (c *rpcCodec) ReadHeader(m *codec.Message, r codec.MessageType) error {
 		return errs.New("go.micro.client.transport:" + err.Error())
 	}
 
+	body, err := compress.Decompress(tm.Header["Content-Encoding"], tm.Body)
+	if err != nil {
+		return errs.New("go.micro.client.codec:" + err.Error())
+	}
+
 	c.buf.rbuf.Reset()
-	c.buf.rbuf.Write(tm.Body)
+	c.buf.rbuf.Write(body)
 
 	// set headers from transport
 	m.Header = tm.Header
 
 	// read header
-	err := c.codec.ReadHeader(m, r)
+	err = c.codec.ReadHeader(m, r)
 
 	// get headers
 	getHeaders(m)
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"testing"
+
+	"common/codec"
+	"common/codec/compress"
+	"common/registry"
+	"common/transport"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testMessage struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags"`
+}
+
+// echo starts a listener that sends every message it receives back
+func echo(t *testing.T, tr transport.Transport) transport.Listener {
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go l.Accept(func(sock transport.Socket) {
+		defer sock.Close()
+		for {
+			var m transport.Message
+			if err := sock.Recv(&m); err != nil {
+				return
+			}
+			if err := sock.Send(&m); err != nil {
+				return
+			}
+		}
+	})
+
+	return l
+}
+
+func testRoundTrip(t *testing.T, contentType string, in, out interface{}) {
+	tr := transport.NewMemoryTransport()
+	l := echo(t, tr)
+	defer l.Close()
+
+	c, err := tr.Dial(l.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &transport.Message{Header: map[string]string{
+		"Content-Type": contentType,
+	}}
+	cc := newRpcCodec(req, c, DefaultCodecs[contentType], "", "")
+	defer cc.Close()
+
+	if err := cc.Write(&codec.Message{
+		Id:       "1",
+		Type:     codec.Request,
+		Target:   "test.service",
+		Method:   "Test.Echo",
+		Endpoint: "Test.Echo",
+	}, in); err != nil {
+		t.Fatal(err)
+	}
+
+	var m codec.Message
+	if err := cc.ReadHeader(&m, codec.Response); err != nil {
+		t.Fatal(err)
+	}
+	if m.Id != "1" || m.Endpoint != "Test.Echo" {
+		t.Fatalf("unexpected header %+v", m)
+	}
+	if err := cc.ReadBody(out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRpcCodecProto(t *testing.T) {
+	for _, ct := range []string{"application/protobuf", "application/proto-rpc"} {
+		var out wrapperspb.StringValue
+		testRoundTrip(t, ct, wrapperspb.String("hello"), &out)
+		if out.GetValue() != "hello" {
+			t.Fatalf("%s: unexpected value %q", ct, out.GetValue())
+		}
+	}
+}
+
+func TestRpcCodecMsgpack(t *testing.T) {
+	in := &testMessage{Name: "John", Count: 3, Tags: []string{"a", "b"}}
+
+	var out testMessage
+	testRoundTrip(t, "application/msgpack", in, &out)
+	if out.Name != in.Name || out.Count != in.Count || len(out.Tags) != 2 || out.Tags[1] != "b" {
+		t.Fatalf("unexpected message %+v", out)
+	}
+}
+
+func TestRequestEncoding(t *testing.T) {
+	node := &registry.Node{Metadata: map[string]string{compress.MetadataKey: "gzip,snappy"}}
+
+	for name, want := range map[string]string{"gzip": "gzip", "zstd": "", "": ""} {
+		if enc := requestEncoding(name, node); enc != want {
+			t.Fatalf("%q: request encoded with %q, want %q", name, enc, want)
+		}
+	}
+
+	// nodes not advertising encodings get requests as is
+	if enc := requestEncoding("gzip", &registry.Node{}); enc != "" {
+		t.Fatalf("request encoded with %q", enc)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"common/codec"
+	merrors "common/errors"
 )
 
 // Implements the streamer interface
@@ -105,7 +106,7 @@ func (r *rpcStream) Recv(msg interface{}) error {
 		// any subsequent requests will get the ReadResponseBody
 		// error if there is one.
 		if resp.Error != lastStreamResponseError {
-			r.err = serverError(resp.Error)
+			r.err = merrors.Parse(resp.Error)
 		} else {
 			r.err = io.EOF
 		}
@@ -0,0 +1,146 @@
+// Package compress provides the compressors negotiated for message
+// bodies via the Content-Encoding and Accept-Encoding headers.
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// MetadataKey is the node metadata advertising the encodings
+	// a server accepts for request bodies
+	MetadataKey = "accept-encoding"
+)
+
+// Compressor compresses message bodies, String is the name used
+// as the Content-Encoding.
+type Compressor interface {
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+	String() string
+}
+
+var (
+	// DefaultThreshold is the body size in bytes from which
+	// bodies of content types without a threshold are compressed
+	DefaultThreshold = 1024
+
+	// MaxSize is the most bytes a body is decompressed to, the bodies
+	// come from the peers. Compressors read it when they are created.
+	MaxSize = 64 << 20
+
+	ErrTooLarge = errors.New("compress: decompressed body too large")
+
+	mtx         sync.RWMutex
+	compressors = map[string]Compressor{}
+	thresholds  = map[string]int{}
+)
+
+func init() {
+	Register(NewGzip())
+	Register(NewSnappy())
+	Register(NewZstd())
+}
+
+// Register adds a compressor
+func Register(c Compressor) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	compressors[c.String()] = c
+}
+
+// SetThreshold sets the body size in bytes from which bodies of
+// a content type are compressed, smaller bodies are sent as is.
+func SetThreshold(contentType string, threshold int) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	thresholds[contentType] = threshold
+}
+
+// Threshold returns the threshold of a content type
+func Threshold(contentType string) int {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	if t, ok := thresholds[contentType]; ok {
+		return t
+	}
+	return DefaultThreshold
+}
+
+// Encodings returns the names of the registered compressors, as
+// advertised in the MetadataKey of a node
+func Encodings() string {
+	mtx.RLock()
+	defer mtx.RUnlock()
+
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Get returns the compressor registered with name
+func Get(name string) (Compressor, bool) {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// Negotiate returns the first compressor of an Accept-Encoding
+// header we support.
+func Negotiate(accept string) (Compressor, bool) {
+	mtx.RLock()
+	defer mtx.RUnlock()
+
+	for _, name := range strings.Split(accept, ",") {
+		// drop quality values, the order is our preference
+		if i := strings.Index(name, ";"); i >= 0 {
+			name = name[:i]
+		}
+		if c, ok := compressors[strings.TrimSpace(name)]; ok {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// Compress compresses body with the compressor negotiated from accept
+// once it reaches the threshold of its content type. The encoding is
+// empty when the body is returned as is.
+func Compress(accept, contentType string, body []byte) ([]byte, string, error) {
+	if len(accept) == 0 || len(body) < Threshold(contentType) {
+		return body, "", nil
+	}
+
+	c, ok := Negotiate(accept)
+	if !ok {
+		return body, "", nil
+	}
+
+	b, err := c.Compress(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, c.String(), nil
+}
+
+// Decompress returns the plain body of the given Content-Encoding
+func Decompress(encoding string, body []byte) ([]byte, error) {
+	if len(encoding) == 0 || encoding == "identity" {
+		return body, nil
+	}
+
+	c, ok := Get(encoding)
+	if !ok {
+		return nil, fmt.Errorf("unsupported Content-Encoding: %s", encoding)
+	}
+	return c.Decompress(body)
+}
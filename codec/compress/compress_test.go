@@ -0,0 +1,66 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"name":"John"}`), 200)
+
+	for _, name := range []string{"gzip", "snappy", "zstd"} {
+		b, enc, err := Compress("identity, "+name+";q=0.8", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc != name || len(b) >= len(body) {
+			t.Fatalf("%s: body of %d bytes encoded with %q", name, len(b), enc)
+		}
+
+		p, err := Decompress(enc, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, body) {
+			t.Fatalf("%s: body changed in round trip", name)
+		}
+	}
+
+	// small bodies are left alone
+	if b, enc, _ := Compress("gzip", "application/json", []byte("{}")); enc != "" || string(b) != "{}" {
+		t.Fatalf("small body encoded with %q", enc)
+	}
+
+	// thresholds are set per content type
+	SetThreshold("application/protobuf", len(body)+1)
+	defer SetThreshold("application/protobuf", DefaultThreshold)
+	if _, enc, _ := Compress("gzip", "application/protobuf", body); enc != "" {
+		t.Fatalf("body below the protobuf threshold encoded with %q", enc)
+	}
+	if _, enc, _ := Compress("gzip", "application/json", body); enc != "gzip" {
+		t.Fatalf("json body encoded with %q", enc)
+	}
+
+	if _, err := Decompress("br", body); err == nil {
+		t.Fatal("expected error for unsupported encoding")
+	}
+}
+
+func TestDecompressMaxSize(t *testing.T) {
+	max := MaxSize
+	MaxSize = 4096
+	defer func() { MaxSize = max }()
+
+	// bodies growing past the limit are rejected
+	for _, c := range []Compressor{NewGzip(), NewSnappy(), NewZstd()} {
+		for size, err := range map[int]error{MaxSize: nil, MaxSize + 1: ErrTooLarge} {
+			b, cerr := c.Compress(make([]byte, size))
+			if cerr != nil {
+				t.Fatal(cerr)
+			}
+			if _, derr := c.Decompress(b); derr != err {
+				t.Fatalf("%s: body of %d bytes decompressed with %v, want %v", c, size, derr, err)
+			}
+		}
+	}
+}
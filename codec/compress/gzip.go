@@ -0,0 +1,57 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func (g *gzipCompressor) Compress(b []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+
+	w, ok := g.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(buf)
+	} else {
+		w = gzip.NewWriter(buf)
+	}
+	defer g.writers.Put(w)
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	p, err := io.ReadAll(io.LimitReader(r, int64(MaxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(p) > MaxSize {
+		return nil, ErrTooLarge
+	}
+	return p, nil
+}
+
+func (g *gzipCompressor) String() string {
+	return "gzip"
+}
+
+func NewGzip() Compressor {
+	return &gzipCompressor{}
+}
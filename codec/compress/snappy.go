@@ -0,0 +1,30 @@
+package compress
+
+import (
+	"github.com/golang/snappy"
+)
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func (snappyCompressor) Decompress(b []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxSize {
+		return nil, ErrTooLarge
+	}
+	return snappy.Decode(nil, b)
+}
+
+func (snappyCompressor) String() string {
+	return "snappy"
+}
+
+func NewSnappy() Compressor {
+	return snappyCompressor{}
+}
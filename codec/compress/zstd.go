@@ -0,0 +1,52 @@
+package compress
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdCompressor creates its encoder and decoder on first use,
+// both are safe for concurrent use.
+type zstdCompressor struct {
+	once    sync.Once
+	err     error
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		if z.encoder, z.err = zstd.NewWriter(nil); z.err != nil {
+			return
+		}
+		z.decoder, z.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(MaxSize)))
+	})
+	return z.err
+}
+
+func (z *zstdCompressor) Compress(b []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.encoder.EncodeAll(b, nil), nil
+}
+
+func (z *zstdCompressor) Decompress(b []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	p, err := z.decoder.DecodeAll(b, nil)
+	if err == zstd.ErrDecoderSizeExceeded {
+		return nil, ErrTooLarge
+	}
+	return p, err
+}
+
+func (z *zstdCompressor) String() string {
+	return "zstd"
+}
+
+func NewZstd() Compressor {
+	return &zstdCompressor{}
+}
@@ -0,0 +1,378 @@
+// Package jsonrpc provides a JSON-RPC 2.0 codec, the id, method and error
+// of a message are carried in the envelope rather than the headers so
+// services in other languages can be called and serve calls.
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+
+	"common/codec"
+	raw "common/codec/bytes"
+	merrors "common/errors"
+)
+
+const (
+	Version = "2.0"
+)
+
+// Error codes defined by the specification
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+	ServerError    = -32000
+)
+
+var (
+	ErrInvalidVersion = errors.New("jsonrpc: invalid version")
+	ErrEmptyBatch     = errors.New("jsonrpc: empty batch")
+
+	null = json.RawMessage("null")
+)
+
+// Error is the error object of a response
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// newError returns the error object of a message error, typed errors keep
+// their detail as the message and are carried whole in the data
+func newError(err string) *Error {
+	e := new(merrors.Error)
+	if json.Unmarshal([]byte(err), e) != nil || e.Code == 0 {
+		return &Error{Code: ServerError, Message: err}
+	}
+
+	code := ServerError
+	switch e.Code {
+	case 400:
+		code = InvalidParams
+	case 404:
+		code = MethodNotFound
+	}
+
+	return &Error{Code: code, Message: e.Detail, Data: json.RawMessage(err)}
+}
+
+// messageError returns the typed message error of an error object, errors
+// of other services are typed by their code
+func messageError(e *Error) string {
+	re := new(merrors.Error)
+	if json.Unmarshal(e.Data, re) == nil && re.Code != 0 {
+		return re.Error()
+	}
+
+	var status int32 = 500
+	switch e.Code {
+	case ParseError, InvalidRequest, InvalidParams:
+		status = 400
+	case MethodNotFound:
+		status = 404
+	}
+
+	return merrors.New("", e.Message, status).Error()
+}
+
+// envelope is read as the union of a request and a response
+type envelope struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+}
+
+type request struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  interface{}      `json:"params,omitempty"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+}
+
+type response struct {
+	Version string           `json:"jsonrpc"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+	Id      *json.RawMessage `json:"id"`
+}
+
+type Codec struct {
+	Conn    io.ReadWriteCloser
+	Encoder *json.Encoder
+	Decoder *json.Decoder
+
+	// the envelopes of a batch still to be read
+	pending []*envelope
+	// the envelope whose body has not been read
+	body *envelope
+	// the id of the last request read, echoed in the response
+	id *json.RawMessage
+	// the last request read was a notification
+	notify bool
+}
+
+// next returns the next envelope, the requests of a batch are
+// returned one by one.
+func (c *Codec) next() (*envelope, error) {
+	if len(c.pending) > 0 {
+		e := c.pending[0]
+		c.pending = c.pending[1:]
+		return e, nil
+	}
+
+	var data json.RawMessage
+	if err := c.Decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	if reqs, ok, err := Split(data); ok {
+		if err != nil {
+			return nil, err
+		}
+		for _, req := range reqs {
+			var e envelope
+			if err := json.Unmarshal(req, &e); err != nil {
+				return nil, err
+			}
+			c.pending = append(c.pending, &e)
+		}
+		return c.next()
+	}
+
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (c *Codec) read() error {
+	e, err := c.next()
+	if err != nil {
+		return err
+	}
+	if e.Version != Version {
+		return ErrInvalidVersion
+	}
+
+	c.body = e
+	if len(e.Method) > 0 {
+		c.id = e.Id
+		c.notify = e.Id == nil
+	}
+	return nil
+}
+
+func (c *Codec) ReadHeader(m *codec.Message, t codec.MessageType) error {
+	if err := c.read(); err != nil {
+		return err
+	}
+
+	e := c.body
+	if len(e.Method) > 0 {
+		m.Method = e.Method
+		m.Endpoint = e.Method
+	}
+	if e.Id != nil {
+		m.Id = idString(*e.Id)
+	}
+	if e.Error != nil {
+		m.Error = messageError(e.Error)
+	}
+	return nil
+}
+
+func (c *Codec) ReadBody(b interface{}) error {
+	// bodies may be read without a header, e.g. by subscribers
+	if c.body == nil {
+		if err := c.read(); err != nil {
+			return err
+		}
+	}
+
+	e := c.body
+	c.body = nil
+
+	if b == nil {
+		return nil
+	}
+
+	data := e.Result
+	if len(e.Method) > 0 {
+		data = e.Params
+	}
+
+	if v, ok := b.(*raw.Frame); ok {
+		v.Data = data
+		return nil
+	}
+
+	if len(data) == 0 || bytes.Equal(data, null) {
+		return nil
+	}
+
+	err := json.Unmarshal(data, b)
+	if err != nil && data[0] == '[' {
+		// positional params holding a single argument
+		var params []json.RawMessage
+		if json.Unmarshal(data, &params) == nil && len(params) == 1 {
+			return json.Unmarshal(params[0], b)
+		}
+	}
+	return err
+}
+
+func (c *Codec) Write(m *codec.Message, b interface{}) error {
+	if v, ok := b.(*raw.Frame); ok {
+		b = json.RawMessage(v.Data)
+	}
+
+	switch m.Type {
+	case codec.Request, codec.Event:
+		req := &request{
+			Version: Version,
+			Method:  m.Endpoint,
+			Params:  b,
+		}
+		if len(req.Method) == 0 {
+			req.Method = m.Method
+		}
+		// events are sent as notifications to the topic
+		if m.Type == codec.Event {
+			if len(req.Method) == 0 {
+				req.Method = m.Target
+			}
+		} else {
+			id := idValue(m.Id)
+			req.Id = &id
+		}
+		return c.Encoder.Encode(req)
+	}
+
+	// notifications are not answered
+	if c.notify {
+		return nil
+	}
+
+	rsp := &response{
+		Version: Version,
+		Id:      c.id,
+	}
+	if rsp.Id == nil {
+		id := idValue(m.Id)
+		rsp.Id = &id
+	}
+
+	if len(m.Error) > 0 {
+		rsp.Error = newError(m.Error)
+	} else if b != nil {
+		rsp.Result = b
+	} else {
+		rsp.Result = null
+	}
+
+	return c.Encoder.Encode(rsp)
+}
+
+func (c *Codec) Close() error {
+	return c.Conn.Close()
+}
+
+func (c *Codec) String() string {
+	return "json-rpc"
+}
+
+func NewCodec(c io.ReadWriteCloser) codec.Codec {
+	return &Codec{
+		Conn:    c,
+		Decoder: json.NewDecoder(c),
+		Encoder: json.NewEncoder(c),
+	}
+}
+
+// Split returns the requests of a batch, ok is false when the
+// body is not a batch. Batches not parsed or empty return an error.
+func Split(body []byte) ([]json.RawMessage, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		return nil, false, nil
+	}
+
+	var reqs []json.RawMessage
+	if err := json.Unmarshal(body, &reqs); err != nil {
+		return nil, true, err
+	}
+	if len(reqs) == 0 {
+		return nil, true, ErrEmptyBatch
+	}
+	return reqs, true, nil
+}
+
+// BatchError returns the response to a batch which could not be served,
+// the error of Split
+func BatchError(err error) []byte {
+	e := &Error{Code: ParseError, Message: "parse error: " + err.Error()}
+	if err == ErrEmptyBatch {
+		e = &Error{Code: InvalidRequest, Message: "invalid request: empty batch"}
+	}
+
+	b, _ := json.Marshal(&response{Version: Version, Error: e, Id: &null})
+	return b
+}
+
+// Join returns the batch response of the given responses, empty
+// responses to notifications are left out. Nothing is returned when
+// no response is left.
+func Join(rsps [][]byte) []byte {
+	var parts [][]byte
+	for _, rsp := range rsps {
+		if rsp = bytes.TrimSpace(rsp); len(rsp) > 0 {
+			parts = append(parts, rsp)
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	b := bytes.NewBuffer(nil)
+	b.WriteByte('[')
+	b.Write(bytes.Join(parts, []byte(",")))
+	b.WriteByte(']')
+	return b.Bytes()
+}
+
+// idValue encodes numeric ids as numbers and anything else as a string
+func idValue(id string) json.RawMessage {
+	if len(id) == 0 {
+		return null
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return json.RawMessage(id)
+	}
+	b, _ := json.Marshal(id)
+	return b
+}
+
+func idString(id json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(id, &s); err == nil {
+		return s
+	}
+	if bytes.Equal(id, null) {
+		return ""
+	}
+	return string(id)
+}
@@ -0,0 +1,91 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"testing"
+
+	"common/codec"
+	"common/util/buf"
+)
+
+type testParams struct {
+	Name string `json:"name"`
+}
+
+func TestCodecRequest(t *testing.T) {
+	b := buf.New(nil)
+
+	if err := NewCodec(b).Write(&codec.Message{
+		Id:       "7",
+		Type:     codec.Request,
+		Endpoint: "Test.Hello",
+	}, &testParams{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := string(bytes.TrimSpace(b.Bytes())); s != `{"jsonrpc":"2.0","method":"Test.Hello","params":{"name":"John"},"id":7}` {
+		t.Fatalf("unexpected request %s", s)
+	}
+
+	c := NewCodec(b)
+
+	var m codec.Message
+	if err := c.ReadHeader(&m, codec.Request); err != nil {
+		t.Fatal(err)
+	}
+	if m.Id != "7" || m.Endpoint != "Test.Hello" {
+		t.Fatalf("unexpected header %+v", m)
+	}
+
+	var p testParams
+	if err := c.ReadBody(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "John" {
+		t.Fatalf("unexpected params %+v", p)
+	}
+
+	// the response echoes the id of the request
+	if err := c.Write(&codec.Message{Type: codec.Error, Error: "failed"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := string(bytes.TrimSpace(b.Bytes())); s != `{"jsonrpc":"2.0","error":{"code":-32000,"message":"failed"},"id":7}` {
+		t.Fatalf("unexpected response %s", s)
+	}
+}
+
+func TestCodecBatch(t *testing.T) {
+	b := buf.New(bytes.NewBufferString(`[
+		{"jsonrpc": "2.0", "method": "a", "id": "1"},
+		{"jsonrpc": "2.0", "method": "b"}
+	]`))
+	c := NewCodec(b)
+
+	for _, ep := range []string{"a", "b"} {
+		var m codec.Message
+		if err := c.ReadHeader(&m, codec.Request); err != nil {
+			t.Fatal(err)
+		}
+		if m.Endpoint != ep {
+			t.Fatalf("unexpected endpoint %s, want %s", m.Endpoint, ep)
+		}
+		if err := c.ReadBody(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b is a notification
+	if err := c.Write(&codec.Message{Type: codec.Response}, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Fatalf("notification answered with %s", b.Bytes())
+	}
+
+	if s := string(Join([][]byte{[]byte("1"), nil, []byte("2\n")})); s != "[1,2]" {
+		t.Fatalf("unexpected batch %s", s)
+	}
+	if Join([][]byte{nil}) != nil {
+		t.Fatal("expected no response to a batch of notifications")
+	}
+}
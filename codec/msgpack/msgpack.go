@@ -0,0 +1,74 @@
+// Package msgpack provides a msgpack codec, struct fields are named by
+// their json tags so the same types work with codec/json.
+package msgpack
+
+import (
+	"io"
+
+	"common/codec"
+	raw "common/codec/bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type Codec struct {
+	Conn    io.ReadWriteCloser
+	Encoder *msgpack.Encoder
+	Decoder *msgpack.Decoder
+}
+
+func (c *Codec) ReadHeader(m *codec.Message, t codec.MessageType) error {
+	return nil
+}
+
+func (c *Codec) ReadBody(b interface{}) error {
+	if b == nil {
+		return nil
+	}
+
+	if v, ok := b.(*raw.Frame); ok {
+		buf, err := io.ReadAll(c.Conn)
+		if err != nil {
+			return err
+		}
+		v.Data = buf
+		return nil
+	}
+
+	return c.Decoder.Decode(b)
+}
+
+func (c *Codec) Write(m *codec.Message, b interface{}) error {
+	if b == nil {
+		return nil
+	}
+
+	if v, ok := b.(*raw.Frame); ok {
+		_, err := c.Conn.Write(v.Data)
+		return err
+	}
+
+	return c.Encoder.Encode(b)
+}
+
+func (c *Codec) Close() error {
+	return c.Conn.Close()
+}
+
+func (c *Codec) String() string {
+	return "msgpack"
+}
+
+func NewCodec(c io.ReadWriteCloser) codec.Codec {
+	enc := msgpack.NewEncoder(c)
+	enc.SetCustomStructTag("json")
+
+	dec := msgpack.NewDecoder(c)
+	dec.SetCustomStructTag("json")
+
+	return &Codec{
+		Conn:    c,
+		Encoder: enc,
+		Decoder: dec,
+	}
+}
@@ -0,0 +1,77 @@
+// Package proto provides a proto codec
+package proto
+
+import (
+	"io"
+
+	"common/codec"
+	raw "common/codec/bytes"
+
+	"github.com/golang/protobuf/proto"
+)
+
+type Codec struct {
+	Conn io.ReadWriteCloser
+}
+
+func (c *Codec) ReadHeader(m *codec.Message, t codec.MessageType) error {
+	return nil
+}
+
+func (c *Codec) ReadBody(b interface{}) error {
+	if b == nil {
+		return nil
+	}
+
+	buf, err := io.ReadAll(c.Conn)
+	if err != nil {
+		return err
+	}
+
+	switch v := b.(type) {
+	case *raw.Frame:
+		v.Data = buf
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(buf, v)
+	}
+
+	return codec.ErrInvalidMessage
+}
+
+func (c *Codec) Write(m *codec.Message, b interface{}) error {
+	if b == nil {
+		// Nothing to write
+		return nil
+	}
+
+	var buf []byte
+	switch v := b.(type) {
+	case *raw.Frame:
+		buf = v.Data
+	case proto.Message:
+		var err error
+		if buf, err = proto.Marshal(v); err != nil {
+			return err
+		}
+	default:
+		return codec.ErrInvalidMessage
+	}
+
+	_, err := c.Conn.Write(buf)
+	return err
+}
+
+func (c *Codec) Close() error {
+	return c.Conn.Close()
+}
+
+func (c *Codec) String() string {
+	return "proto"
+}
+
+func NewCodec(c io.ReadWriteCloser) codec.Codec {
+	return &Codec{
+		Conn: c,
+	}
+}
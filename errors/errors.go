@@ -0,0 +1,156 @@
+// Package errors is the error type sent back by services, it carries a
+// status code so clients can tell what actually happened.
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"common/util/app/errcode"
+)
+
+// Error is serialized as json in the codec message error and the
+// Micro-Error header.
+type Error struct {
+	Id     string `json:"id"`
+	Code   int32  `json:"code"`
+	Detail string `json:"detail"`
+	Status string `json:"status"`
+}
+
+var (
+	// Errcodes maps the errors of util/app/errcode to status codes,
+	// services may add their own codes
+	Errcodes = map[*errcode.Error]int32{
+		errcode.ErrInvalidParams:     400,
+		errcode.ErrServerUnavailable: 503,
+		errcode.ErrServerInternal:    500,
+	}
+)
+
+func (e *Error) Error() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// Errcode returns the util/app/errcode error of the status code
+func (e *Error) Errcode() *errcode.Error {
+	for ec, code := range Errcodes {
+		if code == e.Code {
+			return ec.WithDetail(e.Detail)
+		}
+	}
+	return errcode.ErrServerInternal.WithDetail(e.Detail)
+}
+
+// New returns an error with the given id, detail and status code
+func New(id, detail string, code int32) error {
+	return &Error{
+		Id:     id,
+		Code:   code,
+		Detail: detail,
+		Status: http.StatusText(int(code)),
+	}
+}
+
+// Parse parses a serialized error, anything else becomes
+// the detail of an internal server error
+func Parse(err string) *Error {
+	e := new(Error)
+	if json.Unmarshal([]byte(err), e) != nil || e.Code == 0 {
+		e = &Error{
+			Code:   500,
+			Detail: err,
+			Status: http.StatusText(500),
+		}
+	}
+	return e
+}
+
+// FromError returns the error as an Error, util/app/errcode errors are mapped
+// by Errcodes and other errors parsed
+func FromError(err error) *Error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *Error:
+		return e
+	case *errcode.Error:
+		return FromErrcode("", e)
+	}
+	return Parse(err.Error())
+}
+
+// FromErrcode returns the util/app/errcode error as an Error of the given id
+func FromErrcode(id string, e *errcode.Error) *Error {
+	var code int32 = 500
+	for ec, c := range Errcodes {
+		if ec.Code == e.Code {
+			code = c
+			break
+		}
+	}
+
+	detail := e.Detail
+	if len(detail) == 0 {
+		detail = e.Msg
+	}
+
+	return &Error{
+		Id:     id,
+		Code:   code,
+		Detail: detail,
+		Status: http.StatusText(int(code)),
+	}
+}
+
+// BadRequest generates a 400 error.
+func BadRequest(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 400)
+}
+
+// Unauthorized generates a 401 error.
+func Unauthorized(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 401)
+}
+
+// Forbidden generates a 403 error.
+func Forbidden(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 403)
+}
+
+// NotFound generates a 404 error.
+func NotFound(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 404)
+}
+
+// MethodNotAllowed generates a 405 error.
+func MethodNotAllowed(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 405)
+}
+
+// Timeout generates a 408 error.
+func Timeout(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 408)
+}
+
+// Conflict generates a 409 error.
+func Conflict(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 409)
+}
+
+// TooManyRequests generates a 429 error.
+func TooManyRequests(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 429)
+}
+
+// InternalServerError generates a 500 error.
+func InternalServerError(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 500)
+}
+
+// ServiceUnavailable generates a 503 error.
+func ServiceUnavailable(id, format string, a ...interface{}) error {
+	return New(id, fmt.Sprintf(format, a...), 503)
+}
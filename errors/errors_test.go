@@ -0,0 +1,40 @@
+package errors
+
+import (
+	"errors"
+	"testing"
+
+	"common/util/app/errcode"
+)
+
+func TestParse(t *testing.T) {
+	err := NotFound("go.micro.client", "service %s: not found", "foo")
+
+	e := Parse(err.Error())
+	if e.Id != "go.micro.client" || e.Code != 404 || e.Detail != "service foo: not found" || e.Status != "Not Found" {
+		t.Fatalf("unexpected error %+v", e)
+	}
+
+	// anything else is an internal server error
+	e = FromError(errors.New("connection refused"))
+	if e.Code != 500 || e.Detail != "connection refused" {
+		t.Fatalf("unexpected error %+v", e)
+	}
+}
+
+func TestErrcode(t *testing.T) {
+	e := FromErrcode("test.service", errcode.ErrInvalidParams.WithDetail("name required"))
+	if e.Code != 400 || e.Detail != "name required" {
+		t.Fatalf("unexpected error %+v", e)
+	}
+
+	ec := Parse(e.Error()).Errcode()
+	if ec.Code != errcode.ErrInvalidParams.Code || ec.Detail != "name required" {
+		t.Fatalf("unexpected errcode %+v", ec)
+	}
+
+	// unknown codes are internal errors
+	if e := FromErrcode("", errcode.NewError(42, "unknown")); e.Code != 500 || e.Detail != "unknown" {
+		t.Fatalf("unexpected error %+v", e)
+	}
+}
@@ -6,25 +6,31 @@ require (
 	github.com/aliyun/alibaba-cloud-sdk-go v1.61.948
 	github.com/coocood/freecache v1.1.1
 	github.com/coreos/bbolt v1.3.3 // indirect
-	github.com/coreos/etcd v3.3.25+incompatible // indirect
+	github.com/coreos/etcd v3.3.25+incompatible
 	github.com/coreos/go-semver v0.3.0 // indirect
 	github.com/coreos/go-systemd v0.0.0-20191104093116-d3cd4ed1dbcf // indirect
 	github.com/coreos/pkg v0.0.0-20180928190104-399ea9e2e55f // indirect
 	github.com/gin-gonic/gin v1.7.4
 	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/golang/protobuf v1.4.3
+	github.com/golang/snappy v0.0.3
 	github.com/golang/groupcache v0.0.0-20191002201903-404acd9df4cc // indirect
 	github.com/google/go-cmp v0.5.4
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/grpc-ecosystem/go-grpc-middleware v1.1.0 // indirect
 	github.com/jsimonetti/rtnetlink v0.0.0-20210222123823-d96e01069ed6
+	github.com/klauspost/compress v1.18.0
 	github.com/mdlayher/netlink v1.3.2
+	github.com/miekg/dns v1.1.25
 	github.com/mitchellh/hashstructure v1.1.0
 	github.com/prometheus/client_golang v1.9.0
+	github.com/quic-go/quic-go v0.41.0
 	github.com/streadway/amqp v1.0.0
 	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/tmc/grpc-websocket-proxy v0.0.0-20190109142713-0ad062ec5ee5 // indirect
 	github.com/unrolled/secure v1.0.8
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.etcd.io/etcd v3.3.25+incompatible
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/zap v1.16.0 // indirect
@@ -34,8 +40,12 @@ require (
 	golang.org/x/sys v0.0.0-20210309074719-68d13333faf2
 	golang.org/x/text v0.3.5 // indirect
 	google.golang.org/genproto v0.0.0-20210310155132-4ce2db91004e // indirect
-	google.golang.org/protobuf v1.25.0 // indirect
+	google.golang.org/grpc v1.26.0
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/ini.v1 v1.44.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 	honnef.co/go/tools v0.1.3 // indirect
 )
+
+// etcd v3.3 does not build against newer grpc releases
+replace google.golang.org/grpc => google.golang.org/grpc v1.26.0
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"common/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type hedgeCollector struct {
+	hedges *client.Hedges
+
+	calls *prometheus.Desc
+	sent  *prometheus.Desc
+	wins  *prometheus.Desc
+}
+
+// NewHedgeCollector returns a collector of the hedged calls labelled
+// by service and endpoint.
+func NewHedgeCollector(namespace string, h *client.Hedges) prometheus.Collector {
+	labels := []string{"service", "endpoint"}
+	return &hedgeCollector{
+		hedges: h,
+		calls:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "client", "hedged_calls_total"), "Calls made with hedging enabled.", labels, nil),
+		sent:   prometheus.NewDesc(prometheus.BuildFQName(namespace, "client", "hedges_total"), "Hedged copies sent.", labels, nil),
+		wins:   prometheus.NewDesc(prometheus.BuildFQName(namespace, "client", "hedge_wins_total"), "Calls answered by a hedged copy.", labels, nil),
+	}
+}
+
+func (c *hedgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.calls
+	ch <- c.sent
+	ch <- c.wins
+}
+
+func (c *hedgeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.hedges.Stats() {
+		ch <- prometheus.MustNewConstMetric(c.calls, prometheus.CounterValue, float64(s.Calls), s.Service, s.Endpoint)
+		ch <- prometheus.MustNewConstMetric(c.sent, prometheus.CounterValue, float64(s.Hedges), s.Service, s.Endpoint)
+		ch <- prometheus.MustNewConstMetric(c.wins, prometheus.CounterValue, float64(s.Wins), s.Service, s.Endpoint)
+	}
+}
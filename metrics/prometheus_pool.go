@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"common/transport/pool"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type poolCollector struct {
+	pool pool.Pool
+
+	active   *prometheus.Desc
+	idle     *prometheus.Desc
+	waiters  *prometheus.Desc
+	dials    *prometheus.Desc
+	failures *prometheus.Desc
+}
+
+// NewPoolCollector returns a collector of the connection pool stats
+// labelled by address.
+func NewPoolCollector(namespace string, p pool.Pool) prometheus.Collector {
+	labels := []string{"address"}
+	return &poolCollector{
+		pool:     p,
+		active:   prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "active_conns"), "Connections in use.", labels, nil),
+		idle:     prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "idle_conns"), "Connections idle in the pool.", labels, nil),
+		waiters:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "waiters"), "Callers waiting for a connection.", labels, nil),
+		dials:    prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "dials_total"), "Connections dialed.", labels, nil),
+		failures: prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", "failures_total"), "Failed dials, health checks and errored connections.", labels, nil),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.idle
+	ch <- c.waiters
+	ch <- c.dials
+	ch <- c.failures
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for addr, s := range c.pool.Stats() {
+		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, float64(s.Active), addr)
+		ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.Idle), addr)
+		ch <- prometheus.MustNewConstMetric(c.waiters, prometheus.GaugeValue, float64(s.Waiters), addr)
+		ch <- prometheus.MustNewConstMetric(c.dials, prometheus.CounterValue, float64(s.Dials), addr)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(s.Failures), addr)
+	}
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"common/selector"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type selectorCollector struct {
+	balancer *selector.Balancer
+
+	inflight *prometheus.Desc
+	latency  *prometheus.Desc
+	requests *prometheus.Desc
+	errors   *prometheus.Desc
+}
+
+// NewSelectorCollector returns a collector of the balancer node stats
+// labelled by node id and address.
+func NewSelectorCollector(namespace string, b *selector.Balancer) prometheus.Collector {
+	labels := []string{"node", "address"}
+	return &selectorCollector{
+		balancer: b,
+		inflight: prometheus.NewDesc(prometheus.BuildFQName(namespace, "selector", "inflight_calls"), "Calls in flight.", labels, nil),
+		latency:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "selector", "latency_seconds"), "Decaying average of the call latency.", labels, nil),
+		requests: prometheus.NewDesc(prometheus.BuildFQName(namespace, "selector", "requests_total"), "Calls sent to the node.", labels, nil),
+		errors:   prometheus.NewDesc(prometheus.BuildFQName(namespace, "selector", "errors_total"), "Calls to the node that failed.", labels, nil),
+	}
+}
+
+func (c *selectorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inflight
+	ch <- c.latency
+	ch <- c.requests
+	ch <- c.errors
+}
+
+func (c *selectorCollector) Collect(ch chan<- prometheus.Metric) {
+	for id, s := range c.balancer.Stats() {
+		ch <- prometheus.MustNewConstMetric(c.inflight, prometheus.GaugeValue, float64(s.InFlight), id, s.Address)
+		ch <- prometheus.MustNewConstMetric(c.latency, prometheus.GaugeValue, s.Latency.Seconds(), id, s.Address)
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(s.Requests), id, s.Address)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors), id, s.Address)
+	}
+}
@@ -12,6 +12,14 @@ import "time"
 type Options struct {
 	// TTL is the cache TTL
 	TTL time.Duration
+	// SnapshotPath is the file the cache is saved to and loaded
+	// from at startup, empty disables snapshots
+	SnapshotPath string
+	// SnapshotInterval is how often the snapshot is written
+	SnapshotInterval time.Duration
+	// MaxStale is how long after their last update services are
+	// still served while the registry fails, zero is no limit
+	MaxStale time.Duration
 }
 
 // WithTTL sets the cache TTL
@@ -19,4 +27,27 @@ func WithTTL(t time.Duration) Option {
 	return func(o *Options) {
 		o.TTL = t
 	}
-}
\ No newline at end of file
+}
+
+// WithSnapshot saves the cache to path so it survives restarts
+// during a registry outage
+func WithSnapshot(path string) Option {
+	return func(o *Options) {
+		o.SnapshotPath = path
+	}
+}
+
+// WithSnapshotInterval sets how often the snapshot is written
+func WithSnapshotInterval(t time.Duration) Option {
+	return func(o *Options) {
+		o.SnapshotInterval = t
+	}
+}
+
+// WithMaxStale limits how old the services served while
+// the registry fails can be
+func WithMaxStale(t time.Duration) Option {
+	return func(o *Options) {
+		o.MaxStale = t
+	}
+}
@@ -4,7 +4,7 @@
 @File : rcache
 @Software: GoLand
 @Others:
-TODO: 增加ETCD失去链接或没有可用的servers情况下所有失活的list筛选处理...
+ETCD失去链接时使用过期或快照中的服务, 标记为stale, 见 snapshot.go.
 */
 package rcache
 
@@ -14,6 +14,7 @@ import (
 	"common/registry"
 	"math"
 	"math/rand"
+	"os"
 	"sync"
 	"time"
 )
@@ -37,12 +38,18 @@ type cache struct {
 	cache   map[string][]*registry.Service
 	ttls    map[string]time.Time
 	watched map[string]bool
+	// last time the registry confirmed each service
+	updated map[string]time.Time
+	// changed since the last snapshot
+	dirty bool
 
 	exit chan bool
 }
 
 var (
 	DefaultTTL = time.Minute
+
+	DefaultSnapshotInterval = time.Second * 30
 )
 
 // 10 的 attempts 幂次方时间秒回落..
@@ -123,11 +130,37 @@ func (c *cache) cp(current []*registry.Service) []*registry.Service {
 func (c *cache) del(service string) {
 	delete(c.cache, service)
 	delete(c.ttls, service)
+	delete(c.updated, service)
+	c.dirty = true
 }
 
 func (c *cache) set(service string, services []*registry.Service) {
 	c.cache[service] = services
 	c.ttls[service] = time.Now().Add(c.opts.TTL)
+	c.updated[service] = time.Now()
+	c.dirty = true
+}
+
+// serveStale reports whether services last updated at updated may
+// still be served while the registry fails
+func (c *cache) serveStale(updated time.Time) bool {
+	if c.opts.MaxStale <= 0 {
+		return true
+	}
+	return !updated.IsZero() && time.Since(updated) <= c.opts.MaxStale
+}
+
+// stale flags copied services as served from a failed registry
+func stale(services []*registry.Service) []*registry.Service {
+	for _, s := range services {
+		md := make(map[string]string, len(s.Metadata)+1)
+		for k, v := range s.Metadata {
+			md[k] = v
+		}
+		md[StaleMetadata] = "true"
+		s.Metadata = md
+	}
+	return services
 }
 
 func (c *cache) get(service string) ([]*registry.Service, error) {
@@ -152,13 +185,16 @@ func (c *cache) get(service string) ([]*registry.Service, error) {
 	// get does the actual request for a service and cache it
 	// if services over ttl..
 	invalidServices := c.cp(services)
+	updated := c.updated[service]
 	get := func(service string) ([]*registry.Service, error) {
 		// ask the registry
 		services, err := c.Registry.GetService(service)
 		if err != nil {
 			// 防止ET-CD挂掉读取本地超时ttl...
-			if len(invalidServices) > 0 {
-				return invalidServices, nil
+			// a service the registry no longer has is not an outage
+			if err != registry.ErrNotFound && len(invalidServices) > 0 && c.serveStale(updated) {
+				log.Warnf("r-cache: serving stale %s updated at %v: %v", service, updated, err)
+				return stale(invalidServices), nil
 			}
 			return nil, err
 		}
@@ -192,6 +228,17 @@ func (c *cache) update(res *registry.Result) {
 	c.Lock()
 	defer c.Unlock()
 
+	// the watcher lost changes, reload on the next lookup but
+	// keep what we know in case the registry fails
+	if res.Action == "resync" {
+		for name := range c.ttls {
+			if len(res.Service.Name) == 0 || name == res.Service.Name {
+				delete(c.ttls, name)
+			}
+		}
+		return
+	}
+
 	services, ok := c.cache[res.Service.Name]
 	if !ok {
 		// we're not going to cache anything
@@ -206,6 +253,10 @@ func (c *cache) update(res *registry.Result) {
 		return
 	}
 
+	// the watch confirms the service
+	c.updated[res.Service.Name] = time.Now()
+	c.dirty = true
+
 	var targetNode *registry.Node
 	switch res.Action {
 	case "create", "update":
@@ -491,12 +542,27 @@ func New(r registry.Registry, opts ...Option) Cache {
 		o(&options)
 	}
 
-	return &cache{
+	if options.SnapshotInterval <= 0 {
+		options.SnapshotInterval = DefaultSnapshotInterval
+	}
+
+	c := &cache{
 		Registry: r,
 		opts:     options,
 		watched:  make(map[string]bool),
 		cache:    make(map[string][]*registry.Service),
 		ttls:     make(map[string]time.Time),
+		updated:  make(map[string]time.Time),
 		exit:     make(chan bool),
 	}
+
+	// start from the last snapshot, the registry may be down
+	if len(options.SnapshotPath) > 0 {
+		if err := c.load(); err != nil && !os.IsNotExist(err) {
+			log.Error("r-cache: unable to load snapshot: ", err)
+		}
+		go c.snapshot()
+	}
+
+	return c
 }
@@ -0,0 +1,172 @@
+package rcache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"common/registry"
+	"common/registry/memory"
+)
+
+var errDown = errors.New("registry down")
+
+// failRegistry fails lookups and watches while down
+type failRegistry struct {
+	registry.Registry
+
+	sync.Mutex
+	down bool
+}
+
+func (f *failRegistry) setDown(down bool) {
+	f.Lock()
+	f.down = down
+	f.Unlock()
+}
+
+func (f *failRegistry) isDown() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.down
+}
+
+func (f *failRegistry) GetService(name string) ([]*registry.Service, error) {
+	if f.isDown() {
+		return nil, errDown
+	}
+	return f.Registry.GetService(name)
+}
+
+func (f *failRegistry) Watch(opts ...registry.WatchOption) (registry.Watcher, error) {
+	if f.isDown() {
+		return nil, errDown
+	}
+	return f.Registry.Watch(opts...)
+}
+
+func newFailRegistry(t *testing.T) *failRegistry {
+	r := memory.NewRegistry()
+	if err := r.Register(&registry.Service{
+		Name:    "test.service",
+		Version: "1.0.0",
+		Nodes: []*registry.Node{{
+			Id:       "test.service-1",
+			Address:  "127.0.0.1:8080",
+			Metadata: map[string]string{},
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return &failRegistry{Registry: r}
+}
+
+func isStale(services []*registry.Service) bool {
+	return len(services) > 0 && services[0].Metadata[StaleMetadata] == "true"
+}
+
+func TestCacheStale(t *testing.T) {
+	r := newFailRegistry(t)
+	c := New(r, WithTTL(time.Millisecond), WithMaxStale(time.Millisecond*200))
+	defer c.Stop()
+
+	services, err := c.GetService("test.service")
+	if err != nil || isStale(services) {
+		t.Fatalf("unexpected services %v: %v", services, err)
+	}
+
+	r.setDown(true)
+	time.Sleep(time.Millisecond * 10)
+
+	services, err = c.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isStale(services) || len(services[0].Nodes) != 1 {
+		t.Fatalf("expected stale services, got %v", services)
+	}
+
+	time.Sleep(time.Millisecond * 300)
+	if _, err := c.GetService("test.service"); err != errDown {
+		t.Fatalf("expected %v past max staleness, got %v", errDown, err)
+	}
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rcache.json")
+
+	r := newFailRegistry(t)
+	c := New(r, WithSnapshot(path))
+	if _, err := c.GetService("test.service"); err != nil {
+		t.Fatal(err)
+	}
+	// the last snapshot is written on stop
+	c.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	r.setDown(true)
+	c = New(r, WithSnapshot(path))
+	defer c.Stop()
+
+	services, err := c.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isStale(services) || services[0].Nodes[0].Address != "127.0.0.1:8080" {
+		t.Fatalf("expected snapshot services, got %v", services)
+	}
+
+	// a failed write is retried on the next save
+	r.setDown(false)
+	path = filepath.Join(dir, "missing", "rcache.json")
+	sc := New(r, WithSnapshot(path)).(*cache)
+	defer sc.Stop()
+
+	if _, err := sc.GetService("test.service"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.save(); err == nil || !sc.dirty {
+		t.Fatalf("expected the snapshot to stay dirty, got %v", err)
+	}
+	if err := os.Mkdir(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheResync(t *testing.T) {
+	r := newFailRegistry(t)
+	c := New(r).(*cache)
+	defer c.Stop()
+
+	if _, err := c.GetService("test.service"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.update(&registry.Result{Action: "resync", Service: &registry.Service{}})
+
+	c.RLock()
+	_, ok := c.ttls["test.service"]
+	services := c.cache["test.service"]
+	c.RUnlock()
+
+	// reloaded on the next lookup, still there for registry failures
+	if ok || len(services) != 1 {
+		t.Fatalf("expected expired services, got ttl %v services %v", ok, services)
+	}
+}
@@ -0,0 +1,125 @@
+package rcache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"common/log/log"
+	"common/registry"
+)
+
+const (
+	// StaleMetadata is set to true in the metadata of services
+	// served from the cache while the registry fails
+	StaleMetadata = "stale"
+)
+
+type snapshotFile struct {
+	Services map[string][]*registry.Service `json:"services"`
+	Updated  map[string]time.Time           `json:"updated"`
+}
+
+// load fills the cache from the snapshot, the services are expired so
+// the registry is asked first and they are only served if it fails
+func (c *cache) load() error {
+	b, err := ioutil.ReadFile(c.opts.SnapshotPath)
+	if err != nil {
+		return err
+	}
+
+	var snap snapshotFile
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	for name, services := range snap.Services {
+		// the registry got there first
+		if _, ok := c.cache[name]; ok || len(services) == 0 {
+			continue
+		}
+		c.cache[name] = services
+		c.updated[name] = snap.Updated[name]
+	}
+
+	return nil
+}
+
+// save writes the cache to the snapshot file, replaced in one rename
+// so a crash never leaves half a snapshot
+func (c *cache) save() error {
+	c.Lock()
+	if !c.dirty {
+		c.Unlock()
+		return nil
+	}
+	snap := snapshotFile{
+		Services: make(map[string][]*registry.Service, len(c.cache)),
+		Updated:  make(map[string]time.Time, len(c.updated)),
+	}
+	for name, services := range c.cache {
+		snap.Services[name] = c.cp(services)
+		snap.Updated[name] = c.updated[name]
+	}
+	c.dirty = false
+	b, err := json.Marshal(&snap)
+	c.Unlock()
+
+	if err == nil {
+		err = c.write(b)
+	}
+	if err != nil {
+		// saved again on the next tick
+		c.Lock()
+		c.dirty = true
+		c.Unlock()
+	}
+
+	return err
+}
+
+// write replaces the snapshot file with b
+func (c *cache) write(b []byte) error {
+	path := c.opts.SnapshotPath
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshot saves the cache every interval and once more on stop
+func (c *cache) snapshot() {
+	t := time.NewTicker(c.opts.SnapshotInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-c.exit:
+			if err := c.save(); err != nil {
+				log.Error("r-cache: unable to save snapshot: ", err)
+			}
+			return
+		}
+
+		if err := c.save(); err != nil {
+			log.Error("r-cache: unable to save snapshot: ", err)
+		}
+	}
+}
@@ -313,7 +313,7 @@ func (e *etcdv3Registry) ListServices() ([]*Service, error) {
 }
 
 func (e *etcdv3Registry) Watch(opts ...WatchOption) (Watcher, error) {
-	return newEtcdv3Watcher(e, e.options.Timeout, opts...)
+	return newEtcdv3Watcher(e.client, e.options.Timeout, opts...)
 }
 
 func (e *etcdv3Registry) String() string {
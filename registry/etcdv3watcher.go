@@ -2,20 +2,53 @@ package registry
 
 import (
 	"context"
-	"errors"
+	"sync"
 	"time"
 
+	"common/log/log"
+	"common/util/helper"
+
 	"go.etcd.io/etcd/clientv3"
 )
 
+var (
+	// RewatchInterval is how long a failed watch waits before resuming
+	RewatchInterval = time.Second
+
+	// maxBatch flushes a debounced batch that keeps growing
+	maxBatch = 1024
+)
+
+// change is a result keyed by the etcd key it came from
+type change struct {
+	key string
+	res *Result
+}
+
 type etcdv3Watcher struct {
 	stop    chan bool
+	ctx     context.Context
+	cancel  context.CancelFunc
 	w       clientv3.WatchChan
-	client  *clientv3.Client
+	watcher clientv3.Watcher
 	timeout time.Duration
+
+	service string
+	path    string
+	// the last revision seen, watches resume after it
+	revision int64
+	// results read but not yet returned by Next
+	results []*Result
+
+	// debounced batches, nil when results are not debounced
+	batches chan []*Result
+	ready   chan bool
+	sync.Mutex
+	keys    []string
+	pending map[string]*Result
 }
 
-func newEtcdv3Watcher(r *etcdv3Registry, timeout time.Duration, opts ...WatchOption) (Watcher, error) {
+func newEtcdv3Watcher(w clientv3.Watcher, timeout time.Duration, opts ...WatchOption) (Watcher, error) {
 	var wo WatchOptions
 	for _, o := range opts {
 		o(&wo)
@@ -34,20 +67,104 @@ func newEtcdv3Watcher(r *etcdv3Registry, timeout time.Duration, opts ...WatchOpt
 		watchPath = servicePath(wo.Service) + "/"
 	}
 
-	return &etcdv3Watcher{
+	ew := &etcdv3Watcher{
 		stop:    stop,
-		w:       r.client.Watch(ctx, watchPath, clientv3.WithPrefix(), clientv3.WithPrevKV()),
-		client:  r.client,
+		ctx:     ctx,
+		watcher: w,
 		timeout: timeout,
-	}, nil
+		service: wo.Service,
+		path:    watchPath,
+	}
+	ew.watch()
+
+	if wo.Debounce > 0 {
+		ew.batches = make(chan []*Result)
+		ew.ready = make(chan bool, 1)
+		ew.pending = make(map[string]*Result)
+		go ew.debounce(wo.Debounce)
+		go ew.send()
+	}
+
+	return ew, nil
 }
 
-func (ew *etcdv3Watcher) Next() (*Result, error) {
-	for wresp := range ew.w {
-		if wresp.Err() != nil {
-			return nil, wresp.Err()
+func (ew *etcdv3Watcher) stopped() bool {
+	select {
+	case <-ew.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// watch starts watching after the last revision seen
+func (ew *etcdv3Watcher) watch() {
+	if ew.cancel != nil {
+		ew.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(ew.ctx)
+	ew.cancel = cancel
+
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithProgressNotify()}
+	if ew.revision > 0 {
+		opts = append(opts, clientv3.WithRev(ew.revision+1))
+	}
+
+	// keep the leader requirement so a partitioned member fails the watch
+	ew.w = ew.watcher.Watch(clientv3.WithRequireLeader(ctx), ew.path, opts...)
+}
+
+// rewatch resumes the watch after a failure
+func (ew *etcdv3Watcher) rewatch(err error) {
+	log.Warnf("registry: watch %s failed at revision %d: %v", ew.path, ew.revision, err)
+
+	select {
+	case <-ew.stop:
+	case <-time.After(RewatchInterval):
+		ew.watch()
+	}
+}
+
+// read returns the changes of the next watch response, resuming the watch
+// after errors. It only fails when the watcher is stopped.
+func (ew *etcdv3Watcher) read() ([]*change, error) {
+	for {
+		wresp, ok := <-ew.w
+		if ew.stopped() {
+			return nil, ErrWatcherStopped
+		}
+		if !ok {
+			ew.rewatch(context.Canceled)
+			continue
 		}
+
+		if wresp.CompactRevision > 0 {
+			// the events before the compaction are lost, the caller
+			// has to reload what it knows
+			log.Warnf("registry: watch %s compacted at revision %d", ew.path, wresp.CompactRevision)
+			ew.revision = wresp.CompactRevision - 1
+			ew.watch()
+			return []*change{{res: &Result{
+				Action:  "resync",
+				Service: &Service{Name: ew.service},
+			}}}, nil
+		}
+
+		if err := wresp.Err(); err != nil {
+			ew.rewatch(err)
+			continue
+		}
+
+		if wresp.IsProgressNotify() {
+			ew.revision = wresp.Header.Revision
+			continue
+		}
+
+		var changes []*change
 		for _, ev := range wresp.Events {
+			ew.revision = ev.Kv.ModRevision
+
 			service := decode(ev.Kv.Value)
 			var action string
 			switch ev.Type {
@@ -61,19 +178,139 @@ func (ew *etcdv3Watcher) Next() (*Result, error) {
 				action = "delete"
 
 				// get service from prevKv
-				service = decode(ev.PrevKv.Value)
+				service = nil
+				if ev.PrevKv != nil {
+					service = decode(ev.PrevKv.Value)
+				}
 			}
 			if service == nil {
 				continue
 			}
 
-			return &Result{
-				Action:  action,
-				Service: service,
-			}, nil
+			changes = append(changes, &change{
+				key: string(ev.Kv.Key),
+				res: &Result{Action: action, Service: service},
+			})
+		}
+
+		if len(changes) > 0 {
+			return changes, nil
+		}
+	}
+}
+
+// debounce coalesces the changes of a burst, only the last change of
+// every key is kept until no change came for the given duration.
+func (ew *etcdv3Watcher) debounce(after time.Duration) {
+	flush := helper.New(after)
+
+	for {
+		changes, err := ew.read()
+		if err != nil {
+			return
+		}
+
+		ew.Lock()
+		for _, c := range changes {
+			// a resync replaces everything pending
+			if c.res.Action == "resync" {
+				ew.keys = nil
+				ew.pending = make(map[string]*Result)
+			}
+			if _, ok := ew.pending[c.key]; !ok {
+				ew.keys = append(ew.keys, c.key)
+			}
+			ew.pending[c.key] = c.res
+		}
+		// resyncs are keyed by the empty key and sent at once
+		full := len(ew.keys) >= maxBatch || ew.pending[""] != nil
+		ew.Unlock()
+
+		if full {
+			ew.flush()
+		} else {
+			flush(ew.flush)
 		}
 	}
-	return nil, errors.New("could not get next")
+}
+
+func (ew *etcdv3Watcher) flush() {
+	select {
+	case ew.ready <- true:
+	default:
+	}
+}
+
+// send hands the pending batch over, a single sender keeps the order
+func (ew *etcdv3Watcher) send() {
+	for {
+		select {
+		case <-ew.stop:
+			return
+		case <-ew.ready:
+		}
+
+		ew.Lock()
+		var batch []*Result
+		for _, key := range ew.keys {
+			batch = append(batch, ew.pending[key])
+		}
+		ew.keys = nil
+		ew.pending = make(map[string]*Result)
+		ew.Unlock()
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		select {
+		case ew.batches <- batch:
+		case <-ew.stop:
+			return
+		}
+	}
+}
+
+func (ew *etcdv3Watcher) NextBatch() ([]*Result, error) {
+	if len(ew.results) > 0 {
+		results := ew.results
+		ew.results = nil
+		return results, nil
+	}
+
+	if ew.batches != nil {
+		select {
+		case batch := <-ew.batches:
+			return batch, nil
+		case <-ew.stop:
+			return nil, ErrWatcherStopped
+		}
+	}
+
+	changes, err := ew.read()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, 0, len(changes))
+	for _, c := range changes {
+		results = append(results, c.res)
+	}
+	return results, nil
+}
+
+func (ew *etcdv3Watcher) Next() (*Result, error) {
+	if len(ew.results) == 0 {
+		results, err := ew.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		ew.results = results
+	}
+
+	res := ew.results[0]
+	ew.results = ew.results[1:]
+	return res, nil
 }
 
 func (ew *etcdv3Watcher) Stop() {
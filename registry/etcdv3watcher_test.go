@@ -0,0 +1,301 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// fakeWatch is a watch opened on the fake watcher, its channel is
+// closed once the context is done
+type fakeWatch struct {
+	sync.Mutex
+	key    string
+	rev    int64
+	ctx    context.Context
+	ch     chan clientv3.WatchResponse
+	closed bool
+}
+
+func (w *fakeWatch) send(t *testing.T, resp clientv3.WatchResponse) {
+	w.Lock()
+	defer w.Unlock()
+
+	select {
+	case w.ch <- resp:
+	case <-w.ctx.Done():
+		t.Error("watch closed")
+	case <-time.After(time.Second):
+		t.Error("timed out sending a watch response")
+	}
+}
+
+// close closes the channel as a watch failing without a response
+func (w *fakeWatch) close() {
+	w.Lock()
+	defer w.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+}
+
+// fakeWatcher hands the watches opened to the test
+type fakeWatcher struct {
+	watches chan *fakeWatch
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{watches: make(chan *fakeWatch, 10)}
+}
+
+func (f *fakeWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	w := &fakeWatch{
+		key: key,
+		rev: clientv3.OpGet(key, opts...).Rev(),
+		ctx: ctx,
+		ch:  make(chan clientv3.WatchResponse),
+	}
+
+	go func() {
+		<-ctx.Done()
+		w.close()
+	}()
+
+	f.watches <- w
+	return w.ch
+}
+
+func (f *fakeWatcher) RequestProgress(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeWatcher) Close() error {
+	return nil
+}
+
+func (f *fakeWatcher) next(t *testing.T) *fakeWatch {
+	t.Helper()
+	select {
+	case w := <-f.watches:
+		return w
+	case <-time.After(time.Second * 2):
+		t.Fatal("timed out waiting for a watch")
+		return nil
+	}
+}
+
+// put returns the event of a node registered at rev
+func put(name, id string, create, rev int64) *clientv3.Event {
+	s := &Service{Name: name, Version: "1.0.0", Nodes: []*Node{{Id: id, Address: "127.0.0.1"}}}
+	return &clientv3.Event{
+		Type: mvccpb.PUT,
+		Kv: &mvccpb.KeyValue{
+			Key:            []byte(nodePath(name, id)),
+			Value:          []byte(encode(s)),
+			CreateRevision: create,
+			ModRevision:    rev,
+		},
+	}
+}
+
+func events(evs ...*clientv3.Event) clientv3.WatchResponse {
+	return clientv3.WatchResponse{Events: evs}
+}
+
+func TestEtcdWatcherResume(t *testing.T) {
+	interval := RewatchInterval
+	RewatchInterval = time.Millisecond
+	defer func() { RewatchInterval = interval }()
+
+	fw := newFakeWatcher()
+	w, err := newEtcdv3Watcher(fw, time.Second, WatchService("test.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	results := make(chan *Result, 10)
+	go func() {
+		for {
+			res, err := w.Next()
+			if err != nil {
+				return
+			}
+			results <- res
+		}
+	}()
+
+	expect := func(action string) {
+		t.Helper()
+		select {
+		case res := <-results:
+			if res.Action != action || res.Service.Nodes[0].Id != "a" {
+				t.Fatalf("unexpected result %s %+v, want %s", res.Action, res.Service, action)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", action)
+		}
+	}
+
+	fwatch := fw.next(t)
+	if fwatch.key != servicePath("test.service")+"/" || fwatch.rev != 0 {
+		t.Fatalf("unexpected watch of %s at %d", fwatch.key, fwatch.rev)
+	}
+
+	fwatch.send(t, events(put("test.service", "a", 5, 5)))
+	expect("create")
+
+	// a failed watch resumes after the last revision seen
+	fwatch.send(t, clientv3.WatchResponse{Canceled: true})
+	fwatch = fw.next(t)
+	if fwatch.rev != 6 {
+		t.Fatalf("expected the watch to resume at 6, got %d", fwatch.rev)
+	}
+
+	// progress notifications move the revision on
+	fwatch.send(t, clientv3.WatchResponse{Header: etcdserverpb.ResponseHeader{Revision: 10}})
+
+	// a closed channel is watched again as well
+	fwatch.close()
+	fwatch = fw.next(t)
+	if fwatch.rev != 11 {
+		t.Fatalf("expected the watch to resume at 11, got %d", fwatch.rev)
+	}
+
+	fwatch.send(t, events(put("test.service", "a", 5, 12)))
+	expect("update")
+}
+
+func TestEtcdWatcherCompaction(t *testing.T) {
+	fw := newFakeWatcher()
+	w, err := newEtcdv3Watcher(fw, time.Second, WatchService("test.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	fwatch := fw.next(t)
+	go fwatch.send(t, clientv3.WatchResponse{CompactRevision: 20})
+
+	// the events lost to the compaction are reloaded by the caller
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Action != "resync" || res.Service.Name != "test.service" {
+		t.Fatalf("unexpected result %s %+v", res.Action, res.Service)
+	}
+
+	if fwatch = fw.next(t); fwatch.rev != 20 {
+		t.Fatalf("expected the watch to resume at 20, got %d", fwatch.rev)
+	}
+}
+
+func TestEtcdWatcherDebounce(t *testing.T) {
+	fw := newFakeWatcher()
+	w, err := newEtcdv3Watcher(fw, time.Second, WatchDebounce(time.Millisecond*50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	fwatch := fw.next(t)
+
+	// a burst of changes to the same node is coalesced
+	fwatch.send(t, events(put("test.service", "a", 1, 1)))
+	fwatch.send(t, events(put("test.service", "a", 1, 2), put("test.service", "b", 3, 3)))
+	fwatch.send(t, events(put("test.service", "a", 1, 4)))
+
+	batch, err := w.(BatchWatcher).NextBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(batch))
+	}
+	if batch[0].Action != "update" || batch[0].Service.Nodes[0].Id != "a" || batch[1].Service.Nodes[0].Id != "b" {
+		t.Fatalf("unexpected batch %s %+v, %s %+v", batch[0].Action, batch[0].Service, batch[1].Action, batch[1].Service)
+	}
+}
+
+func TestEtcdWatcherMaxBatch(t *testing.T) {
+	max := maxBatch
+	maxBatch = 3
+	defer func() { maxBatch = max }()
+
+	fw := newFakeWatcher()
+	w, err := newEtcdv3Watcher(fw, time.Second, WatchDebounce(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	fwatch := fw.next(t)
+
+	// a batch that keeps growing is flushed without waiting
+	go func() {
+		for i := int64(1); i <= 3; i++ {
+			fwatch.send(t, events(put("test.service", fmt.Sprint(i), i, i)))
+		}
+	}()
+
+	done := make(chan []*Result)
+	go func() {
+		batch, _ := w.(BatchWatcher).NextBatch()
+		done <- batch
+	}()
+
+	select {
+	case batch := <-done:
+		if len(batch) != 3 {
+			t.Fatalf("expected 3 results, got %d", len(batch))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the full batch was not flushed")
+	}
+}
+
+func TestEtcdWatcherStop(t *testing.T) {
+	for _, debounce := range []time.Duration{0, time.Millisecond} {
+		fw := newFakeWatcher()
+		w, err := newEtcdv3Watcher(fw, time.Second, WatchDebounce(debounce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fwatch := fw.next(t)
+
+		errs := make(chan error)
+		go func() {
+			_, err := w.Next()
+			errs <- err
+		}()
+
+		w.Stop()
+
+		select {
+		case err := <-errs:
+			if err != ErrWatcherStopped {
+				t.Fatalf("expected %v, got %v", ErrWatcherStopped, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Next blocked after Stop")
+		}
+
+		// the watch is canceled
+		select {
+		case <-fwatch.ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("the watch was not canceled")
+		}
+
+		// stopping twice is fine
+		w.Stop()
+	}
+}
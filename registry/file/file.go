@@ -0,0 +1,219 @@
+// Package file is a static registry read from a file managed by ops,
+// reloaded when the file changes.
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"common/log/log"
+	"common/registry"
+	"common/registry/memory"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	DefaultPollInterval = time.Second * 5
+
+	ErrNoPath = errors.New("file registry: no path")
+)
+
+// fileRegistry keeps the services of the file in a memory registry, a
+// reload registers and deregisters the difference so its watchers see
+// what changed. Services registered in process are kept across reloads.
+type fileRegistry struct {
+	registry.Registry
+
+	options registry.Options
+
+	sync.Mutex
+	path     string
+	interval time.Duration
+	// the content and services of the last load
+	data     []byte
+	services map[string]*registry.Service
+	exit     chan bool
+}
+
+// key of a service version in the file
+func key(s *registry.Service) string {
+	return s.Name + "/" + s.Version
+}
+
+func configure(f *fileRegistry, opts ...registry.Option) error {
+	for _, o := range opts {
+		o(&f.options)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.interval = DefaultPollInterval
+	if f.options.Context != nil {
+		if p, ok := f.options.Context.Value(pathKey{}).(string); ok {
+			f.path = p
+		}
+		if d, ok := f.options.Context.Value(pollKey{}).(time.Duration); ok && d > 0 {
+			f.interval = d
+		}
+	}
+
+	if len(f.path) == 0 {
+		return ErrNoPath
+	}
+
+	// restart polling with the new options, a missing
+	// file is picked up once it is written
+	if f.exit != nil {
+		close(f.exit)
+	}
+	f.exit = make(chan bool)
+	go f.poll(f.exit, f.interval)
+
+	return f.load()
+}
+
+// decode reads services as YAML or JSON depending on the file extension
+func decode(path string, data []byte) ([]*registry.Service, error) {
+	var services []*registry.Service
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &services); err != nil {
+			return nil, err
+		}
+	default:
+		if err := json.Unmarshal(data, &services); err != nil {
+			return nil, err
+		}
+	}
+
+	return services, nil
+}
+
+// load reads the file and applies the changes, called with the lock held
+func (f *fileRegistry) load() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	// unchanged
+	if f.services != nil && bytes.Equal(data, f.data) {
+		return nil
+	}
+
+	list, err := decode(f.path, data)
+	if err != nil {
+		return err
+	}
+
+	services := make(map[string]*registry.Service)
+	for _, s := range list {
+		if s == nil || len(s.Name) == 0 {
+			continue
+		}
+		// a version may be split over several entries
+		if prev, ok := services[key(s)]; ok {
+			prev.Nodes = append(prev.Nodes, s.Nodes...)
+			continue
+		}
+		services[key(s)] = s
+	}
+
+	// remove the nodes gone from the file
+	for k, prev := range f.services {
+		s := services[k]
+
+		var gone []*registry.Node
+		for _, n := range prev.Nodes {
+			if s == nil || !hasNode(s.Nodes, n.Id) {
+				gone = append(gone, n)
+			}
+		}
+		if len(gone) == 0 {
+			continue
+		}
+
+		if err := f.Registry.Deregister(&registry.Service{
+			Name:    prev.Name,
+			Version: prev.Version,
+			Nodes:   gone,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// the memory registry reports new and changed nodes
+	for _, s := range services {
+		if len(s.Nodes) == 0 {
+			continue
+		}
+		if err := f.Registry.Register(s); err != nil {
+			return err
+		}
+	}
+
+	f.data = data
+	f.services = services
+
+	return nil
+}
+
+// poll reloads the file every interval until exit is closed
+func (f *fileRegistry) poll(exit chan bool, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-exit:
+			return
+		case <-t.C:
+			f.Lock()
+			// keep the last good services on errors, ops may be mid edit
+			if err := f.load(); err != nil {
+				log.Errorf("file registry: unable to reload %s: %v", f.path, err)
+			}
+			f.Unlock()
+		}
+	}
+}
+
+func hasNode(nodes []*registry.Node, id string) bool {
+	for _, n := range nodes {
+		if n.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fileRegistry) Init(opts ...registry.Option) error {
+	return configure(f, opts...)
+}
+
+func (f *fileRegistry) Options() registry.Options {
+	return f.options
+}
+
+func (f *fileRegistry) String() string {
+	return "file"
+}
+
+func NewRegistry(opts ...registry.Option) registry.Registry {
+	f := &fileRegistry{
+		Registry: memory.NewRegistry(),
+	}
+	if err := configure(f, opts...); err != nil {
+		log.Errorf("file registry: %v", err)
+	}
+	return f
+}
@@ -0,0 +1,102 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/registry"
+)
+
+const testServices = `
+- name: test.service
+  version: 1.0.0
+  metadata:
+    region: north
+  nodes:
+  - id: a
+    address: 10.0.0.1
+    port: 8080
+  - id: b
+    address: 10.0.0.2
+    port: 8080
+`
+
+func TestFileRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "services.yaml")
+	if err := ioutil.WriteFile(path, []byte(testServices), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry(Path(path), PollInterval(time.Millisecond*10))
+
+	services, err := r.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || len(services[0].Nodes) != 2 || services[0].Metadata["region"] != "north" {
+		t.Fatalf("unexpected services %+v", services)
+	}
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// move b, drop a and add c, the same services as json
+	json := `[{"name": "test.service", "version": "1.0.0", "metadata": {"region": "north"}, "nodes": [
+		{"id": "b", "address": "10.0.0.3", "port": 8080},
+		{"id": "c", "address": "10.0.0.4", "port": 8080}
+	]}]`
+	jsonPath := filepath.Join(dir, "services.json")
+	if err := ioutil.WriteFile(jsonPath, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Init(Path(jsonPath)); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := map[string]string{}
+	for i := 0; i < 3; i++ {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions[res.Service.Nodes[0].Id] = res.Action
+	}
+	if actions["a"] != "delete" || actions["b"] != "update" || actions["c"] != "create" {
+		t.Fatalf("unexpected results %v", actions)
+	}
+
+	// an edit is picked up by polling, a broken file is ignored
+	if err := ioutil.WriteFile(jsonPath, []byte(`[{"name": "test.service"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if err := ioutil.WriteFile(jsonPath, []byte(`[]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Action != "delete" {
+			t.Fatalf("unexpected result %s %+v", res.Action, res.Service)
+		}
+	}
+
+	if _, err := r.GetService("test.service"); err != registry.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
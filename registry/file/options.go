@@ -0,0 +1,33 @@
+package file
+
+import (
+	"context"
+	"time"
+
+	"common/registry"
+)
+
+type pathKey struct{}
+
+type pollKey struct{}
+
+// Path of the YAML or JSON file listing the services,
+// .yaml and .yml files are read as YAML.
+func Path(p string) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, pathKey{}, p)
+	}
+}
+
+// PollInterval sets how often the file is checked for changes
+func PollInterval(d time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, pollKey{}, d)
+	}
+}
@@ -0,0 +1,492 @@
+// Package mdns is a multicast dns registry for discovery on a LAN
+// without etcd.
+package mdns
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"common/log/log"
+	"common/registry"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+)
+
+var (
+	DefaultAddress = "224.0.0.251:5353"
+	DefaultDomain  = "micro"
+	// DefaultTimeout is how long a lookup waits for answers
+	DefaultTimeout = time.Millisecond * 100
+	// DefaultTTL of the records when registered without a TTL
+	DefaultTTL = time.Second * 120
+)
+
+// localNode is a node registered by this process
+type localNode struct {
+	txt *mdnsTxt
+	ttl uint32
+}
+
+type mdnsRegistry struct {
+	options registry.Options
+
+	sync.Mutex
+	typeName string
+	// conn receives the group traffic, send writes to the group
+	conn  *net.UDPConn
+	send  *net.UDPConn
+	group *net.UDPAddr
+	// the address and interface the conn joined
+	joined string
+	// the nodes we answer for, keyed by id
+	nodes    map[string]*localNode
+	lookups  map[chan *dns.Msg]bool
+	watchers map[*mdnsWatcher]bool
+}
+
+func configure(m *mdnsRegistry, opts ...registry.Option) error {
+	for _, o := range opts {
+		o(&m.options)
+	}
+
+	if m.options.Timeout == 0 {
+		m.options.Timeout = DefaultTimeout
+	}
+
+	domain := DefaultDomain
+	if m.options.Context != nil {
+		if d, ok := m.options.Context.Value(domainKey{}).(string); ok && len(d) > 0 {
+			domain = d
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.typeName = "_" + domain + "._tcp.local."
+
+	// the group is joined again when its address or interface changed,
+	// at once when watchers or nodes are served
+	if m.conn == nil {
+		return nil
+	}
+	if address, iface := m.endpoint(); address+"%"+iface == m.joined {
+		return nil
+	}
+	m.stop()
+	if len(m.watchers) > 0 || len(m.nodes) > 0 {
+		return m.start()
+	}
+
+	return nil
+}
+
+// endpoint returns the address of the group and the name of
+// the interface joining it, empty for the default interface
+func (m *mdnsRegistry) endpoint() (string, string) {
+	address := DefaultAddress
+	if len(m.options.Addrs) > 0 && len(m.options.Addrs[0]) > 0 {
+		address = m.options.Addrs[0]
+	}
+
+	var iface string
+	if m.options.Context != nil {
+		if name, ok := m.options.Context.Value(interfaceKey{}).(string); ok {
+			iface = name
+		}
+	}
+
+	return address, iface
+}
+
+// start joins the multicast group, called with the lock held
+func (m *mdnsRegistry) start() error {
+	if m.conn != nil {
+		return nil
+	}
+
+	address, name := m.endpoint()
+
+	group, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return err
+	}
+
+	var iface *net.Interface
+	if len(name) > 0 {
+		if iface, err = net.InterfaceByName(name); err != nil {
+			return err
+		}
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", iface, group)
+	if err != nil {
+		return err
+	}
+
+	send, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// other registries in this process listen on the group too
+	p := ipv4.NewPacketConn(send)
+	if iface != nil {
+		if err := p.SetMulticastInterface(iface); err != nil {
+			conn.Close()
+			send.Close()
+			return err
+		}
+	}
+	if err := p.SetMulticastLoopback(true); err != nil {
+		conn.Close()
+		send.Close()
+		return err
+	}
+
+	m.conn = conn
+	m.send = send
+	m.group = group
+	m.joined = address + "%" + name
+
+	go m.serve(conn, send, group)
+
+	return nil
+}
+
+// stop leaves the multicast group, called with the lock held
+func (m *mdnsRegistry) stop() {
+	if m.conn == nil {
+		return
+	}
+	m.conn.Close()
+	m.send.Close()
+	m.conn = nil
+	m.send = nil
+	m.group = nil
+	m.joined = ""
+}
+
+// serve answers the queries and passes the responses on to
+// lookups and watchers until the connection is closed.
+func (m *mdnsRegistry) serve(conn, send *net.UDPConn, group *net.UDPAddr) {
+	buf := make([]byte, 65536)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		msg := new(dns.Msg)
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		if !msg.Response {
+			m.answer(msg, send, group)
+			continue
+		}
+
+		m.Lock()
+		for ch := range m.lookups {
+			select {
+			case ch <- msg:
+			default:
+			}
+		}
+		for w := range m.watchers {
+			select {
+			case w.msgs <- msg:
+			default:
+				// announcements are repeated on the next register
+			}
+		}
+		m.Unlock()
+	}
+}
+
+// answer sends the records of our nodes asked for by a query
+func (m *mdnsRegistry) answer(q *dns.Msg, send *net.UDPConn, group *net.UDPAddr) {
+	m.Lock()
+	var nodes []*localNode
+	for _, question := range q.Question {
+		for _, n := range m.nodes {
+			if question.Name == m.typeName || question.Name == instanceName(n.txt.Node.Id, m.typeName) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	typeName := m.typeName
+	m.Unlock()
+
+	for _, n := range nodes {
+		if err := announce(send, group, n.txt, typeName, n.ttl); err != nil {
+			log.Errorf("mdns: unable to answer for %s: %v", n.txt.Node.Id, err)
+		}
+	}
+}
+
+// announce sends the records of a node to the group
+func announce(send *net.UDPConn, group *net.UDPAddr, t *mdnsTxt, typeName string, ttl uint32) error {
+	rrs, err := records(t, typeName, ttl)
+	if err != nil {
+		return err
+	}
+
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Answer = rrs
+
+	b, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	_, err = send.WriteToUDP(b, group)
+	return err
+}
+
+// announce sends the records of our nodes, a zero ttl says goodbye
+func (m *mdnsRegistry) announce(txts []*mdnsTxt, ttl uint32) error {
+	m.Lock()
+	if err := m.start(); err != nil {
+		m.Unlock()
+		return err
+	}
+	send, group, typeName := m.send, m.group, m.typeName
+	m.Unlock()
+
+	for _, t := range txts {
+		if err := announce(send, group, t, typeName, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup queries the group for the nodes of the service type, the
+// answers are collected until the timeout.
+func (m *mdnsRegistry) lookup() ([]*mdnsTxt, error) {
+	ch := make(chan *dns.Msg, 64)
+
+	m.Lock()
+	if err := m.start(); err != nil {
+		m.Unlock()
+		return nil, err
+	}
+	send, group, typeName := m.send, m.group, m.typeName
+
+	// our own nodes are known without asking
+	found := make(map[string]*mdnsTxt)
+	for id, n := range m.nodes {
+		if t, err := copyTxt(n.txt); err == nil {
+			found[id] = t
+		}
+	}
+
+	m.lookups[ch] = true
+	m.Unlock()
+
+	defer func() {
+		m.Lock()
+		delete(m.lookups, ch)
+		m.Unlock()
+	}()
+
+	q := new(dns.Msg)
+	q.SetQuestion(typeName, dns.TypePTR)
+	q.RecursionDesired = false
+
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := send.WriteToUDP(b, group); err != nil {
+		return nil, err
+	}
+
+	timeout := time.NewTimer(m.options.Timeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case msg := <-ch:
+			for _, e := range entries(msg, typeName) {
+				if e.ttl == 0 {
+					delete(found, e.txt.Node.Id)
+					continue
+				}
+				found[e.txt.Node.Id] = e.txt
+			}
+		case <-timeout.C:
+			txts := make([]*mdnsTxt, 0, len(found))
+			for _, t := range found {
+				txts = append(txts, t)
+			}
+			return txts, nil
+		}
+	}
+}
+
+func (m *mdnsRegistry) Init(opts ...registry.Option) error {
+	return configure(m, opts...)
+}
+
+func (m *mdnsRegistry) Options() registry.Options {
+	return m.options
+}
+
+func (m *mdnsRegistry) Register(s *registry.Service, opts ...registry.RegisterOption) error {
+	if len(s.Nodes) == 0 {
+		return errors.New("Require at least one node ")
+	}
+
+	var options registry.RegisterOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	var txts []*mdnsTxt
+
+	for _, node := range s.Nodes {
+		t, err := copyTxt(&mdnsTxt{
+			Service:   s.Name,
+			Version:   s.Version,
+			Metadata:  s.Metadata,
+			Endpoints: s.Endpoints,
+			Node:      node,
+		})
+		if err != nil {
+			return err
+		}
+		txts = append(txts, t)
+	}
+
+	m.Lock()
+	for _, t := range txts {
+		m.nodes[t.Node.Id] = &localNode{
+			txt: t,
+			ttl: uint32(ttl.Seconds()),
+		}
+	}
+	m.Unlock()
+
+	return m.announce(txts, uint32(ttl.Seconds()))
+}
+
+func (m *mdnsRegistry) Deregister(s *registry.Service) error {
+	if len(s.Nodes) == 0 {
+		return errors.New("Require at least one node ")
+	}
+
+	var txts []*mdnsTxt
+
+	m.Lock()
+	for _, node := range s.Nodes {
+		if n, ok := m.nodes[node.Id]; ok {
+			delete(m.nodes, node.Id)
+			txts = append(txts, n.txt)
+		}
+	}
+	m.Unlock()
+
+	return m.announce(txts, 0)
+}
+
+func (m *mdnsRegistry) GetService(name string) ([]*registry.Service, error) {
+	txts, err := m.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	serviceMap := map[string]*registry.Service{}
+
+	for _, t := range txts {
+		if t.Service != name {
+			continue
+		}
+		s, ok := serviceMap[t.Version]
+		if !ok {
+			s = &registry.Service{
+				Name:      t.Service,
+				Version:   t.Version,
+				Metadata:  t.Metadata,
+				Endpoints: t.Endpoints,
+			}
+			serviceMap[t.Version] = s
+		}
+		s.Nodes = append(s.Nodes, t.Node)
+	}
+
+	if len(serviceMap) == 0 {
+		return nil, registry.ErrNotFound
+	}
+
+	var services []*registry.Service
+	for _, s := range serviceMap {
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+func (m *mdnsRegistry) ListServices() ([]*registry.Service, error) {
+	txts, err := m.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	nameSet := make(map[string]struct{})
+	for _, t := range txts {
+		nameSet[t.Service] = struct{}{}
+	}
+
+	services := []*registry.Service{}
+	for name := range nameSet {
+		services = append(services, &registry.Service{Name: name})
+	}
+	return services, nil
+}
+
+func (m *mdnsRegistry) Watch(opts ...registry.WatchOption) (registry.Watcher, error) {
+	var wo registry.WatchOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if err := m.start(); err != nil {
+		return nil, err
+	}
+
+	w := newWatcher(m, wo, m.typeName)
+	m.watchers[w] = true
+
+	return w, nil
+}
+
+func (m *mdnsRegistry) String() string {
+	return "mdns"
+}
+
+func NewRegistry(opts ...registry.Option) registry.Registry {
+	m := &mdnsRegistry{
+		nodes:    make(map[string]*localNode),
+		lookups:  make(map[chan *dns.Msg]bool),
+		watchers: make(map[*mdnsWatcher]bool),
+	}
+	_ = configure(m, opts...)
+	return m
+}
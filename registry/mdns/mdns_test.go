@@ -0,0 +1,229 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"common/client"
+	"common/registry"
+	"common/server"
+	"common/transport"
+
+	"github.com/miekg/dns"
+)
+
+type Test struct{}
+
+type TestMessage struct {
+	Name string `json:"name"`
+}
+
+func (t *Test) Echo(ctx context.Context, req *TestMessage, rsp *TestMessage) error {
+	rsp.Name = req.Name
+	return nil
+}
+
+// newTestRegistry keeps the multicast traffic on the loopback
+// interface and off the mdns port
+func newTestRegistry(t *testing.T, port int) registry.Registry {
+	r := NewRegistry(
+		registry.Addrs(fmt.Sprintf("224.0.0.251:%d", port)),
+		registry.Timeout(time.Millisecond*200),
+		Interface("lo"),
+	)
+	if _, err := r.ListServices(); err != nil {
+		t.Skipf("multicast on loopback unavailable: %v", err)
+	}
+	return r
+}
+
+func freePort(t *testing.T) int {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestMDNSRegistry(t *testing.T) {
+	port := freePort(t)
+	a := newTestRegistry(t, port)
+	b := newTestRegistry(t, port)
+
+	w, err := b.Watch(registry.WatchService("test.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	s := &registry.Service{
+		Name:    "test.service",
+		Version: "1.0.0",
+		Nodes: []*registry.Node{{
+			Id:       "test.service-1",
+			Address:  "127.0.0.1",
+			Port:     8080,
+			Metadata: map[string]string{"protocol": "mucp"},
+		}},
+	}
+	if err := a.Register(s); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := b.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Metadata["protocol"] != "mucp" {
+		t.Fatalf("unexpected services %+v", services)
+	}
+
+	list, err := b.ListServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "test.service" {
+		t.Fatalf("unexpected service list %+v", list)
+	}
+
+	s.Nodes[0].Port = 9090
+	if err := a.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Deregister(s); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, action := range []string{"create", "update", "delete"} {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Action != action || res.Service.Nodes[0].Id != "test.service-1" {
+			t.Fatalf("unexpected result %s %+v, want %s", res.Action, res.Service, action)
+		}
+	}
+
+	if _, err := b.GetService("test.service"); err != registry.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMDNSInit(t *testing.T) {
+	port := freePort(t)
+	a := newTestRegistry(t, port)
+	b := newTestRegistry(t, freePort(t))
+
+	w, err := b.Watch(registry.WatchService("test.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// the watcher keeps receiving once the group is moved
+	if err := b.Init(registry.Addrs(fmt.Sprintf("224.0.0.251:%d", port))); err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan *registry.Result, 1)
+	go func() {
+		if res, err := w.Next(); err == nil {
+			results <- res
+		}
+	}()
+
+	if err := a.Register(&registry.Service{
+		Name:    "test.service",
+		Version: "1.0.0",
+		Nodes:   []*registry.Node{{Id: "test.service-1", Address: "127.0.0.1", Port: 8080}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-results:
+		if res.Action != "create" {
+			t.Fatalf("unexpected result %s %+v", res.Action, res.Service)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the watcher received nothing after Init")
+	}
+}
+
+func TestMDNSClient(t *testing.T) {
+	port := freePort(t)
+	tr := transport.NewMemoryTransport()
+
+	srv := server.NewServer(
+		server.Name("test.service"),
+		server.Address("127.0.0.1:0"),
+		server.Transport(tr),
+		server.Registry(newTestRegistry(t, port)),
+	)
+	if err := srv.Handle(srv.NewHandler(&Test{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	c := client.NewClient(client.Transport(tr), client.Registry(newTestRegistry(t, port)))
+
+	var rsp TestMessage
+	req := c.NewRequest("test.service", "Test.Echo", &TestMessage{Name: "John"})
+	if err := c.Call(context.Background(), req, &rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Name != "John" {
+		t.Fatalf("unexpected response %+v", rsp)
+	}
+}
+
+func TestMDNSRecordSize(t *testing.T) {
+	schema := &registry.Value{Name: "TestMessage", Type: "TestMessage"}
+	for i := 0; i < 20; i++ {
+		schema.Values = append(schema.Values, &registry.Value{Name: fmt.Sprint("field", i), Type: "string"})
+	}
+
+	txt := &mdnsTxt{
+		Service: "test.service",
+		Version: "1.0.0",
+		Node:    &registry.Node{Id: "test.service-1", Address: "127.0.0.1", Port: 8080},
+	}
+	for i := 0; i < 500; i++ {
+		txt.Endpoints = append(txt.Endpoints, &registry.Endpoint{
+			Name:     fmt.Sprint("Test.Endpoint", i),
+			Request:  schema,
+			Response: schema,
+		})
+	}
+
+	for _, n := range []int{5, 500} {
+		txt.Endpoints = txt.Endpoints[:n]
+
+		rrs, err := records(txt, "_test._tcp.local.", 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := new(dns.Msg)
+		msg.Answer = rrs
+		if msg.Len() > 9000 {
+			t.Fatalf("%d endpoints announced in %d bytes", n, msg.Len())
+		}
+
+		es := entries(msg, "_test._tcp.local.")
+		if len(es) != 1 {
+			t.Fatalf("unexpected entries %v", es)
+		}
+		// the endpoints are announced without schemas while they fit
+		eps := es[0].txt.Endpoints
+		if n == 5 && (len(eps) != 5 || eps[0].Request != nil) || n == 500 && len(eps) != 0 {
+			t.Fatalf("%d endpoints announced as %d", n, len(eps))
+		}
+	}
+}
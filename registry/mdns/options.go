@@ -0,0 +1,33 @@
+package mdns
+
+import (
+	"context"
+
+	"common/registry"
+)
+
+type domainKey struct{}
+
+type interfaceKey struct{}
+
+// Domain sets the service type the nodes are announced under,
+// _<domain>._tcp.local. which defaults to _micro._tcp.local.
+func Domain(d string) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, domainKey{}, d)
+	}
+}
+
+// Interface sets the network interface joined to the multicast group,
+// e.g. lo to keep discovery on the host.
+func Interface(name string) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, interfaceKey{}, name)
+	}
+}
@@ -0,0 +1,181 @@
+package mdns
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+
+	"common/registry"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// txtChunk is the longest string of a TXT record
+	txtChunk = 255
+	// maxTxtSize keeps the records of a node within the 9000 bytes
+	// of an mdns message
+	maxTxtSize = 8000
+)
+
+// mdnsTxt is the content of the TXT record of a node
+type mdnsTxt struct {
+	Service   string               `json:"service"`
+	Version   string               `json:"version"`
+	Metadata  map[string]string    `json:"metadata"`
+	Endpoints []*registry.Endpoint `json:"endpoints"`
+	Node      *registry.Node       `json:"node"`
+}
+
+// entry is a node read from a response, a zero ttl says goodbye
+type entry struct {
+	txt *mdnsTxt
+	ttl uint32
+}
+
+func (t *mdnsTxt) service() *registry.Service {
+	return &registry.Service{
+		Name:      t.Service,
+		Version:   t.Version,
+		Metadata:  t.Metadata,
+		Endpoints: t.Endpoints,
+		Nodes:     []*registry.Node{t.Node},
+	}
+}
+
+// copyTxt keeps the registered service from being changed by the caller
+func copyTxt(t *mdnsTxt) (*mdnsTxt, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var c mdnsTxt
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// encodeTxt returns the TXT strings of a node. The schemas of the
+// endpoints are left out, their names and metadata are only kept while
+// the record fits in a message.
+func encodeTxt(t *mdnsTxt) ([]string, error) {
+	c := *t
+	c.Endpoints = make([]*registry.Endpoint, len(t.Endpoints))
+	for i, ep := range t.Endpoints {
+		c.Endpoints[i] = &registry.Endpoint{Name: ep.Name, Metadata: ep.Metadata}
+	}
+
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return nil, err
+	}
+	if base64.StdEncoding.EncodedLen(len(b)) > maxTxtSize {
+		c.Endpoints = nil
+		if b, err = json.Marshal(&c); err != nil {
+			return nil, err
+		}
+	}
+	if n := base64.StdEncoding.EncodedLen(len(b)); n > maxTxtSize {
+		return nil, fmt.Errorf("mdns: txt record of %d bytes is too large", n)
+	}
+
+	s := base64.StdEncoding.EncodeToString(b)
+
+	var txt []string
+	for len(s) > txtChunk {
+		txt = append(txt, s[:txtChunk])
+		s = s[txtChunk:]
+	}
+	return append(txt, s), nil
+}
+
+func decodeTxt(txt []string) (*mdnsTxt, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.Join(txt, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	var t mdnsTxt
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	if t.Node == nil {
+		return nil, fmt.Errorf("mdns: no node in txt record")
+	}
+	return &t, nil
+}
+
+// instanceName names a node under the service type, node ids are
+// hashed as they can be longer than a dns label.
+func instanceName(id, typeName string) string {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return fmt.Sprintf("%x.%s", h.Sum64(), typeName)
+}
+
+// records returns the PTR, SRV, TXT and A records announcing a node
+func records(t *mdnsTxt, typeName string, ttl uint32) ([]dns.RR, error) {
+	txt, err := encodeTxt(t)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := instanceName(t.Node.Id, typeName)
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{
+			Name:   name,
+			Rrtype: rrtype,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		}
+	}
+
+	rrs := []dns.RR{
+		&dns.PTR{
+			Hdr: hdr(typeName, dns.TypePTR),
+			Ptr: instance,
+		},
+		&dns.SRV{
+			Hdr:    hdr(instance, dns.TypeSRV),
+			Port:   uint16(t.Node.Port),
+			Target: instance,
+		},
+		&dns.TXT{
+			Hdr: hdr(instance, dns.TypeTXT),
+			Txt: txt,
+		},
+	}
+
+	if ip := net.ParseIP(t.Node.Address).To4(); ip != nil {
+		rrs = append(rrs, &dns.A{
+			Hdr: hdr(instance, dns.TypeA),
+			A:   ip,
+		})
+	}
+
+	return rrs, nil
+}
+
+// entries returns the nodes of a response under the service type
+func entries(msg *dns.Msg, typeName string) []*entry {
+	var es []*entry
+
+	for _, rr := range append(msg.Answer, msg.Extra...) {
+		txt, ok := rr.(*dns.TXT)
+		if !ok || !strings.HasSuffix(txt.Hdr.Name, "."+typeName) {
+			continue
+		}
+		t, err := decodeTxt(txt.Txt)
+		if err != nil {
+			continue
+		}
+		es = append(es, &entry{txt: t, ttl: txt.Hdr.Ttl})
+	}
+
+	return es
+}
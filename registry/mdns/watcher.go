@@ -0,0 +1,124 @@
+package mdns
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"common/registry"
+
+	"github.com/miekg/dns"
+)
+
+// watched is a node seen by the watcher
+type watched struct {
+	txt    *mdnsTxt
+	expiry time.Time
+}
+
+type mdnsWatcher struct {
+	opts     registry.WatchOptions
+	typeName string
+	msgs     chan *dns.Msg
+	exit     chan bool
+	once     sync.Once
+	stop     func()
+
+	nodes   map[string]*watched
+	pending []*registry.Result
+}
+
+func newWatcher(m *mdnsRegistry, opts registry.WatchOptions, typeName string) *mdnsWatcher {
+	w := &mdnsWatcher{
+		opts:     opts,
+		typeName: typeName,
+		msgs:     make(chan *dns.Msg, 128),
+		exit:     make(chan bool),
+		nodes:    make(map[string]*watched),
+	}
+	w.stop = func() {
+		m.Lock()
+		delete(m.watchers, w)
+		m.Unlock()
+	}
+	return w
+}
+
+func (w *mdnsWatcher) result(action string, t *mdnsTxt) {
+	w.pending = append(w.pending, &registry.Result{
+		Action:  action,
+		Service: t.service(),
+	})
+}
+
+func (w *mdnsWatcher) update(msg *dns.Msg) {
+	now := time.Now()
+
+	for _, e := range entries(msg, w.typeName) {
+		if len(w.opts.Service) > 0 && e.txt.Service != w.opts.Service {
+			continue
+		}
+
+		id := e.txt.Node.Id
+		prev, ok := w.nodes[id]
+
+		if e.ttl == 0 {
+			if ok {
+				delete(w.nodes, id)
+				w.result("delete", e.txt)
+			}
+			continue
+		}
+
+		w.nodes[id] = &watched{
+			txt:    e.txt,
+			expiry: now.Add(time.Duration(e.ttl) * time.Second),
+		}
+
+		switch {
+		case !ok:
+			w.result("create", e.txt)
+		case !reflect.DeepEqual(prev.txt, e.txt):
+			w.result("update", e.txt)
+		}
+	}
+}
+
+func (w *mdnsWatcher) prune() {
+	now := time.Now()
+	for id, n := range w.nodes {
+		if now.After(n.expiry) {
+			delete(w.nodes, id)
+			w.result("delete", n.txt)
+		}
+	}
+}
+
+func (w *mdnsWatcher) Next() (*registry.Result, error) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		if len(w.pending) > 0 {
+			r := w.pending[0]
+			w.pending = w.pending[1:]
+			return r, nil
+		}
+
+		select {
+		case msg := <-w.msgs:
+			w.update(msg)
+		case <-t.C:
+			w.prune()
+		case <-w.exit:
+			return nil, registry.ErrWatcherStopped
+		}
+	}
+}
+
+func (w *mdnsWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.exit)
+		w.stop()
+	})
+}
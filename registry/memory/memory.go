@@ -0,0 +1,340 @@
+// Package memory is an in process registry for tests and single
+// process deployments.
+package memory
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"common/registry"
+)
+
+var (
+	DefaultPruneInterval = time.Second
+)
+
+type node struct {
+	*registry.Node
+	ttl      time.Duration
+	lastSeen time.Time
+}
+
+func (n *node) expired(now time.Time) bool {
+	return n.ttl > 0 && now.Sub(n.lastSeen) > n.ttl
+}
+
+// record is a version of a service, its nodes keyed by id
+type record struct {
+	service *registry.Service
+	nodes   map[string]*node
+}
+
+type memRegistry struct {
+	options registry.Options
+
+	sync.RWMutex
+	// name to version to record
+	records  map[string]map[string]*record
+	watchers map[*memWatcher]bool
+	// the prune loop runs while there are nodes with a TTL
+	pruning bool
+}
+
+func configure(m *memRegistry, opts ...registry.Option) error {
+	for _, o := range opts {
+		o(&m.options)
+	}
+
+	if m.options.Context == nil {
+		return nil
+	}
+
+	services, ok := m.options.Context.Value(servicesKey{}).(map[string][]*registry.Service)
+	if !ok {
+		return nil
+	}
+
+	for _, list := range services {
+		for _, s := range list {
+			if err := m.Register(s); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *memRegistry) pruneInterval() time.Duration {
+	if m.options.Context != nil {
+		if d, ok := m.options.Context.Value(pruneKey{}).(time.Duration); ok && d > 0 {
+			return d
+		}
+	}
+	return DefaultPruneInterval
+}
+
+// prune removes the nodes past their TTL until none with a TTL is left
+func (m *memRegistry) prune() {
+	t := time.NewTicker(m.pruneInterval())
+	defer t.Stop()
+
+	for range t.C {
+		now := time.Now()
+		ttls := 0
+
+		m.Lock()
+		for name, versions := range m.records {
+			for version, r := range versions {
+				for id, n := range r.nodes {
+					if n.expired(now) {
+						delete(r.nodes, id)
+						m.notify("delete", r.service, n.Node)
+						continue
+					}
+					if n.ttl > 0 {
+						ttls++
+					}
+				}
+				if len(r.nodes) == 0 {
+					delete(versions, version)
+				}
+			}
+			if len(versions) == 0 {
+				delete(m.records, name)
+			}
+		}
+
+		if ttls == 0 {
+			m.pruning = false
+			m.Unlock()
+			return
+		}
+		m.Unlock()
+	}
+}
+
+// notify sends the change of a node to the watchers without blocking,
+// called with the lock held
+func (m *memRegistry) notify(action string, s *registry.Service, n *registry.Node) {
+	if len(m.watchers) == 0 {
+		return
+	}
+
+	svc := copyService(s)
+	svc.Nodes = []*registry.Node{copyNode(n)}
+
+	for w := range m.watchers {
+		if len(w.opts.Service) > 0 && w.opts.Service != s.Name {
+			continue
+		}
+		select {
+		case w.res <- &registry.Result{Action: action, Service: svc}:
+		default:
+			// a watcher falling behind is stopped rather than blocking
+			// the registry, it gets ErrWatcherStopped and watches again
+			delete(m.watchers, w)
+			w.once.Do(func() { close(w.exit) })
+		}
+	}
+}
+
+func (m *memRegistry) Init(opts ...registry.Option) error {
+	return configure(m, opts...)
+}
+
+func (m *memRegistry) Options() registry.Options {
+	return m.options
+}
+
+func (m *memRegistry) Register(s *registry.Service, opts ...registry.RegisterOption) error {
+	if len(s.Nodes) == 0 {
+		return errors.New("Require at least one node ")
+	}
+
+	var options registry.RegisterOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	versions, ok := m.records[s.Name]
+	if !ok {
+		versions = make(map[string]*record)
+		m.records[s.Name] = versions
+	}
+
+	service := copyService(s)
+	service.Nodes = nil
+
+	r, ok := versions[s.Version]
+	if !ok {
+		r = &record{nodes: make(map[string]*node)}
+		versions[s.Version] = r
+	}
+
+	// a changed service updates all of its nodes
+	changed := ok && !equalService(r.service, service)
+	r.service = service
+
+	now := time.Now()
+
+	for _, n := range s.Nodes {
+		prev, ok := r.nodes[n.Id]
+		r.nodes[n.Id] = &node{
+			Node:     copyNode(n),
+			ttl:      options.TTL,
+			lastSeen: now,
+		}
+
+		switch {
+		case !ok:
+			m.notify("create", service, n)
+		case changed || !equalNode(prev.Node, n):
+			m.notify("update", service, n)
+		}
+	}
+
+	if changed {
+		for id, n := range r.nodes {
+			if !hasNode(s.Nodes, id) {
+				m.notify("update", service, n.Node)
+			}
+		}
+	}
+
+	if options.TTL > 0 && !m.pruning {
+		m.pruning = true
+		go m.prune()
+	}
+
+	return nil
+}
+
+func (m *memRegistry) Deregister(s *registry.Service) error {
+	if len(s.Nodes) == 0 {
+		return errors.New("Require at least one node ")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	versions, ok := m.records[s.Name]
+	if !ok {
+		return nil
+	}
+
+	r, ok := versions[s.Version]
+	if !ok {
+		return nil
+	}
+
+	for _, n := range s.Nodes {
+		if prev, ok := r.nodes[n.Id]; ok {
+			delete(r.nodes, n.Id)
+			m.notify("delete", r.service, prev.Node)
+		}
+	}
+
+	if len(r.nodes) == 0 {
+		delete(versions, s.Version)
+	}
+	if len(versions) == 0 {
+		delete(m.records, s.Name)
+	}
+
+	return nil
+}
+
+func (m *memRegistry) GetService(name string) ([]*registry.Service, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	now := time.Now()
+
+	var services []*registry.Service
+	for _, r := range m.records[name] {
+		s := copyService(r.service)
+		for _, n := range r.nodes {
+			if !n.expired(now) {
+				s.Nodes = append(s.Nodes, copyNode(n.Node))
+			}
+		}
+		if len(s.Nodes) > 0 {
+			services = append(services, s)
+		}
+	}
+
+	if len(services) == 0 {
+		return nil, registry.ErrNotFound
+	}
+	return services, nil
+}
+
+func (m *memRegistry) ListServices() ([]*registry.Service, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	now := time.Now()
+
+	services := []*registry.Service{}
+	for name, versions := range m.records {
+		if live(versions, now) {
+			services = append(services, &registry.Service{Name: name})
+		}
+	}
+	return services, nil
+}
+
+func (m *memRegistry) Watch(opts ...registry.WatchOption) (registry.Watcher, error) {
+	var wo registry.WatchOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+
+	w := &memWatcher{
+		opts: wo,
+		res:  make(chan *registry.Result, 64),
+		exit: make(chan bool),
+	}
+
+	m.Lock()
+	m.watchers[w] = true
+	m.Unlock()
+
+	w.stop = func() {
+		m.Lock()
+		delete(m.watchers, w)
+		m.Unlock()
+	}
+
+	return w, nil
+}
+
+func (m *memRegistry) String() string {
+	return "memory"
+}
+
+// live reports whether any version has an unexpired node
+func live(versions map[string]*record, now time.Time) bool {
+	for _, r := range versions {
+		for _, n := range r.nodes {
+			if !n.expired(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func NewRegistry(opts ...registry.Option) registry.Registry {
+	m := &memRegistry{
+		records:  make(map[string]map[string]*record),
+		watchers: make(map[*memWatcher]bool),
+	}
+	_ = configure(m, opts...)
+	return m
+}
@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"common/registry"
+)
+
+func testService(version string, ids ...string) *registry.Service {
+	s := &registry.Service{Name: "test.service", Version: version}
+	for _, id := range ids {
+		s.Nodes = append(s.Nodes, &registry.Node{Id: id, Address: "127.0.0.1", Port: 8080})
+	}
+	return s
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	r := NewRegistry(Services(map[string][]*registry.Service{
+		"test.service": {testService("1.0.0", "a", "b")},
+	}))
+
+	services, err := r.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || len(services[0].Nodes) != 2 {
+		t.Fatalf("unexpected seeded services %+v", services)
+	}
+
+	if err := r.Register(testService("2.0.0", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if services, _ := r.GetService("test.service"); len(services) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(services))
+	}
+
+	if err := r.Deregister(testService("1.0.0", "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if services, _ := r.GetService("test.service"); len(services) != 1 || services[0].Version != "2.0.0" {
+		t.Fatalf("unexpected services %+v", services)
+	}
+
+	if err := r.Deregister(testService("2.0.0", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetService("test.service"); err != registry.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if list, _ := r.ListServices(); len(list) != 0 {
+		t.Fatalf("unexpected services %+v", list)
+	}
+}
+
+func TestMemoryWatch(t *testing.T) {
+	r := NewRegistry(PruneInterval(time.Millisecond * 10))
+
+	w, err := r.Watch(registry.WatchService("test.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	s := testService("1.0.0", "a")
+	if err := r.Register(s, registry.RegisterTTL(time.Millisecond*50)); err != nil {
+		t.Fatal(err)
+	}
+
+	// another service is not seen by the watcher
+	if err := r.Register(&registry.Service{Name: "other", Nodes: s.Nodes}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Nodes[0].Port = 9090
+	if err := r.Register(s, registry.RegisterTTL(time.Millisecond*50)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node expires once it is no longer registered
+	for _, action := range []string{"create", "update", "delete"} {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Action != action || res.Service.Name != "test.service" || res.Service.Nodes[0].Id != "a" {
+			t.Fatalf("unexpected result %s %+v, want %s", res.Action, res.Service, action)
+		}
+	}
+
+	if _, err := r.GetService("test.service"); err != registry.ErrNotFound {
+		t.Fatalf("expected the node to expire, got %v", err)
+	}
+
+	w.Stop()
+	if _, err := w.Next(); err != registry.ErrWatcherStopped {
+		t.Fatalf("expected ErrWatcherStopped, got %v", err)
+	}
+}
+
+func TestMemoryWatchOverflow(t *testing.T) {
+	r := NewRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// registering never blocks on a watcher not reading its results
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			r.Register(testService("1.0.0", fmt.Sprint(i)))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("register blocked on a watcher")
+	}
+
+	// the watcher fell behind and was stopped
+	for {
+		if _, err := w.Next(); err != nil {
+			if err != registry.ErrWatcherStopped {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"common/registry"
+)
+
+type servicesKey struct{}
+
+type pruneKey struct{}
+
+// Services seeds the registry with services keyed by name,
+// seeded nodes never expire.
+func Services(s map[string][]*registry.Service) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, servicesKey{}, s)
+	}
+}
+
+// PruneInterval sets how often nodes past their TTL are removed
+func PruneInterval(d time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, pruneKey{}, d)
+	}
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"reflect"
+
+	"common/registry"
+)
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func copyValue(v *registry.Value) *registry.Value {
+	if v == nil {
+		return nil
+	}
+	c := &registry.Value{Name: v.Name, Type: v.Type}
+	for _, sv := range v.Values {
+		c.Values = append(c.Values, copyValue(sv))
+	}
+	return c
+}
+
+func copyNode(n *registry.Node) *registry.Node {
+	return &registry.Node{
+		Id:       n.Id,
+		Address:  n.Address,
+		Port:     n.Port,
+		Metadata: copyMap(n.Metadata),
+	}
+}
+
+func copyService(s *registry.Service) *registry.Service {
+	c := &registry.Service{
+		Name:     s.Name,
+		Version:  s.Version,
+		Metadata: copyMap(s.Metadata),
+	}
+	for _, ep := range s.Endpoints {
+		c.Endpoints = append(c.Endpoints, &registry.Endpoint{
+			Name:     ep.Name,
+			Request:  copyValue(ep.Request),
+			Response: copyValue(ep.Response),
+			Metadata: copyMap(ep.Metadata),
+		})
+	}
+	for _, n := range s.Nodes {
+		c.Nodes = append(c.Nodes, copyNode(n))
+	}
+	return c
+}
+
+// equalService compares services without their nodes
+func equalService(a, b *registry.Service) bool {
+	return a.Name == b.Name && a.Version == b.Version &&
+		reflect.DeepEqual(a.Metadata, b.Metadata) &&
+		reflect.DeepEqual(a.Endpoints, b.Endpoints)
+}
+
+func equalNode(a, b *registry.Node) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func hasNode(nodes []*registry.Node, id string) bool {
+	for _, n := range nodes {
+		if n.Id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"sync"
+
+	"common/registry"
+)
+
+type memWatcher struct {
+	opts registry.WatchOptions
+	res  chan *registry.Result
+	exit chan bool
+	stop func()
+	once sync.Once
+}
+
+func (w *memWatcher) Next() (*registry.Result, error) {
+	select {
+	case r := <-w.res:
+		return r, nil
+	case <-w.exit:
+		return nil, registry.ErrWatcherStopped
+	}
+}
+
+func (w *memWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.exit)
+		w.stop()
+	})
+}
@@ -0,0 +1,283 @@
+// Package multi is a registry aggregating the registries of several
+// data centers.
+package multi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"common/log/log"
+	"common/registry"
+)
+
+const (
+	// SourceKey is the node metadata holding the name of the source the
+	// node was found in, selector.FilterPrefer(SourceKey, local) selects
+	// local nodes first and falls back to remote ones.
+	SourceKey = "registry.source"
+)
+
+var (
+	ErrNoSources = errors.New("multi: no registries")
+)
+
+type source struct {
+	name     string
+	registry registry.Registry
+	priority int
+}
+
+type multiRegistry struct {
+	sync.RWMutex
+	options registry.Options
+	// sorted by priority, highest first
+	sources  []*source
+	local    *source
+	failover bool
+}
+
+func configure(m *multiRegistry, opts ...registry.Option) error {
+	for _, o := range opts {
+		o(&m.options)
+	}
+
+	m.sources = nil
+	m.local = nil
+	m.failover = false
+
+	if m.options.Context == nil {
+		return nil
+	}
+
+	sources, _ := m.options.Context.Value(sourcesKey{}).([]*source)
+	m.sources = append([]*source(nil), sources...)
+	sort.SliceStable(m.sources, func(i, j int) bool {
+		return m.sources[i].priority > m.sources[j].priority
+	})
+	m.failover, _ = m.options.Context.Value(failoverKey{}).(bool)
+
+	if len(m.sources) == 0 {
+		return nil
+	}
+
+	m.local = m.sources[0]
+	if name, ok := m.options.Context.Value(localKey{}).(string); ok {
+		m.local = nil
+		for _, s := range m.sources {
+			if s.name == name {
+				m.local = s
+				break
+			}
+		}
+		if m.local == nil {
+			return fmt.Errorf("multi: unknown local registry %s", name)
+		}
+	}
+
+	return nil
+}
+
+func (m *multiRegistry) children() ([]*source, *source, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.sources, m.local, m.failover
+}
+
+func (m *multiRegistry) Init(opts ...registry.Option) error {
+	m.Lock()
+	defer m.Unlock()
+	return configure(m, opts...)
+}
+
+func (m *multiRegistry) Options() registry.Options {
+	m.RLock()
+	defer m.RUnlock()
+	return m.options
+}
+
+func (m *multiRegistry) Register(s *registry.Service, opts ...registry.RegisterOption) error {
+	_, local, _ := m.children()
+	if local == nil {
+		return ErrNoSources
+	}
+	return local.registry.Register(s, opts...)
+}
+
+func (m *multiRegistry) Deregister(s *registry.Service) error {
+	_, local, _ := m.children()
+	if local == nil {
+		return ErrNoSources
+	}
+	return local.registry.Deregister(s)
+}
+
+func (m *multiRegistry) GetService(name string) ([]*registry.Service, error) {
+	sources, _, failover := m.children()
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+
+	results := make([][]*registry.Service, len(sources))
+	errs := make([]error, len(sources))
+
+	if failover {
+		// stop at the first source that has the service
+		for i, s := range sources {
+			results[i], errs[i] = s.registry.GetService(name)
+			if errs[i] == nil && len(results[i]) > 0 {
+				break
+			}
+		}
+	} else {
+		// data centers are far apart, ask them all at once
+		var wg sync.WaitGroup
+		for i, s := range sources {
+			wg.Add(1)
+			go func(i int, s *source) {
+				defer wg.Done()
+				results[i], errs[i] = s.registry.GetService(name)
+			}(i, s)
+		}
+		wg.Wait()
+	}
+
+	var services []*registry.Service
+	var err error
+
+	for i, s := range sources {
+		if errs[i] != nil {
+			if errs[i] != registry.ErrNotFound {
+				log.Warnf("multi: %s unable to get %s: %v", s.name, name, errs[i])
+				if err == nil {
+					err = errs[i]
+				}
+			}
+			continue
+		}
+		services = merge(services, results[i], s.name)
+	}
+
+	if len(services) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, registry.ErrNotFound
+	}
+
+	return services, nil
+}
+
+func (m *multiRegistry) ListServices() ([]*registry.Service, error) {
+	sources, _, _ := m.children()
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+
+	var services []*registry.Service
+	var err error
+	var ok bool
+
+	seen := make(map[string]bool)
+	for _, s := range sources {
+		list, lerr := s.registry.ListServices()
+		if lerr != nil {
+			log.Warnf("multi: %s unable to list services: %v", s.name, lerr)
+			if err == nil {
+				err = lerr
+			}
+			continue
+		}
+		ok = true
+
+		for _, service := range list {
+			key := service.Name + ":" + service.Version
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			services = append(services, service)
+		}
+	}
+
+	if !ok {
+		return nil, err
+	}
+
+	return services, nil
+}
+
+func (m *multiRegistry) Watch(opts ...registry.WatchOption) (registry.Watcher, error) {
+	sources, _, failover := m.children()
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+
+	return newWatcher(sources, failover, opts...)
+}
+
+func (m *multiRegistry) String() string {
+	return "multi"
+}
+
+// merge adds the nodes of services found in source to merged, nodes already
+// found in a source with a higher priority are skipped.
+func merge(merged, services []*registry.Service, source string) []*registry.Service {
+	for _, s := range services {
+		var target *registry.Service
+		for _, service := range merged {
+			if service.Version == s.Version {
+				target = service
+				break
+			}
+		}
+
+		if target == nil {
+			target = new(registry.Service)
+			*target = *s
+			target.Nodes = nil
+			merged = append(merged, target)
+		}
+
+		for _, n := range s.Nodes {
+			if hasNode(target, n.Id) {
+				continue
+			}
+			target.Nodes = append(target.Nodes, tag(n, source))
+		}
+	}
+
+	return merged
+}
+
+func hasNode(s *registry.Service, id string) bool {
+	for _, n := range s.Nodes {
+		if n.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// tag copies the node with its source set in the metadata
+func tag(n *registry.Node, source string) *registry.Node {
+	node := new(registry.Node)
+	*node = *n
+	node.Metadata = make(map[string]string, len(n.Metadata)+1)
+	for k, v := range n.Metadata {
+		node.Metadata[k] = v
+	}
+	node.Metadata[SourceKey] = source
+	return node
+}
+
+// NewRegistry returns a registry fanning out to the registries added
+// with Source.
+func NewRegistry(opts ...registry.Option) registry.Registry {
+	m := new(multiRegistry)
+	if err := configure(m, opts...); err != nil {
+		log.Error(err)
+	}
+	return m
+}
@@ -0,0 +1,335 @@
+package multi
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"common/registry"
+	"common/registry/memory"
+)
+
+type downRegistry struct {
+	registry.Registry
+}
+
+func (d *downRegistry) GetService(string) ([]*registry.Service, error) {
+	return nil, errors.New("registry down")
+}
+
+func testService(version string, nodes ...*registry.Node) *registry.Service {
+	return &registry.Service{
+		Name:    "test.service",
+		Version: version,
+		Nodes:   nodes,
+	}
+}
+
+func testNode(id, address string) *registry.Node {
+	return &registry.Node{Id: id, Address: address, Metadata: map[string]string{}}
+}
+
+func newTestRegistry(t *testing.T, opts ...registry.Option) (registry.Registry, registry.Registry, registry.Registry) {
+	dc1 := memory.NewRegistry()
+	dc2 := memory.NewRegistry()
+
+	for _, r := range []struct {
+		r registry.Registry
+		s *registry.Service
+	}{
+		{dc1, testService("1.0.0", testNode("a", "10.0.1.1:8080"))},
+		{dc2, testService("1.0.0", testNode("a", "10.0.2.1:8080"), testNode("b", "10.0.2.2:8080"))},
+		{dc2, testService("2.0.0", testNode("c", "10.0.2.3:8080"))},
+	} {
+		if err := r.r.Register(r.s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts = append([]registry.Option{
+		Source("dc2", dc2, 5),
+		Source("dc1", dc1, 10),
+	}, opts...)
+
+	return NewRegistry(opts...), dc1, dc2
+}
+
+func TestMultiGetService(t *testing.T) {
+	m, _, _ := newTestRegistry(t)
+
+	services, err := m.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(services))
+	}
+
+	nodes := make(map[string]*registry.Node)
+	for _, s := range services {
+		for _, n := range s.Nodes {
+			nodes[n.Id] = n
+		}
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(nodes))
+	}
+	// the higher priority source wins
+	if n := nodes["a"]; n.Address != "10.0.1.1:8080" || n.Metadata[SourceKey] != "dc1" {
+		t.Fatalf("unexpected node %+v", n)
+	}
+	if n := nodes["b"]; n.Metadata[SourceKey] != "dc2" {
+		t.Fatalf("unexpected node %+v", n)
+	}
+
+	list, err := m.ListServices()
+	if err != nil || len(list) != 1 {
+		t.Fatalf("unexpected services %v: %v", list, err)
+	}
+}
+
+func TestMultiFailover(t *testing.T) {
+	m, _, _ := newTestRegistry(t, Failover())
+
+	services, err := m.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Metadata[SourceKey] != "dc1" {
+		t.Fatalf("expected dc1 only, got %+v", services)
+	}
+
+	// the local data center is down
+	dc2 := memory.NewRegistry()
+	if err := dc2.Register(testService("1.0.0", testNode("b", "10.0.2.2:8080"))); err != nil {
+		t.Fatal(err)
+	}
+	m = NewRegistry(
+		Source("dc1", &downRegistry{memory.NewRegistry()}, 10),
+		Source("dc2", dc2, 5),
+		Failover(),
+	)
+
+	services, err = m.GetService("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].Nodes[0].Metadata[SourceKey] != "dc2" {
+		t.Fatalf("expected dc2, got %+v", services)
+	}
+}
+
+func TestMultiRegisterWatch(t *testing.T) {
+	m, dc1, dc2 := newTestRegistry(t, Local("dc2"))
+
+	w, err := m.Watch(registry.WatchService("other.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	s := &registry.Service{Name: "other.service", Nodes: []*registry.Node{testNode("d", "10.0.2.4:8080")}}
+	if err := m.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dc2.GetService("other.service"); err != nil {
+		t.Fatal("expected service registered with the local registry: ", err)
+	}
+	if _, err := dc1.GetService("other.service"); err != registry.ErrNotFound {
+		t.Fatal("expected service not registered with remote registries: ", err)
+	}
+
+	results := make(chan *registry.Result, 1)
+	go func() {
+		if res, err := w.Next(); err == nil {
+			results <- res
+		}
+	}()
+
+	select {
+	case res := <-results:
+		if res.Action != "create" || res.Service.Nodes[0].Metadata[SourceKey] != "dc2" {
+			t.Fatalf("unexpected result %s %+v", res.Action, res.Service.Nodes[0])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for watch result")
+	}
+}
+
+func TestMultiFailoverWatch(t *testing.T) {
+	dc1 := memory.NewRegistry()
+	dc2 := memory.NewRegistry()
+	m := NewRegistry(
+		Source("dc1", dc1, 10),
+		Source("dc2", dc2, 5),
+		Failover(),
+	)
+
+	w, err := m.Watch(registry.WatchService("test.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	results := make(chan *registry.Result, 10)
+	go func() {
+		for {
+			res, err := w.Next()
+			if err != nil {
+				return
+			}
+			results <- res
+		}
+	}()
+
+	expect := func(action, id, source string) {
+		t.Helper()
+		select {
+		case res := <-results:
+			n := res.Service.Nodes[0]
+			if res.Action != action || n.Id != id || n.Metadata[SourceKey] != source {
+				t.Fatalf("unexpected result %s %s from %s, want %s %s from %s", res.Action, n.Id, n.Metadata[SourceKey], action, id, source)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s %s", action, id)
+		}
+	}
+
+	low := testService("1.0.0", testNode("b", "10.0.2.2:8080"))
+	high := testService("1.0.0", testNode("a", "10.0.1.1:8080"))
+
+	// only the remote data center has the service
+	if err := dc2.Register(low); err != nil {
+		t.Fatal(err)
+	}
+	expect("create", "b", "dc2")
+
+	// the local data center takes over
+	if err := dc1.Register(high); err != nil {
+		t.Fatal(err)
+	}
+	expect("delete", "b", "dc2")
+	expect("create", "a", "dc1")
+
+	// changes of the remote data center are not seen while it is not used
+	if err := dc2.Register(testService("1.0.0", testNode("c", "10.0.2.3:8080"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// and it is back once the local data center loses the service
+	if err := dc1.Deregister(high); err != nil {
+		t.Fatal(err)
+	}
+	expect("delete", "a", "dc1")
+
+	nodes := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case res := <-results:
+			if res.Action != "create" || res.Service.Nodes[0].Metadata[SourceKey] != "dc2" {
+				t.Fatalf("unexpected result %s %+v", res.Action, res.Service.Nodes[0])
+			}
+			nodes[res.Service.Nodes[0].Id] = true
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the remote nodes")
+		}
+	}
+	if !nodes["b"] || !nodes["c"] {
+		t.Fatalf("unexpected remote nodes %v", nodes)
+	}
+
+	select {
+	case res := <-results:
+		t.Fatalf("unexpected result %s %+v", res.Action, res.Service.Nodes[0])
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+// resyncRegistry watches with the results sent by the test
+type resyncRegistry struct {
+	registry.Registry
+	results chan *registry.Result
+}
+
+func (r *resyncRegistry) Watch(...registry.WatchOption) (registry.Watcher, error) {
+	return &resyncWatcher{results: r.results, exit: make(chan bool)}, nil
+}
+
+type resyncWatcher struct {
+	results chan *registry.Result
+	exit    chan bool
+	once    sync.Once
+}
+
+func (w *resyncWatcher) Next() (*registry.Result, error) {
+	select {
+	case res := <-w.results:
+		return res, nil
+	case <-w.exit:
+		return nil, registry.ErrWatcherStopped
+	}
+}
+
+func (w *resyncWatcher) Stop() {
+	w.once.Do(func() { close(w.exit) })
+}
+
+func TestMultiFailoverResync(t *testing.T) {
+	dc1 := &resyncRegistry{Registry: memory.NewRegistry(), results: make(chan *registry.Result)}
+	dc2 := memory.NewRegistry()
+	m := NewRegistry(
+		Source("dc1", dc1, 10),
+		Source("dc2", dc2, 5),
+		Failover(),
+	)
+
+	w, err := m.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	results := make(chan *registry.Result, 10)
+	go func() {
+		for {
+			res, err := w.Next()
+			if err != nil {
+				return
+			}
+			results <- res
+		}
+	}()
+
+	expect := func(action, id string) {
+		t.Helper()
+		select {
+		case res := <-results:
+			if res.Action != action || (len(id) > 0 && res.Service.Nodes[0].Id != id) {
+				t.Fatalf("unexpected result %s %+v, want %s %s", res.Action, res.Service, action, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s %s", action, id)
+		}
+	}
+
+	high := testService("1.0.0", testNode("a", "10.0.1.1:8080"))
+	if err := dc1.Register(high); err != nil {
+		t.Fatal(err)
+	}
+	dc1.results <- &registry.Result{Action: "create", Service: high}
+	expect("create", "a")
+
+	// the node is gone while the watch of dc1 was compacted
+	if err := dc1.Deregister(high); err != nil {
+		t.Fatal(err)
+	}
+	dc1.results <- &registry.Result{Action: "resync", Service: &registry.Service{}}
+	expect("resync", "")
+
+	// so the remote data center is used
+	if err := dc2.Register(testService("1.0.0", testNode("b", "10.0.2.2:8080"))); err != nil {
+		t.Fatal(err)
+	}
+	expect("create", "b")
+}
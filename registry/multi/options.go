@@ -0,0 +1,52 @@
+package multi
+
+import (
+	"context"
+
+	"common/registry"
+)
+
+type sourcesKey struct{}
+
+type localKey struct{}
+
+type failoverKey struct{}
+
+// Source adds a child registry under name, when the same node is found in
+// several registries the one with the higher priority wins.
+func Source(name string, r registry.Registry, priority int) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		sources, _ := o.Context.Value(sourcesKey{}).([]*source)
+		sources = append(sources[:len(sources):len(sources)], &source{
+			name:     name,
+			registry: r,
+			priority: priority,
+		})
+		o.Context = context.WithValue(o.Context, sourcesKey{}, sources)
+	}
+}
+
+// Local names the source services are registered with, it defaults
+// to the source with the highest priority.
+func Local(name string) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, localKey{}, name)
+	}
+}
+
+// Failover returns and watches the nodes of the highest priority source
+// that has the service instead of merging the nodes of all sources.
+func Failover() registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, failoverKey{}, true)
+	}
+}
@@ -0,0 +1,288 @@
+package multi
+
+import (
+	"sync"
+	"time"
+
+	"common/log/log"
+	"common/registry"
+)
+
+var (
+	// RetryInterval is how long a source is left before watching it again
+	RetryInterval = time.Second
+)
+
+type multiWatcher struct {
+	opts []registry.WatchOption
+	res  chan *registry.Result
+	exit chan bool
+	once sync.Once
+
+	// with failover only the highest priority source that has
+	// a service is forwarded
+	failover bool
+	sources  []*source
+
+	sync.Mutex
+	// the nodes of each source by service name and node id
+	nodes map[*source]map[string]map[string]*registry.Service
+	// the services whose nodes were read from the sources
+	seeded map[string]bool
+}
+
+// newWatcher watches every source, it fails only when no source can
+// be watched. Sources that fail are watched again until stopped.
+func newWatcher(sources []*source, failover bool, opts ...registry.WatchOption) (registry.Watcher, error) {
+	w := &multiWatcher{
+		opts:     opts,
+		res:      make(chan *registry.Result),
+		exit:     make(chan bool),
+		failover: failover,
+		sources:  sources,
+		nodes:    make(map[*source]map[string]map[string]*registry.Service),
+		seeded:   make(map[string]bool),
+	}
+
+	watchers := make([]registry.Watcher, len(sources))
+
+	var err error
+	var ok bool
+	for i, s := range sources {
+		cw, werr := s.registry.Watch(opts...)
+		if werr != nil {
+			log.Warnf("multi: %s unable to watch: %v", s.name, werr)
+			err = werr
+			continue
+		}
+		watchers[i] = cw
+		ok = true
+	}
+
+	if !ok {
+		return nil, err
+	}
+
+	for i, s := range sources {
+		go w.run(s, watchers[i])
+	}
+
+	return w, nil
+}
+
+// run forwards the results of the source, watching it again on errors
+func (w *multiWatcher) run(s *source, cw registry.Watcher) {
+	for {
+		if cw == nil {
+			select {
+			case <-w.exit:
+				return
+			case <-time.After(RetryInterval):
+			}
+
+			var err error
+			if cw, err = s.registry.Watch(w.opts...); err != nil {
+				log.Warnf("multi: %s unable to watch: %v", s.name, err)
+				cw = nil
+				continue
+			}
+		}
+
+		if !w.forward(s, cw) {
+			return
+		}
+		cw = nil
+	}
+}
+
+// forward sends the results of cw until it fails, it returns
+// false when the watcher was stopped.
+func (w *multiWatcher) forward(s *source, cw registry.Watcher) bool {
+	done := make(chan bool)
+	defer close(done)
+	defer cw.Stop()
+
+	go func() {
+		select {
+		case <-w.exit:
+			cw.Stop()
+		case <-done:
+		}
+	}()
+
+	for {
+		res, err := cw.Next()
+		if err != nil {
+			select {
+			case <-w.exit:
+				return false
+			default:
+			}
+			log.Warnf("multi: %s watch failed: %v", s.name, err)
+			return true
+		}
+
+		if res == nil || res.Service == nil {
+			continue
+		}
+
+		service := new(registry.Service)
+		*service = *res.Service
+		service.Nodes = nil
+		for _, n := range res.Service.Nodes {
+			service.Nodes = append(service.Nodes, tag(n, s.name))
+		}
+
+		for _, r := range w.results(s, res.Action, service) {
+			select {
+			case w.res <- r:
+			case <-w.exit:
+				return false
+			}
+		}
+	}
+}
+
+// results returns the results sent for a result of source s. With
+// failover the nodes of the sources below the highest priority source
+// that has the service are left out, they are deleted and created as
+// that source changes.
+func (w *multiWatcher) results(s *source, action string, service *registry.Service) []*registry.Result {
+	if !w.failover {
+		return []*registry.Result{{Action: action, Service: service}}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	// the source lost changes, the callers reload the services
+	if action == "resync" {
+		w.resync(s, service.Name)
+		return []*registry.Result{{Action: action, Service: service}}
+	}
+
+	name := service.Name
+	if !w.seeded[name] {
+		w.seed(name)
+	}
+
+	before := w.active(name)
+	for _, n := range service.Nodes {
+		svc := new(registry.Service)
+		*svc = *service
+		svc.Nodes = []*registry.Node{n}
+		w.set(s, name, n.Id, svc, action == "delete")
+	}
+	after := w.active(name)
+
+	result := &registry.Result{Action: action, Service: service}
+
+	switch {
+	case before == after && after == s:
+		return []*registry.Result{result}
+	case before == after:
+		return nil
+	case before == s:
+		// the service is gone from s, the next source takes over
+		return append([]*registry.Result{result}, w.all("create", after, name)...)
+	default:
+		// s has the service and takes over from a lower priority source
+		return append(w.all("delete", before, name), result)
+	}
+}
+
+// seed reads the nodes of a service from the sources, the results of
+// the watchers only tell the changes
+func (w *multiWatcher) seed(name string) {
+	w.seeded[name] = true
+
+	for _, s := range w.sources {
+		services, err := s.registry.GetService(name)
+		if err != nil {
+			continue
+		}
+		for _, service := range services {
+			for _, n := range service.Nodes {
+				svc := new(registry.Service)
+				*svc = *service
+				svc.Nodes = []*registry.Node{tag(n, s.name)}
+				w.set(s, name, n.Id, svc, false)
+			}
+		}
+	}
+}
+
+// resync forgets the nodes of source s read for the service, of every
+// service when the name is empty, they are read again on the next result
+func (w *multiWatcher) resync(s *source, name string) {
+	for n := range w.nodes[s] {
+		if len(name) == 0 || n == name {
+			delete(w.nodes[s], n)
+		}
+	}
+	for n := range w.seeded {
+		if len(name) == 0 || n == name {
+			delete(w.seeded, n)
+		}
+	}
+}
+
+// set adds the node of source s, or removes it when deleted
+func (w *multiWatcher) set(s *source, name, id string, svc *registry.Service, deleted bool) {
+	services, ok := w.nodes[s]
+	if !ok {
+		services = make(map[string]map[string]*registry.Service)
+		w.nodes[s] = services
+	}
+
+	if deleted {
+		delete(services[name], id)
+		if len(services[name]) == 0 {
+			delete(services, name)
+		}
+		return
+	}
+
+	if services[name] == nil {
+		services[name] = make(map[string]*registry.Service)
+	}
+	services[name][id] = svc
+}
+
+// active returns the highest priority source that has the service
+func (w *multiWatcher) active(name string) *source {
+	for _, s := range w.sources {
+		if len(w.nodes[s][name]) > 0 {
+			return s
+		}
+	}
+	return nil
+}
+
+// all returns a result for each node of the service in source s
+func (w *multiWatcher) all(action string, s *source, name string) []*registry.Result {
+	if s == nil {
+		return nil
+	}
+
+	var results []*registry.Result
+	for _, svc := range w.nodes[s][name] {
+		results = append(results, &registry.Result{Action: action, Service: svc})
+	}
+	return results
+}
+
+func (w *multiWatcher) Next() (*registry.Result, error) {
+	select {
+	case r := <-w.res:
+		return r, nil
+	case <-w.exit:
+		return nil, registry.ErrWatcherStopped
+	}
+}
+
+func (w *multiWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.exit)
+	})
+}
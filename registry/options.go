@@ -36,6 +36,9 @@ type WatchOptions struct {
 	// Specify a service to watch
 	// If blank, the watch is for all services
 	Service string
+	// Debounce coalesces the changes of a burst into one batch
+	// sent once no change came for this long
+	Debounce time.Duration
 	// Other options for implementations of the interface
 	// can be stored in a context
 	Context context.Context
@@ -86,3 +89,10 @@ func WatchService(name string) WatchOption {
 		o.Service = name
 	}
 }
+
+// WatchDebounce batches the changes of a burst, see BatchWatcher
+func WatchDebounce(d time.Duration) WatchOption {
+	return func(o *WatchOptions) {
+		o.Debounce = d
+	}
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+const (
+	// SchemaKey is the endpoint metadata holding the fingerprint of
+	// its request and response, it changes with either of them.
+	SchemaKey = "schema"
+)
+
+var (
+	// Not found error when GetEndpoint is called
+	ErrEndpointNotFound = errors.New("endpoint not found")
+)
+
+// Fingerprint returns the schema version of an endpoint
+func Fingerprint(ep *Endpoint) string {
+	b, _ := json.Marshal([]*Value{ep.Request, ep.Response})
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// ListEndpoints returns the endpoints of every version of a service
+// keyed by version
+func ListEndpoints(r Registry, service string) (map[string][]*Endpoint, error) {
+	services, err := r.GetService(service)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[string][]*Endpoint, len(services))
+	for _, s := range services {
+		endpoints[s.Version] = append(endpoints[s.Version], s.Endpoints...)
+	}
+
+	return endpoints, nil
+}
+
+// GetEndpoint returns the named endpoint of a service, an empty
+// version matches the first version that has it
+func GetEndpoint(r Registry, service, version, endpoint string) (*Endpoint, error) {
+	services, err := r.GetService(service)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range services {
+		if len(version) > 0 && s.Version != version {
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			if ep.Name == endpoint {
+				return ep, nil
+			}
+		}
+	}
+
+	return nil, ErrEndpointNotFound
+}
@@ -17,7 +17,16 @@ type Watcher interface {
 
 // Result is returned by a call to Next on
 // the watcher. Actions can be create, update, delete
+// or resync when the watcher lost changes and the services
+// of the watch have to be reloaded
 type Result struct {
 	Action  string
 	Service *Service
 }
+
+// BatchWatcher is a watcher returning the results of a burst of changes
+// at once, the etcd watcher coalesces bursts when WatchDebounce is set.
+type BatchWatcher interface {
+	Watcher
+	NextBatch() ([]*Result, error)
+}
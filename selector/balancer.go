@@ -0,0 +1,343 @@
+package selector
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"common/registry"
+)
+
+var (
+	// DefaultBalancer is the state of the P2C and LeastOutstanding strategies
+	DefaultBalancer = NewBalancer()
+
+	// DefaultDecay is how long latency samples take to fade
+	DefaultDecay = time.Second * 10
+)
+
+type BalancerOptions struct {
+	// Decay is the time constant of the latency average, older
+	// samples and idle nodes fade over it
+	Decay time.Duration
+}
+
+type BalancerOption func(*BalancerOptions)
+
+type balancerKey struct{}
+
+// BalancerDecay sets the time constant of the latency average
+func BalancerDecay(d time.Duration) BalancerOption {
+	return func(o *BalancerOptions) {
+		o.Decay = d
+	}
+}
+
+// SetBalancer sets the balancer fed by Mark, DefaultBalancer by default
+func SetBalancer(b *Balancer) Option {
+	return func(o *Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, balancerKey{}, b)
+	}
+}
+
+// P2C picks the cheaper of two random nodes on DefaultBalancer
+func P2C(services []*registry.Service) Next {
+	return DefaultBalancer.P2C(services)
+}
+
+// LeastOutstanding picks the node with the fewest calls in flight on DefaultBalancer
+func LeastOutstanding(services []*registry.Service) Next {
+	return DefaultBalancer.LeastOutstanding(services)
+}
+
+// NodeStats is the state of a node as seen by a balancer
+type NodeStats struct {
+	Address  string
+	InFlight int
+	// Latency is the decaying average of the call latency,
+	// errored calls count twice
+	Latency  time.Duration
+	Requests uint64
+	Errors   uint64
+}
+
+type nodeState struct {
+	NodeStats
+	// latency in nanoseconds and the time it was updated
+	ewma    float64
+	updated time.Time
+}
+
+// Balancer tracks the calls in flight and their latency per node for the
+// strategies it returns, the selector feeds it the call results by Mark.
+type Balancer struct {
+	opts BalancerOptions
+
+	sync.Mutex
+	nodes map[string]*nodeState
+	// start times of the calls in flight by the node returned,
+	// calls to nodes not returned by the balancer are not counted
+	picked map[*registry.Node][]time.Time
+	pruned time.Time
+}
+
+func NewBalancer(opts ...BalancerOption) *Balancer {
+	options := BalancerOptions{
+		Decay: DefaultDecay,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.Decay <= 0 {
+		options.Decay = DefaultDecay
+	}
+
+	return &Balancer{
+		opts:   options,
+		nodes:  make(map[string]*nodeState),
+		picked: make(map[*registry.Node][]time.Time),
+		pruned: time.Now(),
+	}
+}
+
+func (b *Balancer) state(node *registry.Node) *nodeState {
+	s, ok := b.nodes[node.Id]
+	if !ok {
+		s = &nodeState{}
+		b.nodes[node.Id] = s
+	}
+	s.Address = node.Address
+	return s
+}
+
+// decay returns the weight left to a sample after d
+func (b *Balancer) decay(d time.Duration) float64 {
+	return math.Exp(-float64(d) / float64(b.opts.Decay))
+}
+
+// cost is the latency expected by the next call, idle nodes
+// fade so they are tried again
+func (b *Balancer) cost(node *registry.Node, now time.Time) float64 {
+	s, ok := b.nodes[node.Id]
+	if !ok {
+		return 0
+	}
+	latency := s.ewma * b.decay(now.Sub(s.updated))
+	if latency < 1 {
+		latency = 1
+	}
+	return latency * float64(s.InFlight+1)
+}
+
+func (b *Balancer) pick(node *registry.Node, now time.Time) *registry.Node {
+	s := b.state(node)
+	s.InFlight++
+	s.Requests++
+	b.picked[node] = append(b.picked[node], now)
+	return node
+}
+
+func flatten(services []*registry.Service) []*registry.Node {
+	var nodes []*registry.Node
+	for _, service := range services {
+		nodes = append(nodes, service.Nodes...)
+	}
+	return nodes
+}
+
+// untried returns the nodes not returned yet, all of them once
+// every node was returned
+func untried(nodes []*registry.Node, tried map[string]bool) []*registry.Node {
+	var left []*registry.Node
+	for _, n := range nodes {
+		if !tried[n.Id] {
+			left = append(left, n)
+		}
+	}
+	if len(left) == 0 {
+		for id := range tried {
+			delete(tried, id)
+		}
+		return nodes
+	}
+	return left
+}
+
+// P2C returns a power of two choices strategy, the cheaper of two random
+// nodes is picked where the cost is the latency times the calls in flight.
+func (b *Balancer) P2C(services []*registry.Service) Next {
+	nodes := flatten(services)
+	tried := make(map[string]bool)
+	var mtx sync.Mutex
+
+	return func() (*registry.Node, error) {
+		if len(nodes) == 0 {
+			return nil, ErrNoneAvailable
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		left := untried(nodes, tried)
+
+		b.Lock()
+		defer b.Unlock()
+
+		now := time.Now()
+		node := left[0]
+		if len(left) > 1 {
+			i := rand.Intn(len(left))
+			j := rand.Intn(len(left) - 1)
+			if j >= i {
+				j++
+			}
+			node = left[i]
+			if b.cost(left[j], now) < b.cost(node, now) {
+				node = left[j]
+			}
+		}
+
+		tried[node.Id] = true
+		return b.pick(node, now), nil
+	}
+}
+
+// LeastOutstanding returns a strategy picking the node with the
+// fewest calls in flight, ties are broken at random.
+func (b *Balancer) LeastOutstanding(services []*registry.Service) Next {
+	nodes := flatten(services)
+	tried := make(map[string]bool)
+	var mtx sync.Mutex
+
+	return func() (*registry.Node, error) {
+		if len(nodes) == 0 {
+			return nil, ErrNoneAvailable
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		left := untried(nodes, tried)
+
+		b.Lock()
+		defer b.Unlock()
+
+		var best []*registry.Node
+		min := -1
+		for _, n := range left {
+			var inflight int
+			if s, ok := b.nodes[n.Id]; ok {
+				inflight = s.InFlight
+			}
+			switch {
+			case min < 0 || inflight < min:
+				min = inflight
+				best = []*registry.Node{n}
+			case inflight == min:
+				best = append(best, n)
+			}
+		}
+
+		node := best[rand.Intn(len(best))]
+		tried[node.Id] = true
+		return b.pick(node, time.Now()), nil
+	}
+}
+
+// Mark ends a call to a node returned by the balancer, other nodes are ignored
+func (b *Balancer) Mark(node *registry.Node, err error) {
+	if node == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	starts := b.picked[node]
+	if len(starts) == 0 {
+		return
+	}
+	if len(starts) == 1 {
+		delete(b.picked, node)
+	} else {
+		b.picked[node] = starts[1:]
+	}
+
+	now := time.Now()
+	s := b.state(node)
+	s.InFlight--
+
+	sample := float64(now.Sub(starts[0]))
+	if err != nil {
+		s.Errors++
+		sample = 2 * math.Max(sample, s.ewma)
+	}
+
+	if s.updated.IsZero() {
+		s.ewma = sample
+	} else {
+		w := b.decay(now.Sub(s.updated))
+		s.ewma = s.ewma*w + sample*(1-w)
+	}
+	s.updated = now
+	s.Latency = time.Duration(s.ewma)
+
+	b.prune(now)
+}
+
+// Release drops a node returned by the balancer that was not called,
+// the call is not counted
+func (b *Balancer) Release(node *registry.Node) {
+	if node == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	starts := b.picked[node]
+	if len(starts) == 0 {
+		return
+	}
+	if len(starts) == 1 {
+		delete(b.picked, node)
+	} else {
+		b.picked[node] = starts[:len(starts)-1]
+	}
+
+	s := b.state(node)
+	s.InFlight--
+	s.Requests--
+}
+
+// prune forgets the nodes idle for long
+func (b *Balancer) prune(now time.Time) {
+	idle := b.opts.Decay * 10
+	if now.Sub(b.pruned) < idle {
+		return
+	}
+	b.pruned = now
+
+	for id, s := range b.nodes {
+		if s.InFlight == 0 && now.Sub(s.updated) > idle {
+			delete(b.nodes, id)
+		}
+	}
+}
+
+// Stats returns the stats of the nodes by id
+func (b *Balancer) Stats() map[string]NodeStats {
+	b.Lock()
+	defer b.Unlock()
+
+	stats := make(map[string]NodeStats, len(b.nodes))
+	for id, s := range b.nodes {
+		stats[id] = s.NodeStats
+	}
+	return stats
+}
@@ -0,0 +1,69 @@
+package selector
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"common/registry"
+)
+
+func TestBalancerP2C(t *testing.T) {
+	b := NewBalancer()
+	services := testServices(2, nil)
+	slow := services[0].Nodes[0]
+
+	// node-0 answers in 20ms, node-1 at once
+	for i := 0; i < 10; i++ {
+		next := b.P2C(services)
+		n, err := next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == slow {
+			time.Sleep(time.Millisecond * 20)
+		}
+		b.Mark(n, nil)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		n, _ := b.P2C(services)()
+		counts[n.Id]++
+		b.Mark(n, nil)
+	}
+	if counts["node-0"] > 10 {
+		t.Fatalf("expected the slow node to shed traffic, got %v", counts)
+	}
+
+	stats := b.Stats()
+	if stats["node-0"].Latency < time.Millisecond*10 || stats["node-1"].InFlight != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	b := NewBalancer()
+	services := testServices(3, nil)
+
+	// three calls in flight land on three nodes
+	seen := make(map[string]bool)
+	var picked []*registry.Node
+	for i := 0; i < 3; i++ {
+		n, _ := b.LeastOutstanding(services)()
+		seen[n.Id] = true
+		picked = append(picked, n)
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected 3 nodes, got %v", seen)
+	}
+
+	// errors are counted, unknown nodes ignored
+	b.Mark(picked[0], errors.New("failed"))
+	b.Mark(&registry.Node{Id: picked[1].Id}, nil)
+
+	stats := b.Stats()
+	if s := stats[picked[0].Id]; s.Errors != 1 || s.InFlight != 0 || stats[picked[1].Id].InFlight != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,402 @@
+package selector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	merrors "common/errors"
+	"common/log/log"
+	"common/registry"
+)
+
+var (
+	DefaultConsecutiveErrors = 5
+	DefaultErrorWindow       = time.Second * 10
+	DefaultMinRequests       = 10
+	DefaultEjectTime         = time.Second * 30
+	DefaultMaxEjectTime      = time.Minute * 5
+	DefaultMaxEjectPercent   = 50
+)
+
+type BreakerOptions struct {
+	// ConsecutiveErrors ejects a node after that many errors
+	// in a row, zero disables it
+	ConsecutiveErrors int
+	// ErrorRate ejects a node whose errors in a window reach that
+	// share of at least MinRequests calls, zero disables it
+	ErrorRate   float64
+	Window      time.Duration
+	MinRequests int
+	// EjectTime is the first ejection, it doubles with every
+	// ejection up to MaxEjectTime
+	EjectTime    time.Duration
+	MaxEjectTime time.Duration
+	// MaxEjectPercent is the most nodes of a service ejected at once
+	MaxEjectPercent int
+	// Failure tells the errors counted against a node, by default
+	// the timeouts and the server errors
+	Failure func(err error) bool
+}
+
+type BreakerOption func(*BreakerOptions)
+
+type breakerKey struct{}
+
+func BreakerConsecutiveErrors(n int) BreakerOption {
+	return func(o *BreakerOptions) {
+		o.ConsecutiveErrors = n
+	}
+}
+
+// BreakerErrorRate ejects nodes failing rate of at least min calls in window
+func BreakerErrorRate(rate float64, window time.Duration, min int) BreakerOption {
+	return func(o *BreakerOptions) {
+		o.ErrorRate = rate
+		o.Window = window
+		o.MinRequests = min
+	}
+}
+
+// BreakerEjectTime sets the first and the longest ejection
+func BreakerEjectTime(d, max time.Duration) BreakerOption {
+	return func(o *BreakerOptions) {
+		o.EjectTime = d
+		o.MaxEjectTime = max
+	}
+}
+
+func BreakerMaxEjectPercent(p int) BreakerOption {
+	return func(o *BreakerOptions) {
+		o.MaxEjectPercent = p
+	}
+}
+
+// BreakerFailure sets the errors counted against a node, e.g. to
+// leave out the errors returned by the handlers
+func BreakerFailure(fn func(err error) bool) BreakerOption {
+	return func(o *BreakerOptions) {
+		o.Failure = fn
+	}
+}
+
+// SetBreaker ejects the failing nodes from the selection,
+// the selector has no breaker by default
+func SetBreaker(b *Breaker) Option {
+	return func(o *Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, breakerKey{}, b)
+	}
+}
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	halfOpen
+)
+
+type nodeBreaker struct {
+	state       breakerState
+	consecutive int
+	// calls and errors of the current window
+	requests int
+	errors   int
+	window   time.Time
+	// ejections in a row, the ejection time backs off with them
+	ejections int
+	ejectedAt time.Time
+	until     time.Time
+	closedAt  time.Time
+	// the probe in flight while half open
+	probing time.Time
+}
+
+// Breaker holds a circuit breaker per node. A node is ejected when it fails
+// too often, once the ejection is over a single probe call is let through
+// which closes the breaker or ejects the node again for longer.
+type Breaker struct {
+	opts BreakerOptions
+
+	sync.Mutex
+	nodes map[string]*nodeBreaker
+}
+
+func NewBreaker(opts ...BreakerOption) *Breaker {
+	options := BreakerOptions{
+		ConsecutiveErrors: DefaultConsecutiveErrors,
+		Window:            DefaultErrorWindow,
+		MinRequests:       DefaultMinRequests,
+		EjectTime:         DefaultEjectTime,
+		MaxEjectTime:      DefaultMaxEjectTime,
+		MaxEjectPercent:   DefaultMaxEjectPercent,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.Failure == nil {
+		options.Failure = failure
+	}
+	if options.EjectTime <= 0 {
+		options.EjectTime = DefaultEjectTime
+	}
+	if options.MaxEjectTime < options.EjectTime {
+		options.MaxEjectTime = options.EjectTime
+	}
+
+	return &Breaker{
+		opts:  options,
+		nodes: make(map[string]*nodeBreaker),
+	}
+}
+
+// failure counts the transport errors, timeouts and 5xx against the node,
+// the other errors are the answers of a healthy server
+func failure(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := merrors.FromError(err).Code
+	return code == 408 || code >= 500
+}
+
+// allow reports whether a call may go to the node
+func (b *Breaker) allow(id string, now time.Time) bool {
+	nb, ok := b.nodes[id]
+	if !ok {
+		return true
+	}
+
+	switch nb.state {
+	case open:
+		if now.Before(nb.until) {
+			return false
+		}
+		nb.state = halfOpen
+		nb.probing = time.Time{}
+		fallthrough
+	case halfOpen:
+		// a probe that never came back is given up after an ejection time
+		return nb.probing.IsZero() || now.Sub(nb.probing) > b.opts.EjectTime
+	}
+
+	return true
+}
+
+func (b *Breaker) picked(id string, now time.Time) {
+	if nb, ok := b.nodes[id]; ok && nb.state == halfOpen {
+		nb.probing = now
+	}
+}
+
+func (b *Breaker) eject(id string, nb *nodeBreaker, now time.Time) {
+	// a node that stayed healthy for long starts over
+	if now.Sub(nb.closedAt) > b.opts.MaxEjectTime {
+		nb.ejections = 0
+	}
+	nb.ejections++
+
+	d := b.opts.EjectTime
+	for i := 1; i < nb.ejections && d < b.opts.MaxEjectTime; i++ {
+		d *= 2
+	}
+	if d > b.opts.MaxEjectTime {
+		d = b.opts.MaxEjectTime
+	}
+
+	nb.state = open
+	nb.ejectedAt = now
+	nb.until = now.Add(d)
+	nb.consecutive = 0
+	nb.requests = 0
+	nb.errors = 0
+
+	log.Warnf("selector: node %s ejected for %v", id, d)
+}
+
+// Mark counts the result of a call against the breaker of the node
+func (b *Breaker) Mark(node *registry.Node, err error) {
+	if node == nil {
+		return
+	}
+
+	failed := b.opts.Failure(err)
+	now := time.Now()
+
+	b.Lock()
+	defer b.Unlock()
+
+	nb, ok := b.nodes[node.Id]
+	if !ok {
+		if !failed {
+			return
+		}
+		nb = &nodeBreaker{closedAt: now}
+		b.nodes[node.Id] = nb
+	}
+
+	switch nb.state {
+	case open:
+		// started before the ejection
+		return
+	case halfOpen:
+		nb.probing = time.Time{}
+		if failed {
+			b.eject(node.Id, nb, now)
+			return
+		}
+		nb.state = closed
+		nb.closedAt = now
+		return
+	}
+
+	if now.Sub(nb.window) > b.opts.Window {
+		nb.window = now
+		nb.requests = 0
+		nb.errors = 0
+	}
+	nb.requests++
+
+	if failed {
+		nb.errors++
+		nb.consecutive++
+	} else {
+		nb.consecutive = 0
+	}
+
+	switch {
+	case b.opts.ConsecutiveErrors > 0 && nb.consecutive >= b.opts.ConsecutiveErrors:
+		b.eject(node.Id, nb, now)
+	case b.opts.ErrorRate > 0 && nb.requests >= b.opts.MinRequests &&
+		float64(nb.errors) >= b.opts.ErrorRate*float64(nb.requests):
+		b.eject(node.Id, nb, now)
+	}
+}
+
+// Filter is a Select Filter removing the ejected nodes, no more than
+// MaxEjectPercent of the nodes of a service are removed.
+func (b *Breaker) Filter(old []*registry.Service) []*registry.Service {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+
+	var total int
+	var ejected []*registry.Node
+	for _, service := range old {
+		total += len(service.Nodes)
+		for _, node := range service.Nodes {
+			if !b.allow(node.Id, now) {
+				ejected = append(ejected, node)
+			}
+		}
+	}
+	if len(ejected) == 0 {
+		return old
+	}
+
+	// let the nodes closest to the end of their ejection back in over the limit
+	max := total * b.opts.MaxEjectPercent / 100
+	if max == 0 && total > 1 && b.opts.MaxEjectPercent > 0 {
+		max = 1
+	}
+	skip := make(map[*registry.Node]bool, len(ejected))
+	for _, node := range ejected {
+		skip[node] = true
+	}
+	for len(ejected) > max {
+		first := 0
+		for i, node := range ejected {
+			if b.nodes[node.Id].until.Before(b.nodes[ejected[first].Id].until) {
+				first = i
+			}
+		}
+		delete(skip, ejected[first])
+		ejected = append(ejected[:first], ejected[first+1:]...)
+	}
+
+	var services []*registry.Service
+	for _, service := range old {
+		var nodes []*registry.Node
+		for _, node := range service.Nodes {
+			if !skip[node] {
+				nodes = append(nodes, node)
+			}
+		}
+		if len(nodes) > 0 {
+			s := new(registry.Service)
+			*s = *service
+			s.Nodes = nodes
+			services = append(services, s)
+		}
+	}
+
+	return services
+}
+
+// Next skips the nodes ejected since the selection, e.g. between the
+// retries of a call, when every node is ejected the last one is returned.
+// The nodes ejected before were let back in by Filter and are kept.
+// The nodes skipped are given to release so the strategy no longer counts
+// them in flight.
+func (b *Breaker) Next(next Next, services []*registry.Service, release func(*registry.Node)) Next {
+	var count int
+	for _, service := range services {
+		count += len(service.Nodes)
+	}
+
+	selected := time.Now()
+
+	return func() (*registry.Node, error) {
+		var node *registry.Node
+		for i := 0; i < count; i++ {
+			n, err := next()
+			// only the node returned is called
+			if node != nil {
+				release(node)
+			}
+			if err != nil {
+				return nil, err
+			}
+			node = n
+
+			b.Lock()
+			now := time.Now()
+			if b.allow(n.Id, now) || !b.ejectedSince(n.Id, selected) {
+				b.picked(n.Id, now)
+				b.Unlock()
+				return n, nil
+			}
+			b.Unlock()
+		}
+
+		if node == nil {
+			return next()
+		}
+		return node, nil
+	}
+}
+
+// ejectedSince reports whether the node was ejected after t
+func (b *Breaker) ejectedSince(id string, t time.Time) bool {
+	nb, ok := b.nodes[id]
+	return ok && nb.state == open && nb.ejectedAt.After(t)
+}
+
+// Ejected returns the ids of the nodes ejected now
+func (b *Breaker) Ejected() []string {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	var ids []string
+	for id, nb := range b.nodes {
+		if nb.state == open && now.Before(nb.until) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,236 @@
+package selector
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	merrors "common/errors"
+	"common/registry"
+	"common/registry/memory"
+)
+
+var errFailed = errors.New("failed")
+
+func nodeIds(services []*registry.Service) map[string]bool {
+	ids := make(map[string]bool)
+	for _, s := range services {
+		for _, n := range s.Nodes {
+			ids[n.Id] = true
+		}
+	}
+	return ids
+}
+
+func TestBreakerEject(t *testing.T) {
+	b := NewBreaker(
+		BreakerConsecutiveErrors(2),
+		BreakerEjectTime(time.Millisecond*50, time.Second),
+		BreakerMaxEjectPercent(50),
+	)
+	services := testServices(4, nil)
+	nodes := services[0].Nodes
+
+	b.Mark(nodes[0], errFailed)
+	b.Mark(nodes[0], nil)
+	b.Mark(nodes[0], errFailed)
+	if ids := nodeIds(b.Filter(services)); !ids["node-0"] {
+		t.Fatal("expected node-0 kept after a success between errors")
+	}
+
+	b.Mark(nodes[0], errFailed)
+	if ids := nodeIds(b.Filter(services)); ids["node-0"] || len(ids) != 3 {
+		t.Fatalf("expected node-0 ejected, got %v", ids)
+	}
+
+	// no more than half of the nodes are ejected, the first
+	// ejected is let back in
+	for _, n := range nodes[1:3] {
+		b.Mark(n, errFailed)
+		b.Mark(n, errFailed)
+	}
+	if ids := nodeIds(b.Filter(services)); len(ids) != 2 || !ids["node-0"] {
+		t.Fatalf("expected node-0 and node-3 left, got %v", ids)
+	}
+
+	// once the ejection is over a probe is let through
+	time.Sleep(time.Millisecond * 60)
+	next := b.Next(RoundRobin(services[:1]), services, func(*registry.Node) {})
+	seen := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		n, _ := next()
+		seen[n.Id] = true
+	}
+	if !seen["node-0"] {
+		t.Fatal("expected a probe to node-0")
+	}
+
+	// a failed probe ejects the node for twice as long
+	b.Mark(nodes[0], errFailed)
+	time.Sleep(time.Millisecond * 60)
+	if len(b.Ejected()) != 1 {
+		t.Fatalf("expected node-0 still ejected, got %v", b.Ejected())
+	}
+	time.Sleep(time.Millisecond * 50)
+	next = b.Next(RoundRobin(services), services, func(*registry.Node) {})
+	for i := 0; i < 4; i++ {
+		next()
+	}
+	b.Mark(nodes[0], nil)
+	if ids := nodeIds(b.Filter(services)); !ids["node-0"] {
+		t.Fatal("expected node-0 back after a good probe")
+	}
+}
+
+func TestBreakerReadmit(t *testing.T) {
+	b := NewBreaker(BreakerConsecutiveErrors(1), BreakerMaxEjectPercent(50))
+	services := testServices(3, nil)
+	nodes := services[0].Nodes
+
+	b.Mark(nodes[0], errFailed)
+	time.Sleep(time.Millisecond)
+	b.Mark(nodes[1], errFailed)
+
+	// node-0 is the closest to the end of its ejection
+	filtered := b.Filter(services)
+	if ids := nodeIds(filtered); len(ids) != 2 || !ids["node-0"] {
+		t.Fatalf("expected node-0 and node-2 left, got %v", ids)
+	}
+
+	// and is returned by the selection
+	next := b.Next(RoundRobin(filtered), filtered, func(*registry.Node) {})
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		n, _ := next()
+		seen[n.Id] = true
+	}
+	if !seen["node-0"] {
+		t.Fatalf("expected node-0 returned, got %v", seen)
+	}
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	b := NewBreaker(
+		BreakerConsecutiveErrors(0),
+		BreakerErrorRate(0.5, time.Second, 4),
+	)
+	node := testServices(1, nil)[0].Nodes[0]
+
+	b.Mark(node, errFailed)
+	b.Mark(node, nil)
+	b.Mark(node, errFailed)
+	if len(b.Ejected()) != 0 {
+		t.Fatal("expected no ejection under the minimum calls")
+	}
+	b.Mark(node, nil)
+	if len(b.Ejected()) != 1 {
+		t.Fatal("expected the node ejected at half of the calls failing")
+	}
+}
+
+func TestSelectorBreaker(t *testing.T) {
+	r := memory.NewRegistry()
+	if err := r.Register(testServices(2, nil)[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSelector(Registry(r), SetStrategy(RoundRobin), SetBreaker(NewBreaker()))
+	defer s.Close()
+
+	next, err := s.Select("test.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the retries of a call skip the node once it is ejected
+	for i := 0; i < DefaultConsecutiveErrors; i++ {
+		n, _ := next()
+		for n.Id != "node-0" {
+			n, _ = next()
+		}
+		s.Mark("test.service", n, errFailed)
+	}
+	for i := 0; i < 4; i++ {
+		if n, _ := next(); n.Id == "node-0" {
+			t.Fatal("expected node-0 skipped")
+		}
+	}
+}
+
+func TestSelectorBreakerRelease(t *testing.T) {
+	r := memory.NewRegistry()
+	if err := r.Register(testServices(2, nil)[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBalancer()
+	h := NewHash()
+	s := NewSelector(
+		Registry(r),
+		SetStrategy(b.LeastOutstanding),
+		SetBalancer(b),
+		SetHash(h),
+		SetBreaker(NewBreaker(BreakerConsecutiveErrors(1))),
+	)
+	defer s.Close()
+
+	for _, opts := range [][]SelectOption{nil, {WithHashKey("key")}} {
+		next, err := s.Select("test.service", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// node-0 is ejected after the selection, the retries skip it
+		n, _ := next()
+		for n.Id != "node-0" {
+			s.Mark("test.service", n, nil)
+			n, _ = next()
+		}
+		s.Mark("test.service", n, errFailed)
+
+		for i := 0; i < 10; i++ {
+			n, err := next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.Id == "node-0" {
+				t.Fatal("expected node-0 skipped")
+			}
+			s.Mark("test.service", n, nil)
+		}
+
+		// let node-0 back in for the next selection
+		br := s.(*registrySelector).breaker()
+		br.Lock()
+		br.nodes = make(map[string]*nodeBreaker)
+		br.Unlock()
+	}
+
+	for id, stats := range b.Stats() {
+		if stats.InFlight != 0 {
+			t.Fatalf("expected no calls in flight to %s, got %d", id, stats.InFlight)
+		}
+	}
+	if len(h.picked) != 0 || len(h.load) != 0 {
+		t.Fatalf("expected no calls in flight on the ring, got %v", h.load)
+	}
+}
+
+func TestBreakerFailure(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		failed bool
+	}{
+		{nil, false},
+		{errFailed, true},
+		{merrors.InternalServerError("go.micro.client", "connection error"), true},
+		{merrors.Timeout("go.micro.client", "timed out"), true},
+		{merrors.ServiceUnavailable("test", "unavailable"), true},
+		{merrors.BadRequest("test", "bad request"), false},
+		{merrors.NotFound("test", "not found"), false},
+	} {
+		if failure(c.err) != c.failed {
+			t.Fatalf("expected %v counted %v", c.err, c.failed)
+		}
+	}
+}
@@ -65,15 +65,75 @@ func (c *registrySelector) Select(service string, opts ...SelectOption) (Next, e
 		services = filter(services)
 	}
 
+	// skip the ejected nodes
+	br := c.breaker()
+	if br != nil {
+		services = br.Filter(services)
+	}
+
 	// if there's nothing left, return
 	if len(services) == 0 {
 		return nil, ErrNoneAvailable
 	}
 
-	return sopts.Strategy(services), nil
+	strategy := sopts.Strategy
+
+	// calls with a hash key stick to the node owning the key
+	if sopts.Context != nil {
+		if key, ok := sopts.Context.Value(hashKey{}).(string); ok {
+			strategy = c.hash().Strategy(key)
+		}
+	}
+
+	next := strategy(services)
+	if br != nil {
+		next = br.Next(next, services, func(node *registry.Node) {
+			c.Release(service, node)
+		})
+	}
+
+	return next, nil
+}
+
+func (c *registrySelector) breaker() *Breaker {
+	if c.so.Context != nil {
+		if b, ok := c.so.Context.Value(breakerKey{}).(*Breaker); ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func (c *registrySelector) hash() *Hash {
+	if c.so.Context != nil {
+		if h, ok := c.so.Context.Value(hashRingKey{}).(*Hash); ok {
+			return h
+		}
+	}
+	return DefaultHash
+}
+
+func (c *registrySelector) balancer() *Balancer {
+	if c.so.Context != nil {
+		if b, ok := c.so.Context.Value(balancerKey{}).(*Balancer); ok {
+			return b
+		}
+	}
+	return DefaultBalancer
 }
 
 func (c *registrySelector) Mark(service string, node *registry.Node, err error) {
+	c.hash().Done(node)
+	c.balancer().Mark(node, err)
+	if br := c.breaker(); br != nil {
+		br.Mark(node, err)
+	}
+}
+
+// Release drops a node returned by the strategy but never called
+func (c *registrySelector) Release(service string, node *registry.Node) {
+	c.hash().Done(node)
+	c.balancer().Release(node)
 }
 
 func (c *registrySelector) Reset(service string) {
@@ -75,4 +75,17 @@ func FilterVersion(version string) Filter {
 
 		return services
 	}
-}
\ No newline at end of file
+}
+
+// FilterPrefer is a label based Select Filter which will return the
+// nodes with the label specified if there are any and all nodes otherwise,
+// e.g. FilterPrefer(multi.SourceKey, "local") for local first remote fallback.
+func FilterPrefer(key, val string) Filter {
+	label := FilterLabel(key, val)
+	return func(old []*registry.Service) []*registry.Service {
+		if services := label(old); len(services) > 0 {
+			return services
+		}
+		return old
+	}
+}
@@ -0,0 +1,295 @@
+package selector
+
+import (
+	"context"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"common/registry"
+)
+
+var (
+	// DefaultHash is the ring used by ConsistentHash and WithHashKey
+	DefaultHash = NewHash()
+
+	// DefaultReplicas is the number of virtual nodes of a node of weight 1
+	DefaultReplicas = 100
+
+	// WeightKey is the node metadata holding its integer weight
+	WeightKey = "weight"
+
+	// maxRings is the rings kept per service, the filters and the
+	// breaker select several subsets of the nodes
+	maxRings = 4
+)
+
+type HashOptions struct {
+	// Replicas is the number of virtual nodes of a node of weight 1
+	Replicas int
+	// LoadFactor bounds the in flight calls of a node to the factor
+	// times its share of the total, zero disables the bound
+	LoadFactor float64
+}
+
+type HashOption func(*HashOptions)
+
+type hashKey struct{}
+
+type hashRingKey struct{}
+
+// HashReplicas sets the number of virtual nodes per weight
+func HashReplicas(n int) HashOption {
+	return func(o *HashOptions) {
+		o.Replicas = n
+	}
+}
+
+// HashBoundedLoad moves keys off nodes with more than factor times their
+// share of the calls in flight, 1.25 is a common choice.
+func HashBoundedLoad(factor float64) HashOption {
+	return func(o *HashOptions) {
+		o.LoadFactor = factor
+	}
+}
+
+// WithHashKey routes the call by key, e.g. a session id, on the ring set
+// with SetHash or DefaultHash.
+func WithHashKey(key string) SelectOption {
+	return func(o *SelectOptions) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, hashKey{}, key)
+	}
+}
+
+// SetHash sets the ring used for calls with a hash key
+func SetHash(h *Hash) Option {
+	return func(o *Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, hashRingKey{}, h)
+	}
+}
+
+// ConsistentHash is a strategy routing key on DefaultHash
+func ConsistentHash(key string) Strategy {
+	return DefaultHash.Strategy(key)
+}
+
+type ring struct {
+	// the nodes the ring was built from
+	sig    string
+	hashes []uint32
+	nodes  []*registry.Node
+	// weight by node id
+	weights map[string]int
+	total   int
+}
+
+// Hash is a consistent hash ring with virtual nodes, rebuilt when the
+// nodes of a service change so only the keys of those nodes move.
+type Hash struct {
+	opts HashOptions
+
+	sync.Mutex
+	// rings by service, the most recently used first
+	rings map[string][]*ring
+	// calls in flight by node id
+	load map[string]int
+	// calls in flight by the node returned, calls to
+	// nodes not returned by the ring are not counted
+	picked map[*registry.Node]int
+}
+
+func NewHash(opts ...HashOption) *Hash {
+	options := HashOptions{
+		Replicas: DefaultReplicas,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.Replicas <= 0 {
+		options.Replicas = DefaultReplicas
+	}
+
+	return &Hash{
+		opts:   options,
+		rings:  make(map[string][]*ring),
+		load:   make(map[string]int),
+		picked: make(map[*registry.Node]int),
+	}
+}
+
+func weight(node *registry.Node) int {
+	if w, err := strconv.Atoi(node.Metadata[WeightKey]); err == nil && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func signature(nodes []*registry.Node) string {
+	sigs := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		sigs = append(sigs, n.Id+"@"+n.Address+":"+strconv.Itoa(n.Port)+"/"+strconv.Itoa(weight(n)))
+	}
+	sort.Strings(sigs)
+	return strings.Join(sigs, ",")
+}
+
+func (h *Hash) build(sig string, nodes []*registry.Node) *ring {
+	r := &ring{
+		sig:     sig,
+		weights: make(map[string]int, len(nodes)),
+	}
+
+	type point struct {
+		hash uint32
+		node *registry.Node
+	}
+	var points []point
+
+	for _, n := range nodes {
+		w := weight(n)
+		r.weights[n.Id] = w
+		r.total += w
+		for i := 0; i < h.opts.Replicas*w; i++ {
+			points = append(points, point{crc32.ChecksumIEEE([]byte(n.Id + "#" + strconv.Itoa(i))), n})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+	for _, p := range points {
+		r.hashes = append(r.hashes, p.hash)
+		r.nodes = append(r.nodes, p.node)
+	}
+
+	return r
+}
+
+// ring returns the ring of the nodes, reusing it while they are unchanged
+func (h *Hash) ring(name string, nodes []*registry.Node) *ring {
+	sig := signature(nodes)
+	rings := h.rings[name]
+	for i, r := range rings {
+		if r.sig == sig {
+			copy(rings[1:i+1], rings[:i])
+			rings[0] = r
+			return r
+		}
+	}
+
+	r := h.build(sig, nodes)
+	if len(rings) == maxRings {
+		rings = rings[:maxRings-1]
+	}
+	h.rings[name] = append([]*ring{r}, rings...)
+	return r
+}
+
+// full reports whether the node has its share of the calls in flight
+func (h *Hash) full(r *ring, id string) bool {
+	if h.opts.LoadFactor <= 0 {
+		return false
+	}
+
+	var total int
+	for nid := range r.weights {
+		total += h.load[nid]
+	}
+
+	capacity := math.Ceil(h.opts.LoadFactor * float64(total+1) * float64(r.weights[id]) / float64(r.total))
+	return float64(h.load[id]+1) > capacity
+}
+
+// Strategy returns the strategy routing key. The first node is the owner of
+// the key, or the next one on the ring with capacity when the load is bounded,
+// retries move on to the following nodes.
+func (h *Hash) Strategy(key string) Strategy {
+	return func(services []*registry.Service) Next {
+		var name string
+		var nodes []*registry.Node
+		for _, service := range services {
+			name = service.Name
+			nodes = append(nodes, service.Nodes...)
+		}
+
+		if len(nodes) == 0 {
+			return func() (*registry.Node, error) {
+				return nil, ErrNoneAvailable
+			}
+		}
+
+		h.Lock()
+		r := h.ring(name, nodes)
+		h.Unlock()
+
+		start := sort.Search(len(r.hashes), func(i int) bool {
+			return r.hashes[i] >= crc32.ChecksumIEEE([]byte(key))
+		})
+		tried := make(map[string]bool)
+		var mtx sync.Mutex
+
+		return func() (*registry.Node, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			// every node was returned, start over
+			if len(tried) == len(r.weights) {
+				tried = make(map[string]bool)
+			}
+
+			h.Lock()
+			defer h.Unlock()
+
+			var fallback *registry.Node
+			for i := 0; i < len(r.hashes); i++ {
+				node := r.nodes[(start+i)%len(r.hashes)]
+				if tried[node.Id] {
+					continue
+				}
+				if fallback == nil {
+					fallback = node
+				}
+				if h.full(r, node.Id) {
+					continue
+				}
+				fallback = node
+				break
+			}
+
+			tried[fallback.Id] = true
+			h.load[fallback.Id]++
+			h.picked[fallback]++
+			return fallback, nil
+		}
+	}
+}
+
+// Done ends a call to a node returned by the ring, the selector
+// calls it from Mark. Other nodes are ignored.
+func (h *Hash) Done(node *registry.Node) {
+	if node == nil {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if h.picked[node] == 0 {
+		return
+	}
+	if h.picked[node]--; h.picked[node] == 0 {
+		delete(h.picked, node)
+	}
+	if h.load[node.Id]--; h.load[node.Id] <= 0 {
+		delete(h.load, node.Id)
+	}
+}
@@ -0,0 +1,120 @@
+package selector
+
+import (
+	"fmt"
+	"testing"
+
+	"common/registry"
+)
+
+func testServices(n int, weights map[string]string) []*registry.Service {
+	s := &registry.Service{Name: "test.service"}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		s.Nodes = append(s.Nodes, &registry.Node{
+			Id:       id,
+			Address:  "10.0.0.1",
+			Port:     8000 + i,
+			Metadata: map[string]string{WeightKey: weights[id]},
+		})
+	}
+	return []*registry.Service{s}
+}
+
+func pick(t *testing.T, h *Hash, key string, services []*registry.Service) *registry.Node {
+	n, err := h.Strategy(key)(services)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestHashSticky(t *testing.T) {
+	h := NewHash()
+	services := testServices(5, nil)
+
+	owners := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("session-%d", i)
+		n := pick(t, h, key, services)
+		h.Done(n)
+		owners[key] = n.Id
+		if again := pick(t, h, key, services); again.Id != n.Id {
+			t.Fatalf("%s moved from %s to %s", key, n.Id, again.Id)
+		}
+		h.Done(n)
+	}
+
+	// only the keys of the removed node move
+	services[0].Nodes = services[0].Nodes[:4]
+	for key, owner := range owners {
+		n := pick(t, h, key, services)
+		h.Done(n)
+		if owner != "node-4" && n.Id != owner {
+			t.Fatalf("%s moved from %s to %s", key, owner, n.Id)
+		}
+	}
+
+	// retries move on to other nodes
+	next := h.Strategy("session-1")(services)
+	first, _ := next()
+	second, _ := next()
+	if first.Id == second.Id {
+		t.Fatal("expected the retry on another node")
+	}
+}
+
+func TestHashRings(t *testing.T) {
+	h := NewHash()
+	services := testServices(5, nil)
+	subset := []*registry.Service{{Name: "test.service", Nodes: services[0].Nodes[1:]}}
+
+	// the rings of the subsets a filter selects are kept
+	full := h.ring("test.service", services[0].Nodes)
+	part := h.ring("test.service", subset[0].Nodes)
+	if full == part {
+		t.Fatal("expected a ring per subset")
+	}
+	if h.ring("test.service", services[0].Nodes) != full || h.ring("test.service", subset[0].Nodes) != part {
+		t.Fatal("expected the rings to be reused")
+	}
+
+	for i := 0; i < maxRings; i++ {
+		h.ring("test.service", services[0].Nodes[:i+1])
+	}
+	if n := len(h.rings["test.service"]); n != maxRings {
+		t.Fatalf("expected %d rings, got %d", maxRings, n)
+	}
+}
+
+func TestHashWeights(t *testing.T) {
+	h := NewHash()
+	services := testServices(2, map[string]string{"node-1": "3"})
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		n := pick(t, h, fmt.Sprintf("session-%d", i), services)
+		h.Done(n)
+		counts[n.Id]++
+	}
+
+	if counts["node-1"] < 2*counts["node-0"] {
+		t.Fatalf("expected node-1 to get about 3 times the keys, got %v", counts)
+	}
+}
+
+func TestHashBoundedLoad(t *testing.T) {
+	h := NewHash(HashBoundedLoad(1.25))
+	services := testServices(4, nil)
+
+	// the same hot key is spread once its owner is full
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[pick(t, h, "hot", services).Id]++
+	}
+	for id, c := range counts {
+		if c > 32 {
+			t.Fatalf("node %s got %d of 100 calls in flight", id, c)
+		}
+	}
+}
@@ -30,6 +30,12 @@ type Selector interface {
 	String() string
 }
 
+// Releaser is implemented by the selectors counting the nodes returned by
+// Next as called until they are marked, Release drops a node not called
+type Releaser interface {
+	Release(service string, node *registry.Node)
+}
+
 // Next is a function that returns the next node
 // based on the selector's strategy
 type Next func() (*registry.Node, error)
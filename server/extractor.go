@@ -0,0 +1,98 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+
+	"common/registry"
+)
+
+// extractValue builds the registry value of a request or response type,
+// field names follow the json tags the codecs encode them with.
+func extractValue(v reflect.Type) *registry.Value {
+	return extractType(v, make(map[reflect.Type]bool))
+}
+
+func typeName(v reflect.Type) string {
+	if len(v.Name()) > 0 {
+		return v.Name()
+	}
+	return v.String()
+}
+
+func extractType(v reflect.Type, seen map[reflect.Type]bool) *registry.Value {
+	if v == nil {
+		return nil
+	}
+
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	arg := &registry.Value{
+		Name: typeName(v),
+		Type: typeName(v),
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		// recursive types are cut at the second visit
+		if seen[v] {
+			return arg
+		}
+		seen[v] = true
+		defer delete(seen, v)
+
+		arg.Values = extractFields(v, seen)
+	case reflect.Slice, reflect.Array:
+		elem := extractType(v.Elem(), seen)
+		arg.Type = "[]" + elem.Type
+		arg.Values = elem.Values
+	case reflect.Map:
+		elem := extractType(v.Elem(), seen)
+		arg.Type = "map[" + typeName(v.Key()) + "]" + elem.Type
+		arg.Values = elem.Values
+	case reflect.Interface, reflect.Func, reflect.Chan:
+		arg.Type = v.Kind().String()
+	}
+
+	return arg
+}
+
+func extractFields(v reflect.Type, seen map[reflect.Type]bool) []*registry.Value {
+	var values []*registry.Value
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); len(tag) > 0 {
+			if n := strings.Split(tag, ",")[0]; n == "-" {
+				continue
+			} else if len(n) > 0 {
+				name = n
+			}
+		} else if f.Anonymous {
+			// embedded structs are flattened by encoding/json
+			t := f.Type
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Struct {
+				values = append(values, extractType(t, seen).Values...)
+				continue
+			}
+		}
+
+		// unexported fields are not encoded
+		if f.PkgPath != "" {
+			continue
+		}
+
+		val := extractType(f.Type, seen)
+		val.Name = name
+		values = append(values, val)
+	}
+
+	return values
+}
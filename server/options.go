@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"common/broker"
+	"common/codec"
+	"common/registry"
+	"common/transport"
+)
+
+type Options struct {
+	Codecs    map[string]codec.NewCodec
+	Broker    broker.Broker
+	Registry  registry.Registry
+	Transport transport.Transport
+	Metadata  map[string]string
+	Name      string
+	Address   string
+	Advertise string
+	Id        string
+	Version   string
+
+	// RegisterTTL is the expiry of the registration, RegisterInterval
+	// how often the service is re-registered. A nil Registry disables
+	// registration entirely.
+	RegisterTTL      time.Duration
+	RegisterInterval time.Duration
+
+	// Middleware for handlers, subscribers and streams
+	HdlrWrappers   []HandlerWrapper
+	SubWrappers    []SubscriberWrapper
+	StreamWrappers []StreamWrapper
+
+	// Other options for implementations of the interface
+	// can be stored in a context
+	Context context.Context
+}
+
+type HandlerOptions struct {
+	Internal bool
+	Metadata map[string]map[string]string
+	// Streams are the request and response messages of stream endpoints
+	Streams map[string][]interface{}
+}
+
+type SubscriberOptions struct {
+	// AutoAck defaults to true. When a handler returns
+	// with a nil error the message is acked.
+	AutoAck  bool
+	Queue    string
+	Internal bool
+
+	// Other options for implementations of the interface
+	// can be stored in a context
+	Context context.Context
+}
+
+func newOptions(opt ...Option) Options {
+	opts := Options{
+		Codecs:           make(map[string]codec.NewCodec),
+		Metadata:         map[string]string{},
+		RegisterInterval: DefaultRegisterInterval,
+		RegisterTTL:      DefaultRegisterTTL,
+		Context:          context.Background(),
+	}
+
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	if opts.Transport == nil {
+		opts.Transport = transport.DefaultTransport
+	}
+
+	if len(opts.Address) == 0 {
+		opts.Address = DefaultAddress
+	}
+
+	if len(opts.Name) == 0 {
+		opts.Name = DefaultName
+	}
+
+	if len(opts.Id) == 0 {
+		opts.Id = DefaultId
+	}
+
+	if len(opts.Version) == 0 {
+		opts.Version = DefaultVersion
+	}
+
+	return opts
+}
+
+// Server name
+func Name(n string) Option {
+	return func(o *Options) {
+		o.Name = n
+	}
+}
+
+// Unique server id
+func Id(id string) Option {
+	return func(o *Options) {
+		o.Id = id
+	}
+}
+
+// Version of the service
+func Version(v string) Option {
+	return func(o *Options) {
+		o.Version = v
+	}
+}
+
+// Address to bind to - host:port
+func Address(a string) Option {
+	return func(o *Options) {
+		o.Address = a
+	}
+}
+
+// The address to advertise for discovery - host:port
+func Advertise(a string) Option {
+	return func(o *Options) {
+		o.Advertise = a
+	}
+}
+
+// Codec to use to encode/decode requests for a given content type
+func Codec(contentType string, c codec.NewCodec) Option {
+	return func(o *Options) {
+		o.Codecs[contentType] = c
+	}
+}
+
+// Context specifies a context for the service.
+// Can be used to signal shutdown of the service
+// Can be used for extra option values.
+func Context(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Context = ctx
+	}
+}
+
+// Broker to use for pub/sub
+func Broker(b broker.Broker) Option {
+	return func(o *Options) {
+		o.Broker = b
+	}
+}
+
+// Registry used for discovery
+func Registry(r registry.Registry) Option {
+	return func(o *Options) {
+		o.Registry = r
+	}
+}
+
+// Transport mechanism for communication e.g http, rabbitmq, etc
+func Transport(t transport.Transport) Option {
+	return func(o *Options) {
+		o.Transport = t
+	}
+}
+
+// Metadata associated with the server
+func Metadata(md map[string]string) Option {
+	return func(o *Options) {
+		o.Metadata = md
+	}
+}
+
+// Register the service with a TTL
+func RegisterTTL(t time.Duration) Option {
+	return func(o *Options) {
+		o.RegisterTTL = t
+	}
+}
+
+// Register the service with at interval
+func RegisterInterval(t time.Duration) Option {
+	return func(o *Options) {
+		o.RegisterInterval = t
+	}
+}
+
+// EndpointMetadata is a Handler option that allows metadata to be added to
+// individual endpoints.
+func EndpointMetadata(name string, md map[string]string) HandlerOption {
+	return func(o *HandlerOptions) {
+		o.Metadata[name] = md
+	}
+}
+
+// StreamMessages is a Handler option setting the request and response
+// messages of a stream endpoint, registered as its schema. Stream handlers
+// take an untyped Stream, their schemas are empty without it.
+func StreamMessages(name string, req, rsp interface{}) HandlerOption {
+	return func(o *HandlerOptions) {
+		if o.Streams == nil {
+			o.Streams = make(map[string][]interface{})
+		}
+		o.Streams[name] = []interface{}{req, rsp}
+	}
+}
+
+// InternalHandler options specifies that a handler is not advertised
+// to the discovery system. In the future this may also limit request
+// to the internal network or authorised user.
+func InternalHandler(b bool) HandlerOption {
+	return func(o *HandlerOptions) {
+		o.Internal = b
+	}
+}
+
+// WrapHandler adds a handler Wrapper to a list of options passed into the server
+func WrapHandler(w HandlerWrapper) Option {
+	return func(o *Options) {
+		o.HdlrWrappers = append(o.HdlrWrappers, w)
+	}
+}
+
+// WrapSubscriber adds a subscriber Wrapper to a list of options passed into the server
+func WrapSubscriber(w SubscriberWrapper) Option {
+	return func(o *Options) {
+		o.SubWrappers = append(o.SubWrappers, w)
+	}
+}
+
+// WrapStream adds a stream Wrapper to a list of options passed into the server
+func WrapStream(w StreamWrapper) Option {
+	return func(o *Options) {
+		o.StreamWrappers = append(o.StreamWrappers, w)
+	}
+}
+
+// DisableAutoAck will disable auto acking of messages
+// after they have been handled.
+func DisableAutoAck() SubscriberOption {
+	return func(o *SubscriberOptions) {
+		o.AutoAck = false
+	}
+}
+
+// SubscriberQueue sets the shared queue name distributed messages across subscribers
+func SubscriberQueue(n string) SubscriberOption {
+	return func(o *SubscriberOptions) {
+		o.Queue = n
+	}
+}
+
+// InternalSubscriber specifies that a subscriber is not advertised
+// to the discovery system.
+func InternalSubscriber(b bool) SubscriberOption {
+	return func(o *SubscriberOptions) {
+		o.Internal = b
+	}
+}
+
+// SubscriberContext set context options to allow broker SubscriberOption passed
+func SubscriberContext(ctx context.Context) SubscriberOption {
+	return func(o *SubscriberOptions) {
+		o.Context = ctx
+	}
+}
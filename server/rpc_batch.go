@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+
+	"common/codec/compress"
+	"common/codec/jsonrpc"
+	"common/log/log"
+	"common/transport"
+)
+
+// batchSocket collects the responses to one request of a batch,
+// streams can't be served as part of a batch.
+type batchSocket struct {
+	transport.Socket
+	rsps [][]byte
+}
+
+func (b *batchSocket) Recv(m *transport.Message) error {
+	return io.EOF
+}
+
+func (b *batchSocket) Send(m *transport.Message) error {
+	b.rsps = append(b.rsps, m.Body)
+	return nil
+}
+
+func (b *batchSocket) Close() error {
+	return nil
+}
+
+// serveBatch serves the requests of a json-rpc batch in order and sends
+// their responses back in a single message.
+func (s *rpcServer) serveBatch(sock transport.Socket, msg *transport.Message, reqs []json.RawMessage) {
+	var rsps [][]byte
+
+	for _, req := range reqs {
+		// the envelope of each request names its endpoint and id
+		hdr := make(map[string]string, len(msg.Header))
+		for k, v := range msg.Header {
+			switch k {
+			case "Micro-Id", "Micro-Method", "Micro-Endpoint", "Micro-Stream",
+				"Content-Encoding", "Accept-Encoding":
+				continue
+			}
+			hdr[k] = v
+		}
+
+		bs := &batchSocket{Socket: sock}
+		s.serveMessage(bs, &transport.Message{Header: hdr, Body: req})
+		rsps = append(rsps, bs.rsps...)
+	}
+
+	s.sendBatch(sock, msg, jsonrpc.Join(rsps))
+}
+
+// sendBatch sends the response to a batch
+func (s *rpcServer) sendBatch(sock transport.Socket, msg *transport.Message, rsp []byte) {
+	hdr := map[string]string{
+		"Content-Type": msg.Header["Content-Type"],
+	}
+	if id := getHeader("Micro-Id", msg.Header); len(id) > 0 {
+		hdr["Micro-Id"] = id
+	}
+
+	// the batch is compressed as a whole
+	body, enc, err := compress.Compress(msg.Header["Accept-Encoding"], msg.Header["Content-Type"], rsp)
+	if err != nil {
+		log.Errorf("rpc: unable to compress batch response: %v", err)
+		return
+	}
+	if len(enc) > 0 {
+		hdr["Content-Encoding"] = enc
+	}
+
+	if err := sock.Send(&transport.Message{
+		Header: hdr,
+		Body:   body,
+	}); err != nil {
+		log.Errorf("rpc: unable to write batch response: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+
+	"common/codec"
+	raw "common/codec/bytes"
+	"common/codec/compress"
+	"common/codec/json"
+	"common/codec/jsonrpc"
+	"common/codec/msgpack"
+	"common/codec/proto"
+	merrors "common/errors"
+	"common/transport"
+)
+
+const (
+	lastStreamResponseError = "EOS"
+)
+
+type rpcCodec struct {
+	socket transport.Socket
+	codec  codec.Codec
+
+	req *transport.Message
+	buf *readWriteCloser
+
+	// check if we're the first
+	sync.RWMutex
+	first chan bool
+}
+
+type readWriteCloser struct {
+	sync.RWMutex
+	wbuf *bytes.Buffer
+	rbuf *bytes.Buffer
+}
+
+var (
+	DefaultContentType = "application/json"
+
+	DefaultCodecs = map[string]codec.NewCodec{
+		"application/json":         json.NewCodec,
+		"application/json-rpc":     jsonrpc.NewCodec,
+		"application/octet-stream": raw.NewCodec,
+		"application/protobuf":     proto.NewCodec,
+		"application/proto-rpc":    proto.NewCodec,
+		"application/msgpack":      msgpack.NewCodec,
+	}
+)
+
+func (rwc *readWriteCloser) Read(p []byte) (n int, err error) {
+	rwc.RLock()
+	defer rwc.RUnlock()
+	return rwc.rbuf.Read(p)
+}
+
+func (rwc *readWriteCloser) Write(p []byte) (n int, err error) {
+	rwc.Lock()
+	defer rwc.Unlock()
+	return rwc.wbuf.Write(p)
+}
+
+func (rwc *readWriteCloser) Close() error {
+	rwc.rbuf.Reset()
+	rwc.wbuf.Reset()
+	return nil
+}
+
+func getHeader(hdr string, md map[string]string) string {
+	if hd := md[hdr]; len(hd) > 0 {
+		return hd
+	}
+	return md["X-"+hdr]
+}
+
+func getHeaders(m *codec.Message) {
+	set := func(v, hdr string) string {
+		if len(v) > 0 {
+			return v
+		}
+		return getHeader(hdr, m.Header)
+	}
+
+	m.Id = set(m.Id, "Micro-Id")
+	m.Error = set(m.Error, "Micro-Error")
+	m.Endpoint = set(m.Endpoint, "Micro-Endpoint")
+	m.Method = set(m.Method, "Micro-Method")
+	m.Target = set(m.Target, "Micro-Service")
+
+	// older clients only send the method
+	if len(m.Endpoint) == 0 {
+		m.Endpoint = m.Method
+	}
+}
+
+func setHeaders(m, r *codec.Message) {
+	set := func(hdr, v string) {
+		if len(v) == 0 {
+			return
+		}
+		m.Header[hdr] = v
+	}
+
+	// set headers
+	set("Micro-Id", r.Id)
+	set("Micro-Service", r.Target)
+	set("Micro-Method", r.Method)
+	set("Micro-Endpoint", r.Endpoint)
+	set("Micro-Error", r.Error)
+}
+
+func newRpcCodec(req *transport.Message, socket transport.Socket, c codec.NewCodec) codec.Codec {
+	rwc := &readWriteCloser{
+		rbuf: bytes.NewBuffer(nil),
+		wbuf: bytes.NewBuffer(nil),
+	}
+
+	r := &rpcCodec{
+		buf:    rwc,
+		codec:  c(rwc),
+		req:    req,
+		socket: socket,
+		first:  make(chan bool),
+	}
+
+	return r
+}
+
+func (c *rpcCodec) ReadHeader(r *codec.Message, t codec.MessageType) error {
+	// the initial message
+	m := codec.Message{
+		Header: c.req.Header,
+		Body:   c.req.Body,
+	}
+
+	// first message could be pre-loaded
+	select {
+	case <-c.first:
+		// not the first
+		var tm transport.Message
+
+		// read off the socket
+		if err := c.socket.Recv(&tm); err != nil {
+			return err
+		}
+		// bodies are read in their plain form
+		body, err := compress.Decompress(tm.Header["Content-Encoding"], tm.Body)
+		if err != nil {
+			return err
+		}
+		tm.Body = body
+
+		// reset the read buffer
+		c.buf.rbuf.Reset()
+
+		// write the body to the buffer
+		if _, err := c.buf.rbuf.Write(tm.Body); err != nil {
+			return err
+		}
+
+		// set the message header
+		m.Header = tm.Header
+		// set the message body
+		m.Body = tm.Body
+
+		// set req
+		c.req = &tm
+	default:
+		// the channel avoids taking the lock on every read,
+		// recheck under the lock before closing it
+		c.Lock()
+		select {
+		case <-c.first:
+		default:
+			// disable first
+			close(c.first)
+		}
+		// now unlock and we never need this again
+		c.Unlock()
+
+		// the body was already consumed, copy it into the buffer
+		c.buf.rbuf.Reset()
+		if _, err := c.buf.rbuf.Write(m.Body); err != nil {
+			return err
+		}
+	}
+
+	// set some internal things
+	getHeaders(&m)
+
+	// read header via codec
+	if err := c.codec.ReadHeader(&m, codec.Request); err != nil {
+		return err
+	}
+
+	// set message
+	*r = m
+
+	return nil
+}
+
+func (c *rpcCodec) ReadBody(b interface{}) error {
+	// don't read empty body
+	if len(c.req.Body) == 0 {
+		return nil
+	}
+	// read raw data
+	if v, ok := b.(*raw.Frame); ok {
+		v.Data = c.req.Body
+		return nil
+	}
+	// decode the usual way
+	return c.codec.ReadBody(b)
+}
+
+func (c *rpcCodec) Write(r *codec.Message, b interface{}) error {
+	c.buf.wbuf.Reset()
+
+	// create a new message
+	m := &codec.Message{
+		Target:   r.Target,
+		Method:   r.Method,
+		Endpoint: r.Endpoint,
+		Id:       r.Id,
+		Error:    r.Error,
+		Type:     r.Type,
+		Header:   r.Header,
+	}
+
+	if m.Header == nil {
+		m.Header = map[string]string{}
+	}
+
+	setHeaders(m, r)
+
+	// the body being sent
+	var body []byte
+
+	// is it a raw frame?
+	if v, ok := b.(*raw.Frame); ok {
+		body = v.Data
+		// if we have encoded data just send it
+	} else if len(r.Body) > 0 {
+		body = r.Body
+		// write the body to codec
+		// codecs such as json-rpc also encode errors in the body
+	} else if b != nil || len(r.Error) > 0 {
+		if err := c.codec.Write(m, b); err != nil {
+			c.buf.wbuf.Reset()
+
+			// write an error if it failed
+			m.Error = merrors.InternalServerError(m.Target, "unable to encode body: %v", err).Error()
+			m.Header["Micro-Error"] = m.Error
+			// no body to write
+			if err := c.codec.Write(m, nil); err != nil {
+				return err
+			}
+		}
+
+		// set the body, copied as the buffer is reused by the next
+		// write while in-process sockets still hold the message
+		body = append([]byte(nil), c.buf.wbuf.Bytes()...)
+	}
+
+	// compress the body with an encoding the client accepts
+	delete(m.Header, "Content-Encoding")
+	if len(body) > 0 {
+		b, enc, err := compress.Compress(c.req.Header["Accept-Encoding"], c.req.Header["Content-Type"], body)
+		if err != nil {
+			return err
+		}
+		if len(enc) > 0 {
+			body = b
+			m.Header["Content-Encoding"] = enc
+		}
+	}
+
+	// Set content type if theres content
+	if len(body) > 0 {
+		m.Header["Content-Type"] = c.req.Header["Content-Type"]
+	}
+
+	// send on the socket
+	return c.socket.Send(&transport.Message{
+		Header: m.Header,
+		Body:   body,
+	})
+}
+
+func (c *rpcCodec) Close() error {
+	// close the codec
+	c.codec.Close()
+	// close the socket
+	return c.socket.Close()
+}
+
+func (c *rpcCodec) String() string {
+	return "rpc"
+}
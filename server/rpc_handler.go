@@ -0,0 +1,114 @@
+package server
+
+import (
+	"reflect"
+
+	"common/registry"
+)
+
+type rpcHandler struct {
+	name      string
+	handler   interface{}
+	endpoints []*registry.Endpoint
+	opts      HandlerOptions
+}
+
+var (
+	typeOfStream = reflect.TypeOf((*Stream)(nil)).Elem()
+)
+
+// extractEndpoint builds the registry endpoint for a method, it returns nil
+// if the method does not look like a handler.
+func extractEndpoint(method reflect.Method) *registry.Endpoint {
+	if method.PkgPath != "" {
+		return nil
+	}
+
+	var stream bool
+	mt := method.Type
+
+	switch mt.NumIn() {
+	case 3:
+		stream = mt.In(2).Implements(typeOfStream) || mt.In(2) == typeOfStream
+	case 4:
+	default:
+		return nil
+	}
+
+	if mt.NumOut() != 1 || mt.Out(0) != typeOfError {
+		return nil
+	}
+
+	ep := &registry.Endpoint{
+		Name:     method.Name,
+		Metadata: make(map[string]string),
+	}
+
+	if stream {
+		ep.Metadata["stream"] = "true"
+	} else {
+		ep.Request = extractValue(mt.In(2))
+		ep.Response = extractValue(mt.In(3))
+	}
+	ep.Metadata[registry.SchemaKey] = registry.Fingerprint(ep)
+
+	return ep
+}
+
+func newRpcHandler(handler interface{}, opts ...HandlerOption) Handler {
+	options := HandlerOptions{
+		Metadata: make(map[string]map[string]string),
+	}
+
+	for _, o := range opts {
+		o(&options)
+	}
+
+	typ := reflect.TypeOf(handler)
+	hdlr := reflect.ValueOf(handler)
+	name := reflect.Indirect(hdlr).Type().Name()
+
+	var endpoints []*registry.Endpoint
+
+	for m := 0; m < typ.NumMethod(); m++ {
+		if e := extractEndpoint(typ.Method(m)); e != nil {
+			e.Name = name + "." + e.Name
+
+			for k, v := range options.Metadata[e.Name] {
+				e.Metadata[k] = v
+			}
+
+			// the messages of a stream are known from the options only
+			if msgs, ok := options.Streams[e.Name]; ok && e.Metadata["stream"] == "true" {
+				e.Request = extractValue(reflect.TypeOf(msgs[0]))
+				e.Response = extractValue(reflect.TypeOf(msgs[1]))
+				e.Metadata[registry.SchemaKey] = registry.Fingerprint(e)
+			}
+
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	return &rpcHandler{
+		name:      name,
+		handler:   handler,
+		endpoints: endpoints,
+		opts:      options,
+	}
+}
+
+func (r *rpcHandler) Name() string {
+	return r.name
+}
+
+func (r *rpcHandler) Handler() interface{} {
+	return r.handler
+}
+
+func (r *rpcHandler) Endpoints() []*registry.Endpoint {
+	return r.endpoints
+}
+
+func (r *rpcHandler) Options() HandlerOptions {
+	return r.opts
+}
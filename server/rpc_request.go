@@ -0,0 +1,70 @@
+package server
+
+import (
+	"common/codec"
+	"common/transport"
+)
+
+type rpcRequest struct {
+	service     string
+	method      string
+	endpoint    string
+	contentType string
+	socket      transport.Socket
+	codec       codec.Codec
+	header      map[string]string
+	body        []byte
+	rawBody     interface{}
+	stream      bool
+	first       bool
+}
+
+func (r *rpcRequest) Codec() codec.Reader {
+	return r.codec
+}
+
+func (r *rpcRequest) ContentType() string {
+	return r.contentType
+}
+
+func (r *rpcRequest) Service() string {
+	return r.service
+}
+
+func (r *rpcRequest) Method() string {
+	return r.method
+}
+
+func (r *rpcRequest) Endpoint() string {
+	return r.endpoint
+}
+
+func (r *rpcRequest) Header() map[string]string {
+	return r.header
+}
+
+func (r *rpcRequest) Body() interface{} {
+	return r.rawBody
+}
+
+func (r *rpcRequest) Read() ([]byte, error) {
+	// got a body
+	if r.first {
+		b := r.body
+		r.first = false
+		return b, nil
+	}
+
+	var msg transport.Message
+	err := r.socket.Recv(&msg)
+	if err != nil {
+		return nil, err
+	}
+	r.header = msg.Header
+
+	return msg.Body, nil
+}
+
+func (r *rpcRequest) Stream() bool {
+	return r.stream
+}
@@ -0,0 +1,389 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"common/codec"
+	merrors "common/errors"
+	"common/log/log"
+	"common/util/app/errcode"
+)
+
+var (
+	// Precompute the reflect type for error. Can't use error directly
+	// because Typeof takes an empty interface value. This is annoying.
+	typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type methodType struct {
+	method      reflect.Method
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	ContextType reflect.Type
+	stream      bool
+}
+
+type service struct {
+	name   string                 // name of service
+	rcvr   reflect.Value          // receiver of methods for the service
+	typ    reflect.Type           // type of the receiver
+	method map[string]*methodType // registered methods
+}
+
+// router dispatches requests to the reflected handler methods
+type router struct {
+	mu         sync.RWMutex
+	serviceMap map[string]*service
+
+	// wrappers applied to every handler and stream call
+	hdlrWrappers   []HandlerWrapper
+	streamWrappers []StreamWrapper
+}
+
+func newRpcRouter() *router {
+	return &router{
+		serviceMap: make(map[string]*service),
+	}
+}
+
+// setWrappers replaces the handler and stream wrappers used by the router
+func (r *router) setWrappers(hw []HandlerWrapper, sw []StreamWrapper) {
+	r.mu.Lock()
+	r.hdlrWrappers = hw
+	r.streamWrappers = sw
+	r.mu.Unlock()
+}
+
+// Is this an exported - upper case - name?
+func isExported(name string) bool {
+	for _, r := range name {
+		return r >= 'A' && r <= 'Z'
+	}
+	return false
+}
+
+// Is this type exported or a builtin?
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// PkgPath will be non-empty even for an exported type,
+	// so we need to check the type name as well.
+	return isExported(t.Name()) || t.PkgPath() == ""
+}
+
+// prepareMethod returns a methodType for the provided method or nil
+// in case if the method was unsuitable.
+func prepareMethod(method reflect.Method) *methodType {
+	mtype := method.Type
+	mname := method.Name
+	var replyType, argType, contextType reflect.Type
+	var stream bool
+
+	// Method must be exported.
+	if method.PkgPath != "" {
+		return nil
+	}
+
+	switch mtype.NumIn() {
+	case 3:
+		// assuming streaming
+		argType = mtype.In(2)
+		contextType = mtype.In(1)
+		stream = true
+	case 4:
+		// method that takes a context
+		argType = mtype.In(2)
+		replyType = mtype.In(3)
+		contextType = mtype.In(1)
+	default:
+		log.Errorf("method %v of %v has wrong number of ins: %v", mname, mtype, mtype.NumIn())
+		return nil
+	}
+
+	if stream {
+		// check stream type
+		if !argType.Implements(typeOfStream) && argType != typeOfStream {
+			log.Errorf("%v argument does not implement Stream interface: %v", mname, argType)
+			return nil
+		}
+	} else {
+		// if not stream check the replyType
+
+		// First arg need not be a pointer.
+		if !isExportedOrBuiltinType(argType) {
+			log.Errorf("%v argument type not exported: %v", mname, argType)
+			return nil
+		}
+
+		if replyType.Kind() != reflect.Ptr {
+			log.Errorf("method %v reply type not a pointer: %v", mname, replyType)
+			return nil
+		}
+
+		// Reply type must be exported.
+		if !isExportedOrBuiltinType(replyType) {
+			log.Errorf("method %v reply type not exported: %v", mname, replyType)
+			return nil
+		}
+	}
+
+	// Method needs one out.
+	if mtype.NumOut() != 1 {
+		log.Errorf("method %v has wrong number of outs: %v", mname, mtype.NumOut())
+		return nil
+	}
+	// The return type of the method must be error.
+	if returnType := mtype.Out(0); returnType != typeOfError {
+		log.Errorf("method %v returns %v not error", mname, returnType.String())
+		return nil
+	}
+	return &methodType{method: method, ArgType: argType, ReplyType: replyType, ContextType: contextType, stream: stream}
+}
+
+// Handle registers all suitable methods of the handler under its name
+func (r *router) Handle(h Handler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := new(service)
+	s.typ = reflect.TypeOf(h.Handler())
+	s.rcvr = reflect.ValueOf(h.Handler())
+
+	// check name
+	name := h.Name()
+	if len(name) == 0 {
+		return errors.New("rpc.Handle: handler has no name")
+	}
+	if !isExported(name) {
+		return errors.New("rpc.Handle: type " + name + " is not exported")
+	}
+
+	// check we don't already have this handler
+	if _, present := r.serviceMap[name]; present {
+		return errors.New("rpc.Handle: service already defined: " + name)
+	}
+
+	s.name = name
+	s.method = make(map[string]*methodType)
+
+	// Install the methods
+	for m := 0; m < s.typ.NumMethod(); m++ {
+		method := s.typ.Method(m)
+		if mt := prepareMethod(method); mt != nil {
+			s.method[method.Name] = mt
+		}
+	}
+
+	// Check there are methods
+	if len(s.method) == 0 {
+		return errors.New("rpc Register: type " + s.name + " has no exported methods of suitable type")
+	}
+
+	// save handler
+	r.serviceMap[s.name] = s
+	return nil
+}
+
+// split parses a "Service.Method" endpoint
+func split(endpoint string) (string, string, error) {
+	endpoint = strings.TrimPrefix(endpoint, "/")
+
+	dot := strings.LastIndex(endpoint, ".")
+	if dot <= 0 || dot == len(endpoint)-1 {
+		return "", "", merrors.BadRequest("go.micro.server", "rpc: service/endpoint request ill-formed: %s", endpoint)
+	}
+
+	return endpoint[:dot], endpoint[dot+1:], nil
+}
+
+func (r *router) lookup(endpoint string) (*service, *methodType, error) {
+	serviceName, methodName, err := split(endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Look up the request.
+	r.mu.RLock()
+	svc := r.serviceMap[serviceName]
+	r.mu.RUnlock()
+	if svc == nil {
+		return nil, nil, merrors.NotFound("go.micro.server", "unknown service %s", serviceName)
+	}
+
+	mtype := svc.method[methodName]
+	if mtype == nil {
+		return nil, nil, merrors.NotFound("go.micro.server", "unknown service %s.%s", serviceName, methodName)
+	}
+
+	return svc, mtype, nil
+}
+
+// errorString serializes err as a typed error, handlers returning plain
+// errors are reported as internal server errors of the service. The end
+// of stream marker is sent as is.
+func errorString(service string, err error) string {
+	if err.Error() == lastStreamResponseError {
+		return lastStreamResponseError
+	}
+
+	switch e := err.(type) {
+	case *merrors.Error:
+		return e.Error()
+	case *errcode.Error:
+		return merrors.FromErrcode(service, e).Error()
+	}
+
+	return merrors.InternalServerError(service, "%v", err).Error()
+}
+
+// writeError sends an error back for the request on the response codec
+func writeError(req Request, rsp Response, id string, err error) error {
+	msg := &codec.Message{
+		Target:   req.Service(),
+		Method:   req.Method(),
+		Endpoint: req.Endpoint(),
+		Id:       id,
+		Error:    errorString(req.Service(), err),
+		Type:     codec.Error,
+	}
+
+	return rsp.Codec().Write(msg, nil)
+}
+
+// ServeRequest decodes the request, calls the handler and writes the response.
+// Errors returned by the handler are sent back to the client.
+func (r *router) ServeRequest(ctx context.Context, req Request, rsp Response) error {
+	id := req.Header()["Micro-Id"]
+
+	svc, mtype, err := r.lookup(req.Endpoint())
+	if err != nil {
+		// codecs such as json-rpc answer with the id of the header
+		// they read, and not at all to notifications
+		var msg codec.Message
+		req.Codec().ReadHeader(&msg, codec.Request)
+		return writeError(req, rsp, id, err)
+	}
+
+	// the stream reads its own messages
+	if mtype.stream {
+		return r.serveStream(ctx, svc, mtype, req, rsp, id)
+	}
+
+	var msg codec.Message
+	if err := req.Codec().ReadHeader(&msg, codec.Request); err != nil {
+		return writeError(req, rsp, id, merrors.BadRequest(req.Service(), "%v", err))
+	}
+
+	// Decode the argument value.
+	var argv reflect.Value
+	argIsValue := false // if true, need to indirect before calling.
+	if mtype.ArgType.Kind() == reflect.Ptr {
+		argv = reflect.New(mtype.ArgType.Elem())
+	} else {
+		argv = reflect.New(mtype.ArgType)
+		argIsValue = true
+	}
+
+	// argv guaranteed to be a pointer now.
+	if err := req.Codec().ReadBody(argv.Interface()); err != nil {
+		return writeError(req, rsp, id, merrors.BadRequest(req.Service(), "%v", err))
+	}
+
+	if argIsValue {
+		argv = argv.Elem()
+	}
+
+	replyv := reflect.New(mtype.ReplyType.Elem())
+
+	// the handler call
+	fn := func(ctx context.Context, req Request, rsp interface{}) (err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Errorf("panic recovered in %s: %v", req.Endpoint(), rec)
+				err = fmt.Errorf("panic recovered: %v", rec)
+			}
+		}()
+
+		returnValues := mtype.method.Func.Call([]reflect.Value{svc.rcvr, reflect.ValueOf(ctx), argv, reflect.ValueOf(rsp)})
+
+		// The return value for the method is an error.
+		if rerr := returnValues[0].Interface(); rerr != nil {
+			return rerr.(error)
+		}
+
+		return nil
+	}
+
+	// wrap the handler func
+	r.mu.RLock()
+	for i := len(r.hdlrWrappers); i > 0; i-- {
+		fn = r.hdlrWrappers[i-1](fn)
+	}
+	r.mu.RUnlock()
+
+	if err := fn(ctx, req, replyv.Interface()); err != nil {
+		return writeError(req, rsp, id, err)
+	}
+
+	return rsp.Codec().Write(&codec.Message{
+		Target:   req.Service(),
+		Method:   req.Method(),
+		Endpoint: req.Endpoint(),
+		Id:       id,
+		Type:     codec.Response,
+	}, replyv.Interface())
+}
+
+// serveStream hands the connection over to a streaming handler, the client
+// is told the stream has ended with an EOS error once the handler returns.
+func (r *router) serveStream(ctx context.Context, svc *service, mtype *methodType, req Request, rsp Response, id string) error {
+	var stream Stream = &rpcStream{
+		id:      id,
+		context: ctx,
+		codec:   req.Codec().(codec.Codec),
+		request: req,
+	}
+
+	fn := func(ctx context.Context, req Request, stream interface{}) (err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Errorf("panic recovered in %s: %v", req.Endpoint(), rec)
+				err = fmt.Errorf("panic recovered: %v", rec)
+			}
+		}()
+
+		returnValues := mtype.method.Func.Call([]reflect.Value{svc.rcvr, reflect.ValueOf(ctx), reflect.ValueOf(stream)})
+
+		if rerr := returnValues[0].Interface(); rerr != nil {
+			return rerr.(error)
+		}
+
+		return nil
+	}
+
+	// wrap the stream and the handler func
+	r.mu.RLock()
+	for i := len(r.streamWrappers); i > 0; i-- {
+		stream = r.streamWrappers[i-1](stream)
+	}
+	for i := len(r.hdlrWrappers); i > 0; i-- {
+		fn = r.hdlrWrappers[i-1](fn)
+	}
+	r.mu.RUnlock()
+
+	if err := fn(ctx, req, stream); err != nil {
+		return writeError(req, rsp, id, err)
+	}
+
+	return writeError(req, rsp, id, errors.New(lastStreamResponseError))
+}
@@ -0,0 +1,685 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"common/broker"
+	"common/codec"
+	"common/codec/compress"
+	"common/codec/jsonrpc"
+	merrors "common/errors"
+	"common/log/log"
+	"common/rabbitmq"
+	"common/registry"
+	service_wrapper "common/service-wrapper"
+	"common/transport"
+	maddr "common/util/addr"
+	"common/util/buf"
+)
+
+// transportHeaders are the wire level headers not carried into the
+// handler metadata, they are set again on any downstream call.
+var transportHeaders = map[string]bool{
+	"Content-Type":     true,
+	"Content-Length":   true,
+	"Accept":           true,
+	"Accept-Encoding":  true,
+	"Content-Encoding": true,
+	"Timeout":          true,
+	"User-Agent":       true,
+	"Micro-Id":         true,
+	"Micro-Service":    true,
+	"Micro-Method":     true,
+	"Micro-Endpoint":   true,
+	"Micro-Error":      true,
+	"Micro-Stream":     true,
+	"Micro-Multiplex":  true,
+}
+
+// serverSocket serialises the responses written to a connection
+type serverSocket struct {
+	transport.Socket
+	sync.Mutex
+}
+
+func (s *serverSocket) Send(m *transport.Message) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.Socket.Send(m)
+}
+
+type rpcServer struct {
+	router *router
+	exit   chan chan error
+
+	sync.RWMutex
+	opts        Options
+	handlers    map[string]Handler
+	subscribers map[Subscriber][]broker.Subscriber
+	// marks the serve as started
+	started bool
+	// used for first registration
+	registered bool
+	// graceful exit
+	wg *sync.WaitGroup
+	// set while the requests in flight are drained, no more are taken
+	draining bool
+}
+
+func newRpcServer(opts ...Option) Server {
+	options := newOptions(opts...)
+
+	router := newRpcRouter()
+	router.setWrappers(options.HdlrWrappers, options.StreamWrappers)
+
+	return &rpcServer{
+		opts:        options,
+		router:      router,
+		handlers:    make(map[string]Handler),
+		subscribers: make(map[Subscriber][]broker.Subscriber),
+		exit:        make(chan chan error),
+		wg:          new(sync.WaitGroup),
+	}
+}
+
+// newMetadata copies the inbound headers into the metadata passed to
+// handlers, leaving out the transport level headers.
+func newMetadata(hdr map[string]string) service_wrapper.MetaData {
+	md := make(service_wrapper.MetaData, len(hdr))
+	for k, v := range hdr {
+		if transportHeaders[k] || transportHeaders[strings.TrimPrefix(k, "X-")] {
+			continue
+		}
+		md[k] = v
+	}
+	return md
+}
+
+func (s *rpcServer) newCodec(contentType string) (codec.NewCodec, error) {
+	if cf, ok := s.opts.Codecs[contentType]; ok {
+		return cf, nil
+	}
+	if cf, ok := DefaultCodecs[contentType]; ok {
+		return cf, nil
+	}
+	return nil, fmt.Errorf("unsupported Content-Type: %s", contentType)
+}
+
+// ServeConn serves a single connection, unary requests are handled in order
+// until the client goes away, a stream takes over the connection until the
+// handler returns.
+func (s *rpcServer) ServeConn(sock transport.Socket) {
+	// streams are closed by the handler
+	defer func() {
+		sock.Close()
+
+		if r := recover(); r != nil {
+			log.Errorf("panic recovered serving %s: %v", sock.Remote(), r)
+		}
+	}()
+
+	// multiplexed calls write their responses concurrently
+	sock = &serverSocket{Socket: sock}
+
+	for {
+		var msg transport.Message
+		if err := sock.Recv(&msg); err != nil {
+			return
+		}
+
+		// the client has closed a stream we are no longer serving
+		if getHeader("Micro-Error", msg.Header) == lastStreamResponseError {
+			continue
+		}
+
+		// track in-flight requests so Stop can drain them
+		s.RLock()
+		if s.draining {
+			s.RUnlock()
+			return
+		}
+		s.wg.Add(1)
+		s.RUnlock()
+
+		// calls sharing a connection are served concurrently,
+		// the responses are matched by their Micro-Id
+		if len(getHeader("Micro-Multiplex", msg.Header)) > 0 && len(getHeader("Micro-Stream", msg.Header)) == 0 {
+			go func(msg transport.Message) {
+				defer s.wg.Done()
+				s.serveMessage(sock, &msg)
+			}(msg)
+			continue
+		}
+
+		stream := s.serveMessage(sock, &msg)
+		s.wg.Done()
+
+		// the stream owned the socket
+		if stream {
+			return
+		}
+	}
+}
+
+// serveMessage decodes and dispatches one inbound message, reporting
+// whether the message opened a stream.
+func (s *rpcServer) serveMessage(sock transport.Socket, msg *transport.Message) bool {
+	// we use this Content-Type header to identify the codec needed
+	ct := msg.Header["Content-Type"]
+	if len(ct) == 0 {
+		msg.Header["Content-Type"] = DefaultContentType
+		ct = DefaultContentType
+	}
+
+	cf, err := s.newCodec(ct)
+
+	// the body is handled in its plain form
+	if err == nil {
+		msg.Body, err = compress.Decompress(msg.Header["Content-Encoding"], msg.Body)
+	}
+
+	if err != nil {
+		rerr := merrors.BadRequest("go.micro.server", "%v", err).Error()
+		sock.Send(&transport.Message{
+			Header: map[string]string{
+				"Content-Type": "text/plain",
+				"Micro-Id":     msg.Header["Micro-Id"],
+				"Micro-Error":  rerr,
+			},
+			Body: []byte(rerr),
+		})
+		return false
+	}
+
+	// the requests of a json-rpc batch are served one by one
+	if ct == "application/json-rpc" {
+		if reqs, ok, err := jsonrpc.Split(msg.Body); ok {
+			if err != nil {
+				s.sendBatch(sock, msg, jsonrpc.BatchError(err))
+			} else {
+				s.serveBatch(sock, msg, reqs)
+			}
+			return false
+		}
+	}
+
+	rcodec := newRpcCodec(msg, sock, cf)
+
+	// internal request
+	request := &rpcRequest{
+		service:     getHeader("Micro-Service", msg.Header),
+		method:      getHeader("Micro-Method", msg.Header),
+		endpoint:    getHeader("Micro-Endpoint", msg.Header),
+		contentType: ct,
+		codec:       rcodec,
+		header:      msg.Header,
+		body:        msg.Body,
+		socket:      sock,
+		stream:      len(getHeader("Micro-Stream", msg.Header)) > 0,
+		first:       true,
+	}
+
+	if len(request.endpoint) == 0 {
+		request.endpoint = request.method
+	}
+
+	// codecs such as json-rpc carry the endpoint in the body
+	if len(request.endpoint) == 0 && len(msg.Body) > 0 {
+		var m codec.Message
+		if err := cf(buf.New(bytes.NewBuffer(msg.Body))).ReadHeader(&m, codec.Request); err == nil {
+			request.method = m.Method
+			request.endpoint = m.Endpoint
+		}
+	}
+
+	// internal response
+	response := &rpcResponse{
+		header: make(map[string]string),
+		socket: sock,
+		codec:  rcodec,
+	}
+
+	// honour the client's timeout, sent in nanoseconds
+	ctx := s.opts.Context
+	var cancel context.CancelFunc
+	if n, err := strconv.ParseInt(msg.Header["Timeout"], 10, 64); err == nil && n > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(n))
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// pass the caller's metadata on to the handler
+	ctx = service_wrapper.NewContext(ctx, newMetadata(msg.Header))
+
+	if err := s.router.ServeRequest(ctx, request, response); err != nil {
+		log.Errorf("rpc: unable to write response for %s: %v", request.endpoint, err)
+	}
+
+	return request.stream
+}
+
+func (s *rpcServer) Options() Options {
+	s.RLock()
+	opts := s.opts
+	s.RUnlock()
+	return opts
+}
+
+func (s *rpcServer) Init(opts ...Option) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+
+	s.router.setWrappers(s.opts.HdlrWrappers, s.opts.StreamWrappers)
+	return nil
+}
+
+func (s *rpcServer) NewHandler(h interface{}, opts ...HandlerOption) Handler {
+	return newRpcHandler(h, opts...)
+}
+
+func (s *rpcServer) Handle(h Handler) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.router.Handle(h); err != nil {
+		return err
+	}
+
+	s.handlers[h.Name()] = h
+
+	return nil
+}
+
+func (s *rpcServer) NewSubscriber(topic string, sb interface{}, opts ...SubscriberOption) Subscriber {
+	return newSubscriber(topic, sb, opts...)
+}
+
+func (s *rpcServer) Subscribe(sb Subscriber) error {
+	sub, ok := sb.(*subscriber)
+	if !ok {
+		return fmt.Errorf("invalid subscriber: expected *subscriber")
+	}
+	if len(sub.handlers) == 0 {
+		return fmt.Errorf("invalid subscriber: no handler functions")
+	}
+
+	if err := validateSubscriber(sb); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.subscribers[sb]; ok {
+		return fmt.Errorf("subscriber %v already exists", sb)
+	}
+	s.subscribers[sb] = nil
+
+	return nil
+}
+
+// subscribe connects the broker and subscribes every registered subscriber,
+// the default rabbitmq broker is only created once there are subscribers
+func (s *rpcServer) subscribe() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.subscribers) == 0 {
+		return nil
+	}
+
+	if s.opts.Broker == nil {
+		s.opts.Broker = rabbitmq.NewBroker()
+	}
+	config := s.opts
+
+	if err := config.Broker.Connect(); err != nil {
+		return err
+	}
+
+	for sb := range s.subscribers {
+		var opts []broker.SubscribeOption
+		if queue := sb.Options().Queue; len(queue) > 0 {
+			opts = append(opts, broker.Queue(queue))
+		}
+		if ctx := sb.Options().Context; ctx != nil {
+			opts = append(opts, broker.SubscribeContext(ctx))
+		}
+		if !sb.Options().AutoAck {
+			opts = append(opts, broker.DisableAutoAck())
+		}
+
+		sub, err := config.Broker.Subscribe(sb.Topic(), s.createSubHandler(sb.(*subscriber), config), opts...)
+		if err != nil {
+			return err
+		}
+		log.Infof("Subscribing %s to topic: %s", sb.Topic(), sub.Topic())
+		s.subscribers[sb] = []broker.Subscriber{sub}
+	}
+
+	return nil
+}
+
+// unsubscribe drops the broker subscriptions and disconnects the broker
+func (s *rpcServer) unsubscribe() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.subscribers) == 0 || s.opts.Broker == nil {
+		return nil
+	}
+	config := s.opts
+
+	for sb, subs := range s.subscribers {
+		for _, sub := range subs {
+			log.Infof("Unsubscribing %s from topic: %s", sb.Topic(), sub.Topic())
+			sub.Unsubscribe()
+		}
+		s.subscribers[sb] = nil
+	}
+
+	return config.Broker.Disconnect()
+}
+
+// service builds the registry entry advertised for this server
+func (s *rpcServer) service() (*registry.Service, error) {
+	config := s.Options()
+
+	// check the advertise address first
+	// if it exists then use it, otherwise
+	// use the address
+	advt := config.Address
+	if len(config.Advertise) > 0 {
+		advt = config.Advertise
+	}
+
+	host, pt, err := net.SplitHostPort(advt)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := maddr.Extract(host)
+	if err != nil {
+		return nil, err
+	}
+
+	port, _ := strconv.Atoi(pt)
+
+	// make copy of metadata
+	md := make(map[string]string)
+	for k, v := range config.Metadata {
+		md[k] = v
+	}
+
+	node := &registry.Node{
+		Id:       config.Name + "-" + config.Id,
+		Address:  addr,
+		Port:     port,
+		Metadata: md,
+	}
+
+	node.Metadata["transport"] = config.Transport.String()
+	node.Metadata["server"] = s.String()
+	node.Metadata["protocol"] = "mucp"
+	// the encodings requests may be compressed with
+	node.Metadata[compress.MetadataKey] = compress.Encodings()
+	if config.Registry != nil {
+		node.Metadata["registry"] = config.Registry.String()
+	}
+
+	s.RLock()
+	// Maps are ordered randomly, sort the keys for consistency
+	var handlerList []string
+	for n, e := range s.handlers {
+		// Only advertise non internal handlers
+		if !e.Options().Internal {
+			handlerList = append(handlerList, n)
+		}
+	}
+	sort.Strings(handlerList)
+
+	var subscriberList []Subscriber
+	for e := range s.subscribers {
+		// Only advertise non internal subscribers
+		if !e.Options().Internal {
+			subscriberList = append(subscriberList, e)
+		}
+	}
+	sort.Slice(subscriberList, func(i, j int) bool {
+		return subscriberList[i].Topic() > subscriberList[j].Topic()
+	})
+
+	var endpoints []*registry.Endpoint
+	for _, n := range handlerList {
+		endpoints = append(endpoints, s.handlers[n].Endpoints()...)
+	}
+	for _, e := range subscriberList {
+		endpoints = append(endpoints, e.Endpoints()...)
+	}
+	s.RUnlock()
+
+	return &registry.Service{
+		Name:      config.Name,
+		Version:   config.Version,
+		Metadata:  map[string]string{},
+		Nodes:     []*registry.Node{node},
+		Endpoints: endpoints,
+	}, nil
+}
+
+func (s *rpcServer) Register() error {
+	config := s.Options()
+	if config.Registry == nil {
+		return nil
+	}
+
+	service, err := s.service()
+	if err != nil {
+		return err
+	}
+
+	s.RLock()
+	registered := s.registered
+	s.RUnlock()
+
+	if !registered {
+		log.Infof("Registry [%s] Registering node: %s", config.Registry.String(), service.Nodes[0].Id)
+	}
+
+	if err := config.Registry.Register(service, registry.RegisterTTL(config.RegisterTTL)); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.registered = true
+	s.Unlock()
+
+	return nil
+}
+
+func (s *rpcServer) Deregister() error {
+	config := s.Options()
+	if config.Registry == nil {
+		return nil
+	}
+
+	service, err := s.service()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Registry [%s] Deregistering node: %s", config.Registry.String(), service.Nodes[0].Id)
+	if err := config.Registry.Deregister(service); err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.registered = false
+	s.Unlock()
+
+	return nil
+}
+
+func (s *rpcServer) Start() error {
+	s.RLock()
+	if s.started {
+		s.RUnlock()
+		return nil
+	}
+	s.RUnlock()
+
+	config := s.Options()
+
+	// start listening on the transport
+	ts, err := config.Transport.Listen(config.Address)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Transport [%s] Listening on %s", config.Transport.String(), ts.Addr())
+
+	// swap address
+	s.Lock()
+	addr := s.opts.Address
+	s.opts.Address = ts.Addr()
+	s.Unlock()
+
+	// subscribe before registering so publishers find live subscribers
+	if err := s.subscribe(); err != nil {
+		ts.Close()
+		s.Lock()
+		s.opts.Address = addr
+		s.Unlock()
+		return err
+	}
+
+	// a failed registration is retried on the next interval
+	if err := s.Register(); err != nil {
+		log.Errorf("Server %s-%s register error: %s", config.Name, config.Id, err)
+	}
+
+	exit := make(chan bool)
+
+	go func() {
+		for {
+			// listen for connections
+			err := ts.Accept(s.ServeConn)
+
+			// check if we're supposed to exit
+			select {
+			case <-exit:
+				return
+			default:
+			}
+
+			// check the error and backoff
+			if err != nil {
+				log.Errorf("Accept error: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			// no error just exit
+			return
+		}
+	}()
+
+	go func() {
+		t := new(time.Ticker)
+
+		// only process if it exists
+		if config.RegisterInterval > time.Duration(0) {
+			// new ticker
+			t = time.NewTicker(config.RegisterInterval)
+		}
+
+		// return error chan
+		var ch chan error
+
+	Loop:
+		for {
+			select {
+			// register self on interval
+			case <-t.C:
+				if err := s.Register(); err != nil {
+					log.Errorf("Server %s-%s register error: %s", config.Name, config.Id, err)
+				}
+			// wait for exit
+			case ch = <-s.exit:
+				t.Stop()
+				close(exit)
+				break Loop
+			}
+		}
+
+		// deregister self
+		if err := s.Deregister(); err != nil {
+			log.Errorf("Server %s-%s deregister error: %s", config.Name, config.Id, err)
+		}
+
+		// stop consuming messages
+		if err := s.unsubscribe(); err != nil {
+			log.Errorf("Server %s-%s unsubscribe error: %s", config.Name, config.Id, err)
+		}
+
+		// close transport listener, no more connections are accepted
+		err := ts.Close()
+
+		// wait for requests to finish
+		s.Lock()
+		s.draining = true
+		s.Unlock()
+		s.wg.Wait()
+
+		// swap back address
+		s.Lock()
+		s.opts.Address = addr
+		s.draining = false
+		s.Unlock()
+
+		ch <- err
+	}()
+
+	// mark the server as started
+	s.Lock()
+	s.started = true
+	s.Unlock()
+
+	return nil
+}
+
+func (s *rpcServer) Stop() error {
+	s.RLock()
+	if !s.started {
+		s.RUnlock()
+		return nil
+	}
+	s.RUnlock()
+
+	ch := make(chan error)
+	s.exit <- ch
+
+	err := <-ch
+	s.Lock()
+	s.started = false
+	s.Unlock()
+
+	return err
+}
+
+func (s *rpcServer) String() string {
+	return "mucp"
+}
@@ -0,0 +1,675 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"common/client"
+	"common/codec"
+	"common/codec/compress"
+	merrors "common/errors"
+	"common/registry"
+	"common/registry/memory"
+	service_wrapper "common/service-wrapper"
+	"common/transport"
+	"common/transport/pool"
+	"common/transport/tcp"
+	"common/util/app/errcode"
+)
+
+type TestRequest struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type TestResponse struct {
+	Message string `json:"message"`
+}
+
+type Test struct{}
+
+func (t *Test) Hello(ctx context.Context, req *TestRequest, rsp *TestResponse) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no deadline set")
+	}
+	rsp.Message = "Hello " + req.Name
+	return nil
+}
+
+func (t *Test) Meta(ctx context.Context, req *TestRequest, rsp *TestResponse) error {
+	md, _ := service_wrapper.FromContext(ctx)
+	if _, ok := md["Micro-Endpoint"]; ok {
+		return errors.New("transport header leaked into metadata")
+	}
+	rsp.Message, _ = md.Get("Foo")
+	return nil
+}
+
+func (t *Test) Sleep(ctx context.Context, req *TestRequest, rsp *TestResponse) error {
+	time.Sleep(time.Duration(req.Count) * time.Millisecond)
+	rsp.Message = req.Name
+	return nil
+}
+
+func (t *Test) Fail(ctx context.Context, req *TestRequest, rsp *TestResponse) error {
+	return errors.New("failed " + req.Name)
+}
+
+func (t *Test) Invalid(ctx context.Context, req *TestRequest, rsp *TestResponse) error {
+	return errcode.ErrInvalidParams.WithDetail("invalid " + req.Name)
+}
+
+func (t *Test) Repeat(ctx context.Context, stream Stream) error {
+	var req TestRequest
+	if err := stream.Recv(&req); err != nil {
+		return err
+	}
+	for i := 0; i < req.Count; i++ {
+		if err := stream.Send(&TestResponse{Message: req.Name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTestServer(t *testing.T, tr transport.Transport) Server {
+	srv := NewServer(
+		Name("test.service"),
+		Address("127.0.0.1:0"),
+		Transport(tr),
+	)
+
+	if err := srv.Handle(srv.NewHandler(&Test{})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return srv
+}
+
+func TestRpcServerCall(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+	srv := newTestServer(t, tr)
+	defer srv.Stop()
+
+	c := client.NewClient(client.Transport(tr))
+	addr := client.WithAddress(srv.Options().Address)
+
+	var rsp TestResponse
+	req := c.NewRequest("test.service", "Test.Hello", &TestRequest{Name: "John"})
+	if err := c.Call(context.Background(), req, &rsp, addr); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Message != "Hello John" {
+		t.Fatalf("unexpected response %q", rsp.Message)
+	}
+
+	// the status of the errors is passed to the retry func
+	var codes []int
+	retry := client.WithRetry(func(ctx context.Context, req client.Request, code int) (bool, error) {
+		codes = append(codes, code)
+		return client.RetryOnError(ctx, req, code)
+	})
+
+	for _, tc := range []struct {
+		endpoint string
+		code     int32
+		detail   string
+		retries  int
+	}{
+		{"Test.Fail", 500, "failed John", 2},
+		{"Test.Invalid", 400, "invalid John", 1},
+		{"Test.Missing", 404, "unknown service Test.Missing", 1},
+	} {
+		codes = nil
+		req = c.NewRequest("test.service", tc.endpoint, &TestRequest{Name: "John"})
+		err := c.Call(context.Background(), req, &rsp, addr, retry, client.WithRetries(1))
+		e, ok := err.(*merrors.Error)
+		if !ok || e.Code != tc.code || e.Detail != tc.detail {
+			t.Fatalf("unexpected error from %s: %v", tc.endpoint, err)
+		}
+		if len(codes) != tc.retries || codes[0] != int(tc.code) {
+			t.Fatalf("%s retried with codes %v", tc.endpoint, codes)
+		}
+	}
+}
+
+func TestRpcServerStop(t *testing.T) {
+	tr := tcp.NewTransport()
+	srv := newTestServer(t, tr)
+
+	address := srv.Options().Address
+	c := client.NewClient(client.Transport(tr))
+	addr := client.WithAddress(address)
+
+	done := make(chan error)
+	go func() {
+		var rsp TestResponse
+		req := c.NewRequest("test.service", "Test.Sleep", &TestRequest{Name: "John", Count: 100})
+		done <- c.Call(context.Background(), req, &rsp, addr)
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	// the listener is closed at once, the call in flight is waited for
+	stopped := make(chan error)
+	go func() {
+		stopped <- srv.Stop()
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+	if _, err := tr.Dial(address); err == nil {
+		t.Fatal("expected the listener closed while draining")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("the call in flight failed: %v", err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
+
+func TestRpcServerStream(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+	srv := newTestServer(t, tr)
+	defer srv.Stop()
+
+	c := client.NewClient(client.Transport(tr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	req := c.NewRequest("test.service", "Test.Repeat", &TestRequest{Name: "John", Count: 3}, client.StreamingRequest())
+	stream, err := c.Stream(ctx, req, client.WithAddress(srv.Options().Address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for i := 0; i < 3; i++ {
+		var rsp TestResponse
+		if err := stream.Recv(&rsp); err != nil {
+			t.Fatal(err)
+		}
+		if rsp.Message != "John" {
+			t.Fatalf("unexpected response %q", rsp.Message)
+		}
+	}
+
+	var rsp TestResponse
+	if err := stream.Recv(&rsp); err != io.EOF {
+		t.Fatalf("expected io.EOF at the end of the stream, got %v", err)
+	}
+}
+
+// errorCodec reads a header carrying an error
+type errorCodec struct {
+	codec.Codec
+	err string
+}
+
+func (c *errorCodec) ReadHeader(m *codec.Message, mt codec.MessageType) error {
+	m.Error = c.err
+	return nil
+}
+
+func (c *errorCodec) ReadBody(interface{}) error {
+	return nil
+}
+
+func TestRpcStreamRecvError(t *testing.T) {
+	err := merrors.BadRequest("test.client", "bad request")
+	stream := &rpcStream{codec: &errorCodec{err: err.Error()}}
+
+	// the errors sent by the client keep their id and code
+	rerr := stream.Recv(new(TestRequest))
+	if e, ok := rerr.(*merrors.Error); !ok || e.Code != 400 || e.Id != "test.client" {
+		t.Fatalf("expected a typed error, got %v", rerr)
+	}
+
+	stream = &rpcStream{codec: &errorCodec{err: lastStreamResponseError}}
+	if err := stream.Recv(new(TestRequest)); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestRpcHandlerEndpoints(t *testing.T) {
+	h := newRpcHandler(&Test{})
+
+	if h.Name() != "Test" {
+		t.Fatalf("unexpected handler name %s", h.Name())
+	}
+
+	eps := map[string]bool{}
+	for _, ep := range h.Endpoints() {
+		eps[ep.Name] = ep.Metadata["stream"] == "true"
+	}
+
+	for name, stream := range map[string]bool{"Test.Hello": false, "Test.Fail": false, "Test.Invalid": false, "Test.Repeat": true} {
+		s, ok := eps[name]
+		if !ok {
+			t.Fatalf("missing endpoint %s", name)
+		}
+		if s != stream {
+			t.Fatalf("endpoint %s stream %v, want %v", name, s, stream)
+		}
+	}
+}
+
+func TestRpcServerWrappers(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+
+	var calls []string
+	hdlrWrapper := func(fn HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req Request, rsp interface{}) error {
+			calls = append(calls, "handler:"+req.Endpoint())
+			return fn(ctx, req, rsp)
+		}
+	}
+	streamWrapper := func(st Stream) Stream {
+		calls = append(calls, "stream:"+st.Request().Endpoint())
+		return st
+	}
+
+	srv := NewServer(
+		Name("test.service"),
+		Address("127.0.0.1:0"),
+		Transport(tr),
+		WrapHandler(hdlrWrapper),
+		WrapStream(streamWrapper),
+	)
+	if err := srv.Handle(srv.NewHandler(&Test{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	c := client.NewClient(client.Transport(tr))
+	addr := client.WithAddress(srv.Options().Address)
+
+	ctx := service_wrapper.NewContext(context.Background(), service_wrapper.MetaData{"Foo": "bar"})
+
+	var rsp TestResponse
+	req := c.NewRequest("test.service", "Test.Meta", &TestRequest{})
+	if err := c.Call(ctx, req, &rsp, addr); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Message != "bar" {
+		t.Fatalf("expected metadata Foo=bar in handler, got %q", rsp.Message)
+	}
+
+	req = c.NewRequest("test.service", "Test.Repeat", &TestRequest{Name: "John", Count: 1}, client.StreamingRequest())
+	stream, err := c.Stream(context.Background(), req, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Recv(&rsp); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Recv(&rsp); err != io.EOF {
+		t.Fatalf("expected io.EOF at the end of the stream, got %v", err)
+	}
+	stream.Close()
+
+	expected := []string{"handler:Test.Meta", "stream:Test.Repeat", "handler:Test.Repeat"}
+	if len(calls) != len(expected) {
+		t.Fatalf("unexpected wrapper calls %v", calls)
+	}
+	for i, call := range expected {
+		if calls[i] != call {
+			t.Fatalf("unexpected wrapper calls %v, want %v", calls, expected)
+		}
+	}
+}
+
+func TestRpcServerMultiplex(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+	srv := newTestServer(t, tr)
+	defer srv.Stop()
+
+	p := pool.NewPool(pool.Transport(tr), pool.Multiplex(0))
+	defer p.Close()
+
+	c := client.NewClient(client.Transport(tr), client.Pool(p))
+	addr := client.WithAddress(srv.Options().Address)
+
+	start := time.Now()
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			name := fmt.Sprint(i)
+			var rsp TestResponse
+			req := c.NewRequest("test.service", "Test.Sleep", &TestRequest{Name: name, Count: 100})
+			if err := c.Call(context.Background(), req, &rsp, addr); err != nil {
+				errs <- err
+				return
+			}
+			if rsp.Message != name {
+				errs <- fmt.Errorf("call %s got response %q", name, rsp.Message)
+				return
+			}
+			errs <- nil
+		}(i)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the calls shared one connection and ran concurrently
+	if d := time.Since(start); d > time.Millisecond*800 {
+		t.Fatalf("multiplexed calls took %v", d)
+	}
+	if s := p.Stats()[srv.Options().Address]; s.Dials != 1 {
+		t.Fatalf("unexpected pool stats %+v", s)
+	}
+}
+
+func TestRpcServerJsonRpc(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+	srv := newTestServer(t, tr)
+	defer srv.Stop()
+
+	c := client.NewClient(client.Transport(tr), client.ContentType("application/json-rpc"))
+	addr := client.WithAddress(srv.Options().Address)
+
+	var rsp TestResponse
+	req := c.NewRequest("test.service", "Test.Hello", &TestRequest{Name: "John"})
+	if err := c.Call(context.Background(), req, &rsp, addr); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Message != "Hello John" {
+		t.Fatalf("unexpected response %q", rsp.Message)
+	}
+
+	req = c.NewRequest("test.service", "Test.Fail", &TestRequest{Name: "John"})
+	if err := c.Call(context.Background(), req, &rsp, addr); err == nil || merrors.FromError(err).Detail != "failed John" {
+		t.Fatalf("expected error from Test.Fail, got %v", err)
+	}
+
+	// a peer sending a bare batch, the notification gets no response
+	sock, err := tr.Dial(srv.Options().Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+
+	if err := sock.Send(&transport.Message{
+		Header: map[string]string{"Content-Type": "application/json-rpc"},
+		Body: []byte(`[
+			{"jsonrpc": "2.0", "method": "Test.Sleep", "params": {"name": "a"}, "id": 1},
+			{"jsonrpc": "2.0", "method": "Test.Sleep", "params": [{"name": "b"}]},
+			{"jsonrpc": "2.0", "method": "Test.Fail", "params": {"name": "c"}, "id": "c"}
+		]`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg transport.Message
+	if err := sock.Recv(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var rsps []struct {
+		Id     interface{}   `json:"id"`
+		Result *TestResponse `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(msg.Body, &rsps); err != nil {
+		t.Fatalf("unexpected batch response %s: %v", msg.Body, err)
+	}
+	if len(rsps) != 2 {
+		t.Fatalf("unexpected batch response %s", msg.Body)
+	}
+	if rsps[0].Id != float64(1) || rsps[0].Result == nil || rsps[0].Result.Message != "a" {
+		t.Fatalf("unexpected first response %s", msg.Body)
+	}
+	if rsps[1].Id != "c" || rsps[1].Error == nil || rsps[1].Error.Message != "failed c" {
+		t.Fatalf("unexpected second response %s", msg.Body)
+	}
+
+	// the unknown methods are answered with the id of the request,
+	// notifications get an empty body
+	for body, id := range map[string]interface{}{
+		`{"jsonrpc": "2.0", "method": "Test.Missing", "params": {"name": "a"}}`:            nil,
+		`{"jsonrpc": "2.0", "method": "Test.Missing", "params": {"name": "b"}, "id": "b"}`: "b",
+	} {
+		if err := sock.Send(&transport.Message{
+			Header: map[string]string{"Content-Type": "application/json-rpc"},
+			Body:   []byte(body),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		var msg transport.Message
+		if err := sock.Recv(&msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if id == nil {
+			if len(msg.Body) > 0 {
+				t.Fatalf("unexpected response %s to a notification", msg.Body)
+			}
+			continue
+		}
+
+		var rsp struct {
+			Id    interface{} `json:"id"`
+			Error *struct {
+				Code int `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(msg.Body, &rsp); err != nil || rsp.Error == nil || rsp.Error.Code != -32601 || rsp.Id != id {
+			t.Fatalf("unexpected response %s to %s", msg.Body, body)
+		}
+	}
+
+	// empty and malformed batches get a single error object
+	for body, code := range map[string]int{`[]`: -32600, `[{"jsonrpc": "2.0",`: -32700} {
+		if err := sock.Send(&transport.Message{
+			Header: map[string]string{"Content-Type": "application/json-rpc"},
+			Body:   []byte(body),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		var msg transport.Message
+		if err := sock.Recv(&msg); err != nil {
+			t.Fatal(err)
+		}
+
+		var rsp struct {
+			Id    interface{} `json:"id"`
+			Error *struct {
+				Code int `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(msg.Body, &rsp); err != nil || rsp.Error == nil || rsp.Error.Code != code || rsp.Id != nil {
+			t.Fatalf("unexpected response %s to batch %s", msg.Body, body)
+		}
+	}
+}
+
+func TestRpcServerCompression(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+	srv := newTestServer(t, tr)
+	defer srv.Stop()
+
+	c := client.NewClient(client.Transport(tr))
+	addr := client.WithAddress(srv.Options().Address)
+
+	name := strings.Repeat("John", 1024)
+
+	for _, enc := range []string{"gzip", "zstd", "snappy"} {
+		var rsp TestResponse
+		req := c.NewRequest("test.service", "Test.Hello", &TestRequest{Name: name})
+		if err := c.Call(context.Background(), req, &rsp, addr, client.WithCompression(enc)); err != nil {
+			t.Fatal(err)
+		}
+		if rsp.Message != "Hello "+name {
+			t.Fatalf("%s: unexpected response of %d bytes", enc, len(rsp.Message))
+		}
+
+		req = c.NewRequest("test.service", "Test.Repeat", &TestRequest{Name: name, Count: 2}, client.StreamingRequest())
+		stream, err := c.Stream(context.Background(), req, addr, client.WithCompression(enc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := stream.Recv(&rsp); err != nil {
+				t.Fatal(err)
+			}
+			if rsp.Message != name {
+				t.Fatalf("%s: unexpected stream response of %d bytes", enc, len(rsp.Message))
+			}
+		}
+		if err := stream.Recv(&rsp); err != io.EOF {
+			t.Fatalf("%s: expected io.EOF at the end of the stream, got %v", enc, err)
+		}
+		stream.Close()
+	}
+
+	// the response is compressed with the encoding the peer accepts
+	sock, err := tr.Dial(srv.Options().Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+
+	body, _ := json.Marshal(&TestRequest{Name: name})
+	if body, _, err = compress.Compress("snappy", "application/json", body); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sock.Send(&transport.Message{
+		Header: map[string]string{
+			"Content-Type":     "application/json",
+			"Content-Encoding": "snappy",
+			"Accept-Encoding":  "br, gzip",
+			"Micro-Endpoint":   "Test.Sleep",
+		},
+		Body: body,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg transport.Message
+	if err := sock.Recv(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header["Content-Encoding"] != "gzip" {
+		t.Fatalf("unexpected response headers %v", msg.Header)
+	}
+	if body, err = compress.Decompress("gzip", msg.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	var rsp TestResponse
+	if err := json.Unmarshal(body, &rsp); err != nil || rsp.Message != name {
+		t.Fatalf("unexpected response %s: %v", body, err)
+	}
+}
+
+func TestRpcServerRegistry(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+	reg := memory.NewRegistry()
+
+	srv := NewServer(
+		Name("test.service"),
+		Address("127.0.0.1:0"),
+		Transport(tr),
+		Registry(reg),
+	)
+	if err := srv.Handle(srv.NewHandler(&Test{}, StreamMessages("Test.Repeat", &TestRequest{}, &TestResponse{}))); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// the node is looked up in the registry
+	c := client.NewClient(client.Transport(tr), client.Registry(reg))
+
+	var rsp TestResponse
+	req := c.NewRequest("test.service", "Test.Hello", &TestRequest{Name: "John"})
+	if err := c.Call(context.Background(), req, &rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Message != "Hello John" {
+		t.Fatalf("unexpected response %q", rsp.Message)
+	}
+	// the schemas are registered with the endpoints
+	ep, err := registry.GetEndpoint(reg, "test.service", "", "Test.Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.Request == nil || len(ep.Request.Values) != 2 || ep.Request.Values[1].Name != "count" || ep.Request.Values[1].Type != "int" {
+		t.Fatalf("unexpected request schema %+v", ep.Request)
+	}
+	if ep.Response == nil || ep.Response.Type != "TestResponse" || ep.Response.Values[0].Name != "message" {
+		t.Fatalf("unexpected response schema %+v", ep.Response)
+	}
+	if ep.Metadata[registry.SchemaKey] != registry.Fingerprint(ep) {
+		t.Fatalf("unexpected schema version %s", ep.Metadata[registry.SchemaKey])
+	}
+	// stream schemas are set with the handler options
+	ep, err = registry.GetEndpoint(reg, "test.service", "", "Test.Repeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.Request == nil || ep.Request.Type != "TestRequest" || ep.Response == nil || ep.Response.Type != "TestResponse" {
+		t.Fatalf("unexpected stream schemas %+v %+v", ep.Request, ep.Response)
+	}
+	if ep.Metadata["stream"] != "true" || ep.Metadata[registry.SchemaKey] != registry.Fingerprint(ep) {
+		t.Fatalf("unexpected stream metadata %v", ep.Metadata)
+	}
+	if _, err := registry.GetEndpoint(reg, "test.service", "", "Test.Missing"); err != registry.ErrEndpointNotFound {
+		t.Fatalf("expected %v, got %v", registry.ErrEndpointNotFound, err)
+	}
+}
+
+type testTree struct {
+	Name     string                 `json:"name"`
+	Children []*testTree            `json:"children,omitempty"`
+	Labels   map[string]TestRequest `json:"labels"`
+	Skipped  string                 `json:"-"`
+	private  int
+}
+
+func TestExtractValue(t *testing.T) {
+	v := extractValue(reflect.TypeOf(&testTree{}))
+	if v.Type != "testTree" || len(v.Values) != 3 {
+		t.Fatalf("unexpected value %+v", v)
+	}
+	// the recursion stops at the repeated type
+	if c := v.Values[1]; c.Name != "children" || c.Type != "[]testTree" || len(c.Values) != 0 {
+		t.Fatalf("unexpected children %+v", c)
+	}
+	if l := v.Values[2]; l.Type != "map[string]TestRequest" || len(l.Values) != 2 {
+		t.Fatalf("unexpected labels %+v", l)
+	}
+}
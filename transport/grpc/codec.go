@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"errors"
+
+	"common/transport"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// codecName is the content subtype the transport messages are sent with,
+// the stream is served as application/grpc+micro-transport.
+const codecName = "micro-transport"
+
+func init() {
+	encoding.RegisterCodec(messageCodec{})
+}
+
+// messageCodec encodes a transport.Message in the protobuf wire format of
+//
+//	message Message {
+//		map<string, string> header = 1;
+//		bytes body = 2;
+//	}
+type messageCodec struct{}
+
+func (messageCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*transport.Message)
+	if !ok {
+		return nil, errors.New("grpc transport: invalid message type")
+	}
+
+	var b []byte
+	for k, v := range m.Header {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, v)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+
+	if len(m.Body) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.Body)
+	}
+
+	return b, nil
+}
+
+func (messageCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*transport.Message)
+	if !ok {
+		return errors.New("grpc transport: invalid message type")
+	}
+
+	m.Header = make(map[string]string)
+	m.Body = nil
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		val, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch num {
+		case 1:
+			k, v, err := unmarshalEntry(val)
+			if err != nil {
+				return err
+			}
+			m.Header[k] = v
+		case 2:
+			m.Body = append([]byte(nil), val...)
+		}
+	}
+
+	return nil
+}
+
+func (messageCodec) Name() string {
+	return codecName
+}
+
+// unmarshalEntry decodes a single header map entry
+func unmarshalEntry(data []byte) (string, string, error) {
+	var k, v string
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		val, n := protowire.ConsumeString(data)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch num {
+		case 1:
+			k = val
+		case 2:
+			v = val
+		}
+	}
+
+	return k, v, nil
+}
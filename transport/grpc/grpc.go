@@ -0,0 +1,266 @@
+// Package grpc provides a grpc transport, each Dial opens a bidirectional
+// stream over a connection shared by all clients of the same address.
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"common/transport"
+	maddr "common/util/addr"
+	mnet "common/util/net"
+	mls "common/util/tls"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+)
+
+type grpcTransport struct {
+	opts transport.Options
+
+	sync.Mutex
+	// connections shared by the streams of an address
+	conns map[string]*grpc.ClientConn
+	// local addresses of the connections
+	locals map[string]string
+}
+
+type grpcTransportListener struct {
+	listener net.Listener
+	secure   bool
+	tls      *tls.Config
+
+	sync.Mutex
+	srv *grpc.Server
+}
+
+func getTLSConfig(addr string) (*tls.Config, error) {
+	hosts := []string{addr}
+
+	// check if its a valid host:port
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if len(host) == 0 {
+			hosts = maddr.IPs()
+		} else {
+			hosts = []string{host}
+		}
+	}
+
+	// generate a certificate
+	cert, err := mls.Certificate(hosts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+func (t *grpcTransportListener) Addr() string {
+	return t.listener.Addr().String()
+}
+
+func (t *grpcTransportListener) Close() error {
+	t.Lock()
+	srv := t.srv
+	t.Unlock()
+
+	// stopping the server closes the listener and every open stream
+	if srv != nil {
+		srv.Stop()
+		return nil
+	}
+
+	return t.listener.Close()
+}
+
+func (t *grpcTransportListener) Accept(fn func(transport.Socket)) error {
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(connHandler{}),
+	}
+
+	// setup tls if specified
+	if t.secure || t.tls != nil {
+		config := t.tls
+		if config == nil {
+			var err error
+			config, err = getTLSConfig(t.listener.Addr().String())
+			if err != nil {
+				return err
+			}
+		}
+
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+
+	// new service
+	srv := grpc.NewServer(opts...)
+
+	// register service
+	srv.RegisterService(&serviceDesc, &microTransport{addr: t.listener.Addr().String(), fn: fn})
+
+	t.Lock()
+	t.srv = srv
+	t.Unlock()
+
+	// start serving
+	return srv.Serve(t.listener)
+}
+
+// getConn returns the shared connection to addr, dialing it if needed
+func (t *grpcTransport) getConn(addr string, dopts transport.DialOptions) (*grpc.ClientConn, error) {
+	t.Lock()
+	conn, ok := t.conns[addr]
+	t.Unlock()
+
+	if ok && conn.GetState() != connectivity.Shutdown {
+		return conn, nil
+	}
+
+	options := []grpc.DialOption{
+		grpc.WithBlock(),
+		// the local address of the connection is kept for the sockets
+		grpc.WithContextDialer(func(ctx context.Context, a string) (net.Conn, error) {
+			c, err := (&net.Dialer{}).DialContext(ctx, "tcp", a)
+			if err != nil {
+				return nil, err
+			}
+			t.Lock()
+			t.locals[addr] = c.LocalAddr().String()
+			t.Unlock()
+			return c, nil
+		}),
+	}
+
+	if t.opts.Secure || t.opts.TLSConfig != nil {
+		config := t.opts.TLSConfig
+		if config == nil {
+			config = &tls.Config{
+				InsecureSkipVerify: true,
+			}
+		}
+		options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	} else {
+		options = append(options, grpc.WithInsecure())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dopts.Timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	// another dial won the race, use its connection
+	if c, ok := t.conns[addr]; ok && c.GetState() != connectivity.Shutdown {
+		conn.Close()
+		return c, nil
+	}
+
+	t.conns[addr] = conn
+
+	return conn, nil
+}
+
+// dropConn forgets a connection shut down so the next Dial reconnects
+func (t *grpcTransport) dropConn(addr string, conn *grpc.ClientConn) {
+	t.Lock()
+	if c, ok := t.conns[addr]; ok && c == conn {
+		delete(t.conns, addr)
+		delete(t.locals, addr)
+	}
+	t.Unlock()
+}
+
+func (t *grpcTransport) Dial(addr string, opts ...transport.DialOption) (transport.Client, error) {
+	dopts := transport.DialOptions{
+		Timeout: transport.DefaultDialTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(&dopts)
+	}
+
+	conn, err := t.getConn(addr, dopts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the stream lives until the client is closed
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := conn.NewStream(ctx, &streamDesc, "/"+serviceName+"/"+streamName, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		cancel()
+		// the connection is shared by other streams, grpc reconnects
+		// it from transient failures itself
+		if conn.GetState() == connectivity.Shutdown {
+			t.dropConn(addr, conn)
+		}
+		return nil, err
+	}
+
+	t.Lock()
+	local := t.locals[addr]
+	t.Unlock()
+
+	return &grpcTransportClient{
+		stream: stream,
+		cancel: cancel,
+		local:  local,
+		remote: addr,
+	}, nil
+}
+
+func (t *grpcTransport) Listen(addr string, opts ...transport.ListenOption) (transport.Listener, error) {
+	var options transport.ListenOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	ln, err := mnet.Listen(addr, func(addr string) (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcTransportListener{
+		listener: ln,
+		tls:      t.opts.TLSConfig,
+		secure:   t.opts.Secure,
+	}, nil
+}
+
+func (t *grpcTransport) Init(opts ...transport.Option) error {
+	for _, o := range opts {
+		o(&t.opts)
+	}
+	return nil
+}
+
+func (t *grpcTransport) Options() transport.Options {
+	return t.opts
+}
+
+func (t *grpcTransport) String() string {
+	return "grpc"
+}
+
+func NewTransport(opts ...transport.Option) transport.Transport {
+	var options transport.Options
+	for _, o := range opts {
+		o(&options)
+	}
+	return &grpcTransport{
+		opts:   options,
+		conns:  make(map[string]*grpc.ClientConn),
+		locals: make(map[string]string),
+	}
+}
@@ -0,0 +1,162 @@
+package grpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"common/transport"
+)
+
+func expectedPort(t *testing.T, expected string, lsn transport.Listener) {
+	t.Helper()
+	_, port, err := net.SplitHostPort(lsn.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != expected {
+		lsn.Close()
+		t.Fatalf("expected address to be `%s`, got `%s`", expected, port)
+	}
+}
+
+func echo(sock transport.Socket) {
+	defer sock.Close()
+	for {
+		var m transport.Message
+		if err := sock.Recv(&m); err != nil {
+			return
+		}
+		if err := sock.Send(&m); err != nil {
+			return
+		}
+	}
+}
+
+func testTransport(t *testing.T, tr transport.Transport) {
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go l.Accept(echo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			c, err := tr.Dial(l.Addr(), transport.WithStream())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+
+			for j := 0; j < 3; j++ {
+				m := &transport.Message{
+					Header: map[string]string{"Content-Type": "application/json", "Micro-Id": fmt.Sprint(i, j)},
+					Body:   []byte(fmt.Sprintf(`{"message": "%d-%d"}`, i, j)),
+				}
+				if err := c.Send(m); err != nil {
+					t.Error(err)
+					return
+				}
+
+				var rsp transport.Message
+				if err := c.Recv(&rsp); err != nil {
+					t.Error(err)
+					return
+				}
+				if string(rsp.Body) != string(m.Body) || rsp.Header["Micro-Id"] != m.Header["Micro-Id"] {
+					t.Errorf("unexpected echo %v", rsp)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestGRPCTransport(t *testing.T) {
+	tr := NewTransport()
+	testTransport(t, tr)
+
+	// every stream shares one connection
+	if n := len(tr.(*grpcTransport).conns); n != 1 {
+		t.Fatalf("expected 1 shared connection, got %d", n)
+	}
+}
+
+func TestGRPCTransportAddrs(t *testing.T) {
+	tr := NewTransport()
+
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	socks := make(chan transport.Socket, 1)
+	go l.Accept(func(sock transport.Socket) {
+		socks <- sock
+		echo(sock)
+	})
+
+	c, err := tr.Dial(l.Addr(), transport.WithStream())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the server sees the stream once the first message is sent
+	if err := c.Send(&transport.Message{Body: []byte("ping")}); err != nil {
+		t.Fatal(err)
+	}
+	sock := <-socks
+
+	if sock.Local() != l.Addr() || sock.Remote() != c.Local() {
+		t.Fatalf("server socket %s -> %s, client socket %s -> %s", sock.Local(), sock.Remote(), c.Local(), c.Remote())
+	}
+}
+
+func TestGRPCTransportSecure(t *testing.T) {
+	testTransport(t, NewTransport(transport.Secure(true)))
+}
+
+func TestGRPCTransportListenPortRange(t *testing.T) {
+	tr := NewTransport()
+
+	l, err := tr.Listen("127.0.0.1:44454-44458")
+	if err != nil {
+		t.Skipf("port range unavailable: %v", err)
+	}
+	expectedPort(t, "44454", l)
+	l.Close()
+}
+
+func TestMessageCodec(t *testing.T) {
+	c := messageCodec{}
+
+	in := &transport.Message{
+		Header: map[string]string{"Foo": "bar", "Empty": ""},
+		Body:   []byte("hello"),
+	}
+
+	b, err := c.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out transport.Message
+	if err := c.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out.Body) != "hello" || out.Header["Foo"] != "bar" || len(out.Header) != 2 {
+		t.Fatalf("unexpected message %v", out)
+	}
+}
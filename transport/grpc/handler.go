@@ -0,0 +1,93 @@
+package grpc
+
+import (
+	"context"
+
+	"common/transport"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	serviceName = "go.micro.transport.grpc.Transport"
+	streamName  = "Stream"
+)
+
+// streamDesc describes the single bidirectional stream of the transport
+var streamDesc = grpc.StreamDesc{
+	StreamName:    streamName,
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+type localAddrKey struct{}
+
+// connHandler tags the context of each connection with its local
+// address, the streams of the connection inherit it
+type connHandler struct{}
+
+func (connHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	if info.LocalAddr == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, localAddrKey{}, info.LocalAddr.String())
+}
+
+func (connHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+func (connHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (connHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+// transportServer is the interface registered with the grpc server
+type transportServer interface {
+	Stream(grpc.ServerStream) error
+}
+
+// microTransport hands every inbound stream to the listener's accept func
+type microTransport struct {
+	addr string
+	fn   func(transport.Socket)
+}
+
+func (m *microTransport) Stream(ts grpc.ServerStream) error {
+	sock := &grpcTransportSocket{
+		stream: ts,
+		local:  m.addr,
+		exit:   make(chan bool),
+	}
+
+	if p, ok := peer.FromContext(ts.Context()); ok {
+		sock.remote = p.Addr.String()
+	}
+	if local, ok := ts.Context().Value(localAddrKey{}).(string); ok {
+		sock.local = local
+	}
+
+	// the stream lives until the handler returns
+	m.fn(sock)
+
+	return nil
+}
+
+func streamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(transportServer).Stream(stream)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*transportServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    streamName,
+			Handler:       streamHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
@@ -0,0 +1,141 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"common/transport"
+
+	"google.golang.org/grpc"
+)
+
+// grpcTransportClient is the dialing side of a stream, many clients
+// share the same connection to an address.
+type grpcTransportClient struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+
+	local  string
+	remote string
+
+	// grpc streams are not safe for concurrent sends or receives
+	sendMu sync.Mutex
+	recvMu sync.Mutex
+
+	sync.Mutex
+	closed bool
+}
+
+// grpcTransportSocket is the accepted side of a stream
+type grpcTransportSocket struct {
+	stream grpc.ServerStream
+
+	local  string
+	remote string
+
+	sendMu sync.Mutex
+	recvMu sync.Mutex
+
+	once sync.Once
+	exit chan bool
+}
+
+func (g *grpcTransportClient) Local() string {
+	return g.local
+}
+
+func (g *grpcTransportClient) Remote() string {
+	return g.remote
+}
+
+func (g *grpcTransportClient) Recv(m *transport.Message) error {
+	if m == nil {
+		return nil
+	}
+
+	g.recvMu.Lock()
+	defer g.recvMu.Unlock()
+
+	return g.stream.RecvMsg(m)
+}
+
+func (g *grpcTransportClient) Send(m *transport.Message) error {
+	if m == nil {
+		return nil
+	}
+
+	g.sendMu.Lock()
+	defer g.sendMu.Unlock()
+
+	return g.stream.SendMsg(m)
+}
+
+// Close ends the stream, the underlying connection is kept for other streams
+func (g *grpcTransportClient) Close() error {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+
+	g.sendMu.Lock()
+	err := g.stream.CloseSend()
+	g.sendMu.Unlock()
+
+	g.cancel()
+
+	return err
+}
+
+func (g *grpcTransportSocket) Local() string {
+	return g.local
+}
+
+func (g *grpcTransportSocket) Remote() string {
+	return g.remote
+}
+
+func (g *grpcTransportSocket) Recv(m *transport.Message) error {
+	if m == nil {
+		return nil
+	}
+
+	select {
+	case <-g.exit:
+		return errors.New("socket closed")
+	default:
+	}
+
+	g.recvMu.Lock()
+	defer g.recvMu.Unlock()
+
+	return g.stream.RecvMsg(m)
+}
+
+func (g *grpcTransportSocket) Send(m *transport.Message) error {
+	if m == nil {
+		return nil
+	}
+
+	select {
+	case <-g.exit:
+		return errors.New("socket closed")
+	default:
+	}
+
+	g.sendMu.Lock()
+	defer g.sendMu.Unlock()
+
+	return g.stream.SendMsg(m)
+}
+
+// Close marks the socket closed, the stream ends once the accept func returns
+func (g *grpcTransportSocket) Close() error {
+	g.once.Do(func() {
+		close(g.exit)
+	})
+	return nil
+}
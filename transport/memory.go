@@ -28,6 +28,9 @@ type memorySocket struct {
 	timeout time.Duration
 	ctx     context.Context
 	sync.RWMutex
+
+	// closes exit once for both ends without waiting on a blocked Recv
+	once *sync.Once
 }
 
 type memoryClient struct {
@@ -107,14 +110,9 @@ func (ms *memorySocket) Send(m *Message) error {
 }
 
 func (ms *memorySocket) Close() error {
-	ms.Lock()
-	defer ms.Unlock()
-	select {
-	case <-ms.exit:
-		return nil
-	default:
+	ms.once.Do(func() {
 		close(ms.exit)
-	}
+	})
 	return nil
 }
 
@@ -143,6 +141,7 @@ func (m *memoryListener) Accept(fn func(Socket)) error {
 			go fn(&memorySocket{
 				lexit:   c.lexit,
 				exit:    c.exit,
+				once:    c.once,
 				send:    c.recv,
 				recv:    c.send,
 				local:   c.Remote(),
@@ -173,6 +172,7 @@ func (m *memoryTransport) Dial(addr string, opts ...DialOption) (Client, error)
 			send:    make(chan *Message),
 			recv:    make(chan *Message),
 			exit:    make(chan bool),
+			once:    new(sync.Once),
 			lexit:   listener.exit,
 			local:   addr,
 			remote:  addr,
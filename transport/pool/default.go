@@ -1,6 +1,8 @@
 package pool
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -8,37 +10,110 @@ import (
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrPoolClosed is returned by Get once the pool is closed
+	ErrPoolClosed = errors.New("pool closed")
+)
+
 type pool struct {
-	size int
-	ttl  time.Duration
-	tr   transport.Transport
+	opts Options
 
 	sync.Mutex
-	conns map[string][]*poolConn
+	addrs map[string]*addrPool
+
+	closed bool
+	exit   chan bool
+}
+
+// addrPool holds the connections of a single address
+type addrPool struct {
+	idle   []*poolConn
+	active int
+
+	// shared conns in multiplexed mode
+	muxed   []*muxConn
+	dialing int
+
+	waiters  int
+	dials    uint64
+	failures uint64
+
+	// closed and replaced to wake the waiters
+	notify chan bool
 }
 
 type poolConn struct {
 	transport.Client
-	id      string
-	created time.Time
+	id       string
+	addr     string
+	created  time.Time
+	lastUsed time.Time
 }
 
 func newPool(options Options) *pool {
-	return &pool{
-		size:  options.Size,
-		tr:    options.Transport,
-		ttl:   options.TTL,
-		conns: make(map[string][]*poolConn),
+	p := &pool{
+		opts:  options,
+		addrs: make(map[string]*addrPool),
+		exit:  make(chan bool),
+	}
+
+	// idle connections are checked in the background
+	interval := options.HealthCheckInterval
+	if interval <= 0 || options.HealthCheck == nil {
+		interval = options.IdleTimeout
+	}
+	if interval > 0 {
+		go p.run(interval)
+	}
+
+	return p
+}
+
+func (p *pool) addr(addr string) *addrPool {
+	ap, ok := p.addrs[addr]
+	if !ok {
+		ap = &addrPool{notify: make(chan bool)}
+		p.addrs[addr] = ap
+	}
+	return ap
+}
+
+// wake unblocks the callers waiting on the address
+func (ap *addrPool) wake() {
+	if ap.waiters > 0 {
+		close(ap.notify)
+		ap.notify = make(chan bool)
+	}
+}
+
+// expired reports whether an idle connection should be dropped
+func (p *pool) expired(conn *poolConn, now time.Time) bool {
+	if p.opts.TTL > 0 && now.Sub(conn.created) > p.opts.TTL {
+		return true
 	}
+	if p.opts.IdleTimeout > 0 && now.Sub(conn.lastUsed) > p.opts.IdleTimeout {
+		return true
+	}
+	return false
 }
 
 func (p *pool) Close() error {
 	p.Lock()
-	for k, c := range p.conns {
-		for _, conn := range c {
+	if !p.closed {
+		p.closed = true
+		close(p.exit)
+	}
+	for k, ap := range p.addrs {
+		for _, conn := range ap.idle {
+			conn.Client.Close()
+		}
+		for _, conn := range ap.muxed {
 			conn.Client.Close()
 		}
-		delete(p.conns, k)
+		ap.idle = nil
+		ap.muxed = nil
+		ap.wake()
+		delete(p.addrs, k)
 	}
 	p.Unlock()
 	return nil
@@ -57,58 +132,219 @@ func (p *poolConn) Created() time.Time {
 	return p.created
 }
 
-func (p *pool) Get(addr string, opts ...transport.DialOption) (Conn, error) {
+func (p *pool) Get(ctx context.Context, addr string, opts ...transport.DialOption) (Conn, error) {
+	if p.opts.Multiplex {
+		return p.getStream(ctx, addr, opts...)
+	}
+
 	p.Lock()
-	conns := p.conns[addr]
 
-	// while we have conns check age and then return one
-	// otherwise we'll create a new conn
-	for len(conns) > 0 {
-		conn := conns[len(conns)-1]
-		conns = conns[:len(conns)-1]
-		p.conns[addr] = conns
+	for {
+		if p.closed {
+			p.Unlock()
+			return nil, ErrPoolClosed
+		}
 
-		// if conn is old kill it and move on
-		if d := time.Since(conn.Created()); d > p.ttl {
-			conn.Client.Close()
-			continue
+		ap := p.addr(addr)
+		now := time.Now()
+
+		// while we have conns check age and then return one
+		// otherwise we'll create a new conn
+		for len(ap.idle) > 0 {
+			conn := ap.idle[len(ap.idle)-1]
+			ap.idle = ap.idle[:len(ap.idle)-1]
+
+			// if conn is old kill it and move on
+			if p.expired(conn, now) {
+				conn.Client.Close()
+				continue
+			}
+
+			// we got a good conn, lets unlock and return it
+			ap.active++
+			p.Unlock()
+
+			return conn, nil
 		}
 
-		// we got a good conn, lets unlock and return it
+		// dial a new conn if we're below the limit
+		if p.opts.MaxActive <= 0 || ap.active < p.opts.MaxActive {
+			ap.active++
+			ap.dials++
+			p.Unlock()
+
+			return p.dial(ap, addr, opts...)
+		}
+
+		// wait for a conn to be released
+		ap.waiters++
+		notify := ap.notify
 		p.Unlock()
 
-		return conn, nil
-	}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			p.Lock()
+			ap.waiters--
+			p.Unlock()
+			return nil, errors.New("pool exhausted: " + ctx.Err().Error())
+		}
 
-	p.Unlock()
+		p.Lock()
+		ap.waiters--
+	}
+}
 
-	// create new conn
-	c, err := p.tr.Dial(addr, opts...)
+func (p *pool) dial(ap *addrPool, addr string, opts ...transport.DialOption) (Conn, error) {
+	c, err := p.opts.Transport.Dial(addr, opts...)
 	if err != nil {
+		p.Lock()
+		ap.active--
+		ap.failures++
+		ap.wake()
+		p.Unlock()
 		return nil, err
 	}
+
+	now := time.Now()
+
 	return &poolConn{
-		Client:  c,
-		id:      uuid.New().String(),
-		created: time.Now(),
+		Client:   c,
+		id:       uuid.New().String(),
+		addr:     addr,
+		created:  now,
+		lastUsed: now,
 	}, nil
 }
 
 func (p *pool) Release(conn Conn, err error) error {
+	// errors of a single call don't affect the shared conn
+	if s, ok := conn.(*muxStream); ok {
+		return p.releaseStream(s)
+	}
+
+	pc := conn.(*poolConn)
+
+	p.Lock()
+	defer p.Unlock()
+
+	ap, ok := p.addrs[pc.addr]
+	if !ok {
+		// the pool was closed while the conn was in use
+		return pc.Client.Close()
+	}
+
+	ap.active--
+	ap.wake()
+
 	// don't store the conn if it has errored
 	if err != nil {
-		return conn.(*poolConn).Client.Close()
+		ap.failures++
+		return pc.Client.Close()
 	}
 
 	// otherwise put it back for reuse
+	if len(ap.idle) >= p.opts.Size || p.closed {
+		return pc.Client.Close()
+	}
+
+	pc.lastUsed = time.Now()
+	ap.idle = append(ap.idle, pc)
+
+	return nil
+}
+
+func (p *pool) Stats() map[string]Stats {
 	p.Lock()
-	conns := p.conns[conn.Remote()]
-	if len(conns) >= p.size {
-		p.Unlock()
-		return conn.(*poolConn).Client.Close()
+	defer p.Unlock()
+
+	stats := make(map[string]Stats, len(p.addrs))
+	for addr, ap := range p.addrs {
+		idle := len(ap.idle)
+		for _, c := range ap.muxed {
+			c.Lock()
+			if len(c.streams) == 0 {
+				idle++
+			}
+			c.Unlock()
+		}
+
+		stats[addr] = Stats{
+			Active:   ap.active,
+			Idle:     idle,
+			Waiters:  ap.waiters,
+			Dials:    ap.dials,
+			Failures: ap.failures,
+		}
+	}
+	return stats
+}
+
+// run evicts expired idle conns and health checks the rest
+func (p *pool) run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.exit:
+			return
+		case <-t.C:
+			p.check()
+		}
+	}
+}
+
+func (p *pool) check() {
+	now := time.Now()
+	probe := make(map[string][]*poolConn)
+
+	p.Lock()
+	for addr, ap := range p.addrs {
+		var keep []*poolConn
+		for _, conn := range ap.idle {
+			if p.expired(conn, now) {
+				conn.Client.Close()
+				continue
+			}
+			keep = append(keep, conn)
+		}
+
+		// probed conns leave the pool until they pass
+		if p.opts.HealthCheck != nil {
+			probe[addr] = keep
+			keep = nil
+		}
+		ap.idle = keep
 	}
-	p.conns[conn.Remote()] = append(conns, conn.(*poolConn))
 	p.Unlock()
 
-	return nil
+	for addr, conns := range probe {
+		var healthy []*poolConn
+		var failed uint64
+
+		for _, conn := range conns {
+			if err := p.opts.HealthCheck(conn); err != nil {
+				conn.Client.Close()
+				failed++
+				continue
+			}
+			healthy = append(healthy, conn)
+		}
+
+		p.Lock()
+		ap, ok := p.addrs[addr]
+		for _, conn := range healthy {
+			if !ok || p.closed || len(ap.idle) >= p.opts.Size {
+				conn.Client.Close()
+				continue
+			}
+			ap.idle = append(ap.idle, conn)
+		}
+		if ok {
+			ap.failures += failed
+			ap.wake()
+		}
+		p.Unlock()
+	}
 }
@@ -0,0 +1,225 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"common/transport"
+)
+
+func testPool(t *testing.T, opts ...Option) (Pool, string) {
+	tr := transport.NewMemoryTransport()
+
+	l, err := tr.Listen(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go l.Accept(func(s transport.Socket) {
+		for {
+			var msg transport.Message
+			if err := s.Recv(&msg); err != nil {
+				return
+			}
+			if err := s.Send(&msg); err != nil {
+				return
+			}
+		}
+	})
+
+	p := NewPool(append([]Option{Transport(tr), Size(2), TTL(time.Minute)}, opts...)...)
+	t.Cleanup(func() { p.Close() })
+
+	return p, l.Addr()
+}
+
+func TestPoolReuse(t *testing.T) {
+	p, addr := testPool(t)
+
+	for i := 0; i < 10; i++ {
+		c, err := p.Get(context.Background(), addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := &transport.Message{Body: []byte(`hello world`)}
+		if err := c.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		var rcv transport.Message
+		if err := c.Recv(&rcv); err != nil {
+			t.Fatal(err)
+		}
+		if string(rcv.Body) != string(msg.Body) {
+			t.Fatalf("got %v, expected %v", rcv.Body, msg.Body)
+		}
+
+		p.Release(c, nil)
+	}
+
+	s := p.Stats()[addr]
+	if s.Dials != 1 || s.Idle != 1 || s.Active != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestPoolMaxActive(t *testing.T) {
+	p, addr := testPool(t, MaxActive(1))
+
+	c, err := p.Get(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pool is exhausted until the context times out
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := p.Get(ctx, addr); err == nil {
+		t.Fatal("expected Get to fail on an exhausted pool")
+	}
+
+	// a waiter gets the released conn
+	got := make(chan Conn, 1)
+	go func() {
+		c, err := p.Get(context.Background(), addr)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- c
+	}()
+
+	for p.Stats()[addr].Waiters == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Release(c, nil)
+
+	select {
+	case c2 := <-got:
+		if c2 == nil || c2.Id() != c.Id() {
+			t.Fatal("expected the waiter to reuse the released conn")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken")
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	fail := errors.New("unhealthy")
+	p, addr := testPool(t, HealthCheck(time.Millisecond*10, func(c Conn) error {
+		return fail
+	}))
+
+	c, err := p.Get(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(c, nil)
+
+	deadline := time.Now().Add(time.Second)
+	for p.Stats()[addr].Idle > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("unhealthy conn was not evicted")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	if s := p.Stats()[addr]; s.Failures != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	p, addr := testPool(t, IdleTimeout(time.Millisecond*10))
+
+	c, err := p.Get(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(c, nil)
+
+	time.Sleep(time.Millisecond * 50)
+
+	c2, err := p.Get(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Id() == c.Id() {
+		t.Fatal("expected the idle conn to be evicted")
+	}
+}
+
+func TestPoolMultiplex(t *testing.T) {
+	tr := transport.NewMemoryTransport()
+
+	l, err := tr.Listen(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// answer the calls in reverse order
+	go l.Accept(func(s transport.Socket) {
+		var msgs []transport.Message
+		for {
+			var msg transport.Message
+			if err := s.Recv(&msg); err != nil {
+				return
+			}
+			if msg.Header["Micro-Multiplex"] != "true" {
+				t.Error("expected the multiplex header")
+			}
+			msgs = append(msgs, msg)
+			if len(msgs) < 3 {
+				continue
+			}
+			for i := len(msgs) - 1; i >= 0; i-- {
+				s.Send(&msgs[i])
+			}
+			msgs = nil
+		}
+	})
+
+	p := NewPool(Transport(tr), Multiplex(0))
+	defer p.Close()
+
+	var conns []Conn
+	for i := 0; i < 3; i++ {
+		c, err := p.Get(context.Background(), l.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Send(&transport.Message{
+			Header: map[string]string{"Micro-Id": "call"},
+			Body:   []byte{byte(i)},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+
+	for i, c := range conns {
+		var msg transport.Message
+		if err := c.Recv(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Body[0] != byte(i) || msg.Header["Micro-Id"] != "call" {
+			t.Fatalf("call %d got response %v", i, msg)
+		}
+	}
+
+	if s := p.Stats()[l.Addr()]; s.Dials != 1 || s.Active != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	for _, c := range conns {
+		p.Release(c, errors.New("call error"))
+	}
+
+	if s := p.Stats()[l.Addr()]; s.Active != 0 || s.Idle != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,326 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"common/transport"
+	"github.com/google/uuid"
+)
+
+const (
+	// idHeader correlates a response with its call
+	idHeader = "Micro-Id"
+	// muxHeader tells the server the call may be served concurrently
+	muxHeader = "Micro-Multiplex"
+)
+
+var (
+	errStreamClosed = errors.New("stream closed")
+)
+
+// muxConn is a connection shared by many calls, responses are
+// dispatched to the waiting stream by their id.
+type muxConn struct {
+	transport.Client
+	id      string
+	addr    string
+	created time.Time
+
+	sendMu sync.Mutex
+
+	sync.Mutex
+	seq     uint64
+	streams map[string]*muxStream
+	err     error
+	done    chan bool
+}
+
+// muxStream is a single call on a muxConn
+type muxStream struct {
+	conn *muxConn
+	id   string
+
+	// the id the caller set, restored on responses
+	callId string
+
+	recv chan *transport.Message
+	once sync.Once
+	exit chan bool
+}
+
+func (c *muxConn) newStream() *muxStream {
+	c.seq++
+	s := &muxStream{
+		conn: c,
+		id:   strconv.FormatUint(c.seq, 10),
+		recv: make(chan *transport.Message, 8),
+		exit: make(chan bool),
+	}
+	c.streams[s.id] = s
+	return s
+}
+
+func (c *muxConn) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run reads the responses until the connection fails
+func (c *muxConn) run(p *pool) {
+	for {
+		var m transport.Message
+		if err := c.Client.Recv(&m); err != nil {
+			c.Lock()
+			c.err = err
+			close(c.done)
+			c.Unlock()
+
+			p.dropMux(c)
+			c.Client.Close()
+			return
+		}
+
+		c.Lock()
+		s, ok := c.streams[m.Header[idHeader]]
+		c.Unlock()
+
+		// the call has gone away
+		if !ok {
+			continue
+		}
+
+		select {
+		case s.recv <- &m:
+		case <-s.exit:
+		}
+	}
+}
+
+func (s *muxStream) Id() string {
+	return s.conn.id + "-" + s.id
+}
+
+func (s *muxStream) Created() time.Time {
+	return s.conn.created
+}
+
+func (s *muxStream) Local() string {
+	return s.conn.Local()
+}
+
+func (s *muxStream) Remote() string {
+	return s.conn.Remote()
+}
+
+func (s *muxStream) Send(m *transport.Message) error {
+	select {
+	case <-s.exit:
+		return errStreamClosed
+	default:
+	}
+
+	// tag the message with the stream id
+	header := make(map[string]string, len(m.Header)+2)
+	for k, v := range m.Header {
+		header[k] = v
+	}
+	s.callId = m.Header[idHeader]
+	header[idHeader] = s.id
+	header[muxHeader] = "true"
+
+	s.conn.sendMu.Lock()
+	defer s.conn.sendMu.Unlock()
+
+	return s.conn.Client.Send(&transport.Message{
+		Header: header,
+		Body:   m.Body,
+	})
+}
+
+func (s *muxStream) Recv(m *transport.Message) error {
+	var msg *transport.Message
+
+	select {
+	case msg = <-s.recv:
+	case <-s.exit:
+		return errStreamClosed
+	case <-s.conn.done:
+		// responses read before the failure are still delivered
+		select {
+		case msg = <-s.recv:
+		default:
+			return s.conn.err
+		}
+	}
+
+	*m = *msg
+	if len(s.callId) > 0 {
+		m.Header[idHeader] = s.callId
+	}
+
+	return nil
+}
+
+// Close unblocks a pending Recv, the stream is removed on Release
+func (s *muxStream) Close() error {
+	s.once.Do(func() {
+		close(s.exit)
+	})
+	return nil
+}
+
+// getStream returns a stream on the least loaded connection to addr
+func (p *pool) getStream(ctx context.Context, addr string, opts ...transport.DialOption) (Conn, error) {
+	p.Lock()
+
+	for {
+		if p.closed {
+			p.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		ap := p.addr(addr)
+		now := time.Now()
+
+		var conn *muxConn
+		var load int
+		var live []*muxConn
+
+		for _, c := range ap.muxed {
+			c.Lock()
+			n := len(c.streams)
+			c.Unlock()
+
+			// retire old conns once their calls are done
+			if p.opts.TTL > 0 && now.Sub(c.created) > p.opts.TTL {
+				if n == 0 {
+					c.Client.Close()
+					continue
+				}
+				live = append(live, c)
+				continue
+			}
+			live = append(live, c)
+
+			if p.opts.MaxStreams > 0 && n >= p.opts.MaxStreams {
+				continue
+			}
+			if conn == nil || n < load {
+				conn = c
+				load = n
+			}
+		}
+		ap.muxed = live
+
+		if conn != nil {
+			conn.Lock()
+			s := conn.newStream()
+			conn.Unlock()
+
+			ap.active++
+			p.Unlock()
+
+			return s, nil
+		}
+
+		// dial a new conn if we're below the limit, concurrent
+		// calls wait for the dial in progress to share its conn
+		if ap.dialing == 0 && (p.opts.MaxActive <= 0 || len(ap.muxed) < p.opts.MaxActive) {
+			ap.dialing++
+			ap.dials++
+			p.Unlock()
+
+			c, err := p.opts.Transport.Dial(addr, opts...)
+
+			p.Lock()
+			ap.dialing--
+
+			if err != nil {
+				ap.failures++
+				ap.wake()
+				p.Unlock()
+				return nil, err
+			}
+
+			mc := &muxConn{
+				Client:  c,
+				id:      uuid.New().String(),
+				addr:    addr,
+				created: time.Now(),
+				streams: make(map[string]*muxStream),
+				done:    make(chan bool),
+			}
+			go mc.run(p)
+
+			ap.muxed = append(ap.muxed, mc)
+			ap.wake()
+
+			// loop round to pick the least loaded conn
+			continue
+		}
+
+		// wait for a stream to be released or a dial to finish
+		ap.waiters++
+		notify := ap.notify
+		p.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			p.Lock()
+			ap.waiters--
+			p.Unlock()
+			return nil, errors.New("pool exhausted: " + ctx.Err().Error())
+		}
+
+		p.Lock()
+		ap.waiters--
+	}
+}
+
+// releaseStream removes the stream from its connection
+func (p *pool) releaseStream(s *muxStream) error {
+	s.Close()
+
+	c := s.conn
+	c.Lock()
+	delete(c.streams, s.id)
+	c.Unlock()
+
+	p.Lock()
+	if ap, ok := p.addrs[c.addr]; ok {
+		ap.active--
+		ap.wake()
+	}
+	p.Unlock()
+
+	return nil
+}
+
+// dropMux removes a failed connection from the pool
+func (p *pool) dropMux(c *muxConn) {
+	p.Lock()
+	defer p.Unlock()
+
+	ap, ok := p.addrs[c.addr]
+	if !ok {
+		return
+	}
+
+	for i, mc := range ap.muxed {
+		if mc == c {
+			ap.muxed = append(ap.muxed[:i], ap.muxed[i+1:]...)
+			ap.failures++
+			ap.wake()
+			return
+		}
+	}
+}
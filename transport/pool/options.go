@@ -9,7 +9,22 @@ import (
 type Options struct {
 	Transport transport.Transport
 	TTL       time.Duration
-	Size      int
+	// Size is the maximum number of idle connections per address
+	Size int
+	// MaxActive limits the connections in use per address, Get blocks
+	// once it's reached. Zero means no limit.
+	MaxActive int
+	// IdleTimeout closes connections idle for longer than the timeout
+	IdleTimeout time.Duration
+	// HealthCheck probes idle connections every HealthCheckInterval,
+	// connections returning an error are closed.
+	HealthCheck         func(Conn) error
+	HealthCheckInterval time.Duration
+	// Multiplex shares each connection between concurrent calls,
+	// MaxActive then limits the connections and MaxStreams the calls
+	// carried by each of them. Zero MaxStreams means no limit.
+	Multiplex  bool
+	MaxStreams int
 }
 
 type Option func(*Options)
@@ -31,3 +46,43 @@ func TTL(t time.Duration) Option {
 		o.TTL = t
 	}
 }
+
+// MaxIdle sets the maximum number of idle connections per address, it's
+// the same as Size.
+func MaxIdle(i int) Option {
+	return func(o *Options) {
+		o.Size = i
+	}
+}
+
+// MaxActive sets the maximum number of connections in use per address
+func MaxActive(i int) Option {
+	return func(o *Options) {
+		o.MaxActive = i
+	}
+}
+
+// IdleTimeout sets how long a connection may stay idle in the pool
+func IdleTimeout(t time.Duration) Option {
+	return func(o *Options) {
+		o.IdleTimeout = t
+	}
+}
+
+// HealthCheck probes the idle connections with fn on the given interval
+func HealthCheck(d time.Duration, fn func(Conn) error) Option {
+	return func(o *Options) {
+		o.HealthCheckInterval = d
+		o.HealthCheck = fn
+	}
+}
+
+// Multiplex carries up to maxStreams concurrent calls on each connection,
+// responses are matched to their call by the Micro-Id header. The transport
+// must allow responses out of order, which rules out http.
+func Multiplex(maxStreams int) Option {
+	return func(o *Options) {
+		o.Multiplex = true
+		o.MaxStreams = maxStreams
+	}
+}
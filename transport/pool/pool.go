@@ -2,6 +2,7 @@
 package pool
 
 import (
+	"context"
 	"time"
 
 	"common/transport"
@@ -11,10 +12,13 @@ import (
 type Pool interface {
 	// Close the pool
 	Close() error
-	// Get a connection
-	Get(addr string, opts ...transport.DialOption) (Conn, error)
+	// Get a connection, blocks until the context is done when
+	// the address has reached its active limit
+	Get(ctx context.Context, addr string, opts ...transport.DialOption) (Conn, error)
 	// Releaes the connection
 	Release(c Conn, status error) error
+	// Stats of the connections per address
+	Stats() map[string]Stats
 }
 
 type Conn interface {
@@ -26,6 +30,20 @@ type Conn interface {
 	transport.Client
 }
 
+// Stats of the connections to an address
+type Stats struct {
+	// connections in use, calls in flight when multiplexed
+	Active int
+	// connections waiting in the pool, without calls when multiplexed
+	Idle int
+	// callers blocked in Get
+	Waiters int
+	// connections dialed
+	Dials uint64
+	// failed dials, health checks and connections released with an error
+	Failures uint64
+}
+
 func NewPool(opts ...Option) Pool {
 	var options Options
 	for _, o := range opts {
@@ -0,0 +1,40 @@
+package quic
+
+import (
+	"context"
+
+	"common/transport"
+)
+
+var (
+	// DefaultMaxStreams is the number of concurrent streams a peer may
+	// open on a connection, each client holds a stream
+	DefaultMaxStreams = 1000
+)
+
+type maxStreamsKey struct{}
+type allow0RTTKey struct{}
+
+// setOption returns a function to setup a context with given value
+func setOption(k, v interface{}) transport.Option {
+	return func(o *transport.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, k, v)
+	}
+}
+
+// MaxStreams sets the number of concurrent streams a peer may open on a
+// connection. Clients dialing over the limit of the server wait for a
+// stream to be closed, up to the dial timeout. Defaults to DefaultMaxStreams.
+func MaxStreams(n int) transport.Option {
+	return setOption(maxStreamsKey{}, n)
+}
+
+// Allow0RTT sends the first messages of resumed connections as 0-RTT data
+// and accepts it when listening. 0-RTT data can be replayed by an attacker,
+// only enable it when every request served is idempotent.
+func Allow0RTT() transport.Option {
+	return setOption(allow0RTTKey{}, true)
+}
@@ -0,0 +1,362 @@
+// Package quic provides a QUIC transport, each client is a stream on a
+// connection shared per address. Reconnects resume the TLS session, with
+// Allow0RTT their first request is sent as 0-RTT data.
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"common/log/log"
+	"common/transport"
+	maddr "common/util/addr"
+	mnet "common/util/net"
+	mls "common/util/tls"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+var (
+	// DefaultNextProto is the ALPN protocol negotiated by the transport
+	DefaultNextProto = "micro-quic"
+
+	// DefaultSessionCacheSize is the number of TLS sessions kept to
+	// resume connections with 0-RTT
+	DefaultSessionCacheSize = 128
+)
+
+type quicTransport struct {
+	opts transport.Options
+
+	// resumed sessions allow 0-RTT reconnects
+	sessions tls.ClientSessionCache
+
+	sync.Mutex
+	// connections shared by the streams of an address
+	conns map[string]quic.Connection
+}
+
+type quicListener struct {
+	l       *quic.EarlyListener
+	timeout time.Duration
+
+	sync.Mutex
+	conns map[quic.Connection]bool
+	exit  chan bool
+}
+
+func (q *quicListener) Addr() string {
+	return q.l.Addr().String()
+}
+
+func (q *quicListener) Close() error {
+	q.Lock()
+	select {
+	case <-q.exit:
+	default:
+		close(q.exit)
+	}
+	for conn := range q.conns {
+		conn.CloseWithError(0, "listener closed")
+	}
+	q.Unlock()
+
+	return q.l.Close()
+}
+
+func (q *quicListener) Accept(fn func(transport.Socket)) error {
+	for {
+		conn, err := q.l.Accept(context.Background())
+		if err != nil {
+			select {
+			case <-q.exit:
+				return nil
+			default:
+			}
+			return err
+		}
+
+		q.Lock()
+		q.conns[conn] = true
+		q.Unlock()
+
+		go q.serveConn(conn, fn)
+	}
+}
+
+// serveConn hands every stream opened on conn to fn
+func (q *quicListener) serveConn(conn quic.Connection, fn func(transport.Socket)) {
+	defer func() {
+		q.Lock()
+		delete(q.conns, conn)
+		q.Unlock()
+	}()
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		sock := newSocket(conn, stream, q.timeout)
+
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("quic: panic serving %s: %v", sock.Remote(), r)
+					sock.Close()
+				}
+			}()
+
+			fn(sock)
+		}()
+	}
+}
+
+// allow0RTT reports whether 0-RTT data is sent and accepted
+func (q *quicTransport) allow0RTT() bool {
+	if q.opts.Context == nil {
+		return false
+	}
+	ok, _ := q.opts.Context.Value(allow0RTTKey{}).(bool)
+	return ok
+}
+
+func (q *quicTransport) config() *quic.Config {
+	streams := DefaultMaxStreams
+	if q.opts.Context != nil {
+		if n, ok := q.opts.Context.Value(maxStreamsKey{}).(int); ok && n > 0 {
+			streams = n
+		}
+	}
+
+	return &quic.Config{
+		MaxIncomingStreams: int64(streams),
+		Allow0RTT:          q.allow0RTT(),
+	}
+}
+
+// getConn returns the shared connection to addr, dialing it if needed
+func (q *quicTransport) getConn(addr string, dopts transport.DialOptions) (quic.Connection, error) {
+	q.Lock()
+	conn, ok := q.conns[addr]
+	q.Unlock()
+
+	if ok {
+		select {
+		case <-conn.Context().Done():
+		default:
+			return conn, nil
+		}
+	}
+
+	config := q.opts.TLSConfig
+	if config == nil {
+		config = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
+	config = config.Clone()
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{DefaultNextProto}
+	}
+	if config.ClientSessionCache == nil {
+		config.ClientSessionCache = q.sessions
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dopts.Timeout)
+	defer cancel()
+
+	var dialed quic.Connection
+	var err error
+
+	// without 0-RTT nothing is sent before the handshake completes
+	if q.allow0RTT() {
+		dialed, err = quic.DialAddrEarly(ctx, addr, config, q.config())
+	} else {
+		dialed, err = quic.DialAddr(ctx, addr, config, q.config())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	// another dial won the race, use its connection
+	if c, ok := q.conns[addr]; ok && c != conn {
+		select {
+		case <-c.Context().Done():
+		default:
+			dialed.CloseWithError(0, "")
+			return c, nil
+		}
+	}
+
+	q.conns[addr] = dialed
+
+	return dialed, nil
+}
+
+func (q *quicTransport) setConn(addr string, conn quic.Connection) {
+	q.Lock()
+	q.conns[addr] = conn
+	q.Unlock()
+}
+
+func (q *quicTransport) Dial(addr string, opts ...transport.DialOption) (transport.Client, error) {
+	dopts := transport.DialOptions{
+		Timeout: transport.DefaultDialTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(&dopts)
+	}
+
+	conn, err := q.getConn(addr, dopts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dopts.Timeout)
+	defer cancel()
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		// the connection went away, redial once
+		select {
+		case <-conn.Context().Done():
+		default:
+			return nil, err
+		}
+
+		if conn, err = q.getConn(addr, dopts); err != nil {
+			return nil, err
+		}
+		if stream, err = conn.OpenStreamSync(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return &quicClient{
+		t:    q,
+		addr: addr,
+		sock: newSocket(conn, stream, q.opts.Timeout),
+	}, nil
+}
+
+func (q *quicTransport) Listen(addr string, opts ...transport.ListenOption) (transport.Listener, error) {
+	var options transport.ListenOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	// QUIC is always encrypted, without a config a certificate is generated
+	config := q.opts.TLSConfig
+	if config == nil {
+		hosts := []string{addr}
+
+		// check if its a valid host:port
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if len(host) == 0 {
+				hosts = maddr.IPs()
+			} else {
+				hosts = []string{host}
+			}
+		}
+
+		// generate a certificate
+		cert, err := mls.Certificate(hosts...)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	config = config.Clone()
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{DefaultNextProto}
+	}
+
+	l, err := q.listen(addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quicListener{
+		l:       l,
+		timeout: q.opts.Timeout,
+		conns:   make(map[quic.Connection]bool),
+		exit:    make(chan bool),
+	}, nil
+}
+
+// listen binds addr, or the first free port of a host:min-max range
+func (q *quicTransport) listen(addr string, config *tls.Config) (*quic.EarlyListener, error) {
+	host, ports, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// single port
+	prange := strings.Split(ports, "-")
+	if len(prange) < 2 {
+		return quic.ListenAddrEarly(addr, config, q.config())
+	}
+
+	min, err := strconv.Atoi(prange[0])
+	if err != nil {
+		return nil, errors.New("unable to extract port range")
+	}
+	max, err := strconv.Atoi(prange[1])
+	if err != nil {
+		return nil, errors.New("unable to extract port range")
+	}
+
+	for port := min; port <= max; port++ {
+		l, err := quic.ListenAddrEarly(mnet.HostPort(host, port), config, q.config())
+		if err == nil {
+			return l, nil
+		}
+
+		// hit max port
+		if port == max {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("unable to bind to %s", addr)
+}
+
+func (q *quicTransport) Init(opts ...transport.Option) error {
+	for _, o := range opts {
+		o(&q.opts)
+	}
+	return nil
+}
+
+func (q *quicTransport) Options() transport.Options {
+	return q.opts
+}
+
+func (q *quicTransport) String() string {
+	return "quic"
+}
+
+func NewTransport(opts ...transport.Option) transport.Transport {
+	var options transport.Options
+	for _, o := range opts {
+		o(&options)
+	}
+	return &quicTransport{
+		opts:     options,
+		sessions: tls.NewLRUClientSessionCache(DefaultSessionCacheSize),
+		conns:    make(map[string]quic.Connection),
+	}
+}
@@ -0,0 +1,185 @@
+package quic
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"common/transport"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+func echo(sock transport.Socket) {
+	defer sock.Close()
+	for {
+		var m transport.Message
+		if err := sock.Recv(&m); err != nil {
+			return
+		}
+		if err := sock.Send(&m); err != nil {
+			return
+		}
+	}
+}
+
+func roundTrip(t *testing.T, tr transport.Transport, addr string, id string) transport.Client {
+	c, err := tr.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &transport.Message{
+		Header: map[string]string{"Content-Type": "application/json", "Micro-Id": id},
+		Body:   []byte(fmt.Sprintf(`{"message": "%s"}`, id)),
+	}
+	if err := c.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var rsp transport.Message
+	if err := c.Recv(&rsp); err != nil {
+		t.Fatalf("message %s: %v", id, err)
+	}
+	if string(rsp.Body) != string(m.Body) || rsp.Header["Micro-Id"] != id {
+		t.Fatalf("unexpected echo %v", rsp)
+	}
+
+	return c
+}
+
+func TestQUICTransport(t *testing.T) {
+	tr := NewTransport(transport.Secure(true))
+
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go l.Accept(echo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := roundTrip(t, tr, l.Addr(), fmt.Sprint(i))
+			c.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	// every stream shares one connection
+	if n := len(tr.(*quicTransport).conns); n != 1 {
+		t.Fatalf("expected 1 shared connection, got %d", n)
+	}
+}
+
+func TestQUICTransportMaxStreams(t *testing.T) {
+	tr := NewTransport(MaxStreams(200))
+
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go l.Accept(echo)
+
+	// more clients than the default limit of quic-go stay open
+	for i := 0; i < 150; i++ {
+		defer roundTrip(t, tr, l.Addr(), fmt.Sprint(i)).Close()
+	}
+}
+
+func TestQUICTransportListenPortRange(t *testing.T) {
+	tr := NewTransport()
+
+	l1, err := tr.Listen("127.0.0.1:45123-45125")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	l2, err := tr.Listen("127.0.0.1:45123-45125")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+
+	if l1.Addr() != "127.0.0.1:45123" || l2.Addr() != "127.0.0.1:45124" {
+		t.Fatalf("unexpected addresses %s and %s", l1.Addr(), l2.Addr())
+	}
+}
+
+func TestQUICTransport0RTT(t *testing.T) {
+	tr := NewTransport()
+
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go l.Accept(echo)
+
+	c := roundTrip(t, tr, l.Addr(), "1")
+	conn := c.(*quicClient).socket().conn
+	c.Close()
+
+	conn.CloseWithError(0, "")
+	<-conn.Context().Done()
+
+	// 0-RTT is opt-in, the reconnect completes the handshake first
+	c = roundTrip(t, tr, l.Addr(), "2")
+	if c.(*quicClient).socket().conn.ConnectionState().Used0RTT {
+		t.Fatal("expected the reconnect not to use 0-RTT")
+	}
+	c.Close()
+}
+
+func TestQUICTransportReconnect(t *testing.T) {
+	tr := NewTransport(Allow0RTT())
+
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr()
+
+	go l.Accept(echo)
+
+	c := roundTrip(t, tr, addr, "1")
+	conn := c.(*quicClient).socket().conn
+	c.Close()
+
+	// drop the connection, the session ticket stays cached
+	conn.CloseWithError(0, "")
+	<-conn.Context().Done()
+
+	c = roundTrip(t, tr, addr, "2")
+	conn = c.(*quicClient).socket().conn
+	if early, ok := conn.(quic.EarlyConnection); ok {
+		<-early.HandshakeComplete()
+		if !early.ConnectionState().Used0RTT {
+			t.Fatal("expected the reconnect to use 0-RTT")
+		}
+	}
+	c.Close()
+
+	// a restarted server cannot resume the session and
+	// rejects the 0-RTT data, the first message is replayed
+	l.Close()
+	<-conn.Context().Done()
+
+	l, err = tr.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go l.Accept(echo)
+
+	roundTrip(t, tr, addr, "3").Close()
+}
@@ -0,0 +1,233 @@
+package quic
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"time"
+
+	"common/transport"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// quicSocket is a single QUIC stream, messages are gob encoded on it
+type quicSocket struct {
+	conn    quic.Connection
+	stream  quic.Stream
+	timeout time.Duration
+
+	enc *gob.Encoder
+	dec *gob.Decoder
+	buf *bufio.Writer
+
+	// send and recv may run concurrently on a stream
+	sendMu sync.Mutex
+	recvMu sync.Mutex
+}
+
+// quicClient is the dialing side of a stream, the connection is shared
+// with the other clients of the same address.
+type quicClient struct {
+	t    *quicTransport
+	addr string
+
+	sendMu sync.Mutex
+	recvMu sync.Mutex
+
+	sync.RWMutex
+	sock *quicSocket
+	// messages sent as 0-RTT data, replayed if the server rejects them
+	early []*transport.Message
+}
+
+func newSocket(conn quic.Connection, stream quic.Stream, timeout time.Duration) *quicSocket {
+	buf := bufio.NewWriter(stream)
+	return &quicSocket{
+		conn:    conn,
+		stream:  stream,
+		timeout: timeout,
+		enc:     gob.NewEncoder(buf),
+		dec:     gob.NewDecoder(stream),
+		buf:     buf,
+	}
+}
+
+func (q *quicSocket) Recv(m *transport.Message) error {
+	if m == nil {
+		return errors.New("message passed in is nil")
+	}
+
+	q.recvMu.Lock()
+	defer q.recvMu.Unlock()
+
+	return q.recv(m)
+}
+
+func (q *quicSocket) recv(m *transport.Message) error {
+	// set timeout if its greater than 0
+	if q.timeout > time.Duration(0) {
+		q.stream.SetReadDeadline(time.Now().Add(q.timeout))
+	}
+
+	return q.dec.Decode(m)
+}
+
+func (q *quicSocket) Send(m *transport.Message) error {
+	if m == nil {
+		return errors.New("message passed in is nil")
+	}
+
+	q.sendMu.Lock()
+	defer q.sendMu.Unlock()
+
+	return q.send(m)
+}
+
+func (q *quicSocket) send(m *transport.Message) error {
+	// set timeout if its greater than 0
+	if q.timeout > time.Duration(0) {
+		q.stream.SetWriteDeadline(time.Now().Add(q.timeout))
+	}
+
+	if err := q.enc.Encode(m); err != nil {
+		return err
+	}
+
+	return q.buf.Flush()
+}
+
+// Close closes the stream, the connection stays open for other streams
+func (q *quicSocket) Close() error {
+	q.stream.CancelRead(0)
+	return q.stream.Close()
+}
+
+func (q *quicSocket) Local() string {
+	return q.conn.LocalAddr().String()
+}
+
+func (q *quicSocket) Remote() string {
+	return q.conn.RemoteAddr().String()
+}
+
+func (q *quicClient) socket() *quicSocket {
+	q.RLock()
+	defer q.RUnlock()
+	return q.sock
+}
+
+func (q *quicClient) Recv(m *transport.Message) error {
+	if m == nil {
+		return errors.New("message passed in is nil")
+	}
+
+	q.recvMu.Lock()
+	defer q.recvMu.Unlock()
+
+	sock := q.socket()
+	err := sock.recv(m)
+
+	if errors.Is(err, quic.Err0RTTRejected) {
+		if err := q.replay(sock); err != nil {
+			return err
+		}
+		return q.socket().recv(m)
+	}
+
+	return err
+}
+
+func (q *quicClient) Send(m *transport.Message) error {
+	if m == nil {
+		return errors.New("message passed in is nil")
+	}
+
+	q.sendMu.Lock()
+	defer q.sendMu.Unlock()
+
+	sock := q.socket()
+
+	// keep what is sent before the handshake completes
+	q.Lock()
+	if handshaking(sock.conn) {
+		q.early = append(q.early, m)
+	} else {
+		q.early = nil
+	}
+	q.Unlock()
+
+	err := sock.send(m)
+
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// the message is part of the replay
+		return q.replay(sock)
+	}
+
+	return err
+}
+
+// handshaking reports whether data sent on conn may still be rejected
+func handshaking(conn quic.Connection) bool {
+	early, ok := conn.(quic.EarlyConnection)
+	if !ok {
+		return false
+	}
+
+	select {
+	case <-early.HandshakeComplete():
+		return false
+	default:
+		return true
+	}
+}
+
+// replay moves the client to a new stream on the connection which
+// replaced the rejected 0-RTT attempt and resends the early messages.
+func (q *quicClient) replay(sock *quicSocket) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// already replayed by send or recv
+	if q.sock != sock {
+		return nil
+	}
+
+	early, ok := sock.conn.(quic.EarlyConnection)
+	if !ok {
+		return quic.Err0RTTRejected
+	}
+
+	conn := early.NextConnection()
+	q.t.setConn(q.addr, conn)
+
+	stream, err := conn.OpenStreamSync(conn.Context())
+	if err != nil {
+		return err
+	}
+
+	next := newSocket(conn, stream, sock.timeout)
+	for _, m := range q.early {
+		if err := next.send(m); err != nil {
+			return err
+		}
+	}
+
+	q.sock = next
+	q.early = nil
+
+	return nil
+}
+
+func (q *quicClient) Close() error {
+	return q.socket().Close()
+}
+
+func (q *quicClient) Local() string {
+	return q.socket().Local()
+}
+
+func (q *quicClient) Remote() string {
+	return q.socket().Remote()
+}
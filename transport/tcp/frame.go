@@ -0,0 +1,114 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"common/transport"
+)
+
+// A frame is a 4 byte big endian length followed by the payload
+//
+//	[count uint32] count * ([klen uint32][key][vlen uint32][value]) [body]
+//
+// every length is big endian.
+
+var errShortFrame = errors.New("tcp: short frame")
+
+// writeFrame encodes m on w, the caller flushes
+func writeFrame(w *bufio.Writer, m *transport.Message, max int) error {
+	size := 4
+	for k, v := range m.Header {
+		size += 8 + len(k) + len(v)
+	}
+	size += len(m.Body)
+
+	if size > max {
+		return fmt.Errorf("tcp: message size %d exceeds maximum %d", size, max)
+	}
+
+	var b [4]byte
+
+	put := func(n int) {
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		w.Write(b[:])
+	}
+
+	put(size)
+	put(len(m.Header))
+	for k, v := range m.Header {
+		put(len(k))
+		w.WriteString(k)
+		put(len(v))
+		w.WriteString(v)
+	}
+	_, err := w.Write(m.Body)
+	return err
+}
+
+// readFrame decodes the next frame from r into m
+func readFrame(r *bufio.Reader, m *transport.Message, max int) error {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return err
+	}
+
+	size := int(binary.BigEndian.Uint32(b[:]))
+	if size > max {
+		return fmt.Errorf("tcp: message size %d exceeds maximum %d", size, max)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	next := func() (int, error) {
+		if len(data) < 4 {
+			return 0, errShortFrame
+		}
+		n := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if n < 0 || n > len(data) {
+			return 0, errShortFrame
+		}
+		return n, nil
+	}
+
+	count, err := next()
+	if err != nil {
+		return err
+	}
+
+	// every header takes at least its two lengths
+	if count > len(data)/8 {
+		return errShortFrame
+	}
+
+	m.Header = make(map[string]string, count)
+
+	for i := 0; i < count; i++ {
+		n, err := next()
+		if err != nil {
+			return err
+		}
+		k := string(data[:n])
+		data = data[n:]
+
+		if n, err = next(); err != nil {
+			return err
+		}
+		m.Header[k] = string(data[:n])
+		data = data[n:]
+	}
+
+	m.Body = data
+
+	return nil
+}
@@ -0,0 +1,13 @@
+// +build linux
+
+package tcp
+
+import (
+	"net"
+
+	"common/util/reuse"
+)
+
+func listenReusePort(addr string) (net.Listener, error) {
+	return reuse.Listen("tcp", addr)
+}
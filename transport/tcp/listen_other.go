@@ -0,0 +1,13 @@
+// +build !linux
+
+package tcp
+
+import (
+	"net"
+)
+
+// listenReusePort falls back to a plain listener where SO_REUSEPORT
+// is not supported.
+func listenReusePort(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
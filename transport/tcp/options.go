@@ -0,0 +1,50 @@
+package tcp
+
+import (
+	"context"
+	"time"
+
+	"common/transport"
+)
+
+type reusePortKey struct{}
+type listenTimeoutKey struct{}
+type maxMessageSizeKey struct{}
+
+// setListenOption returns a function to setup a context with given value
+func setListenOption(k, v interface{}) transport.ListenOption {
+	return func(o *transport.ListenOptions) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, k, v)
+	}
+}
+
+// setOption returns a function to setup a context with given value
+func setOption(k, v interface{}) transport.Option {
+	return func(o *transport.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, k, v)
+	}
+}
+
+// ReusePort listens with SO_REUSEPORT set so several processes can share
+// the port, only supported on linux.
+func ReusePort() transport.ListenOption {
+	return setListenOption(reusePortKey{}, true)
+}
+
+// ListenTimeout sets the Send/Recv timeout of the sockets accepted by the
+// listener, overriding the transport Timeout.
+func ListenTimeout(d time.Duration) transport.ListenOption {
+	return setListenOption(listenTimeoutKey{}, d)
+}
+
+// MaxMessageSize limits the size of a single frame, larger frames are
+// rejected. Defaults to DefaultMaxMessageSize.
+func MaxMessageSize(n int) transport.Option {
+	return setOption(maxMessageSizeKey{}, n)
+}
@@ -0,0 +1,293 @@
+// Package tcp provides a raw tcp transport, messages are framed with a
+// length prefix rather than carried in http requests.
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"common/log/log"
+	"common/transport"
+	maddr "common/util/addr"
+	mnet "common/util/net"
+	mls "common/util/tls"
+)
+
+var (
+	// DefaultMaxMessageSize is the largest frame accepted by default
+	DefaultMaxMessageSize = 64 * 1024 * 1024
+)
+
+type tcpTransport struct {
+	opts transport.Options
+}
+
+type tcpTransportSocket struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+	maxSize int
+
+	// send and recv may run concurrently on a stream
+	sendMu sync.Mutex
+	recvMu sync.Mutex
+}
+
+type tcpTransportClient struct {
+	*tcpTransportSocket
+	dialOpts transport.DialOptions
+}
+
+type tcpTransportListener struct {
+	listener net.Listener
+	timeout  time.Duration
+	maxSize  int
+}
+
+func newSocket(conn net.Conn, timeout time.Duration, maxSize int) *tcpTransportSocket {
+	return &tcpTransportSocket{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		timeout: timeout,
+		maxSize: maxSize,
+	}
+}
+
+func (t *tcpTransportSocket) Local() string {
+	return t.conn.LocalAddr().String()
+}
+
+func (t *tcpTransportSocket) Remote() string {
+	return t.conn.RemoteAddr().String()
+}
+
+func (t *tcpTransportSocket) Recv(m *transport.Message) error {
+	if m == nil {
+		return errors.New("message passed in is nil")
+	}
+
+	t.recvMu.Lock()
+	defer t.recvMu.Unlock()
+
+	// set timeout if its greater than 0
+	if t.timeout > time.Duration(0) {
+		t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+
+	return readFrame(t.r, m, t.maxSize)
+}
+
+func (t *tcpTransportSocket) Send(m *transport.Message) error {
+	if m == nil {
+		return errors.New("message passed in is nil")
+	}
+
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+
+	// set timeout if its greater than 0
+	if t.timeout > time.Duration(0) {
+		t.conn.SetWriteDeadline(time.Now().Add(t.timeout))
+	}
+
+	if err := writeFrame(t.w, m, t.maxSize); err != nil {
+		// drop whatever was partially buffered
+		t.w.Reset(t.conn)
+		return err
+	}
+
+	return t.w.Flush()
+}
+
+func (t *tcpTransportSocket) Close() error {
+	return t.conn.Close()
+}
+
+func (t *tcpTransportListener) Addr() string {
+	return t.listener.Addr().String()
+}
+
+func (t *tcpTransportListener) Close() error {
+	return t.listener.Close()
+}
+
+func (t *tcpTransportListener) Accept(fn func(transport.Socket)) error {
+	var tempDelay time.Duration
+
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				log.Errorf("tcp: Accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+
+		sock := newSocket(c, t.timeout, t.maxSize)
+
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("tcp: panic serving %s: %v", sock.Remote(), r)
+					sock.Close()
+				}
+			}()
+
+			fn(sock)
+		}()
+	}
+}
+
+func (t *tcpTransport) maxMessageSize() int {
+	if t.opts.Context != nil {
+		if n, ok := t.opts.Context.Value(maxMessageSizeKey{}).(int); ok && n > 0 {
+			return n
+		}
+	}
+	return DefaultMaxMessageSize
+}
+
+func (t *tcpTransport) Dial(addr string, opts ...transport.DialOption) (transport.Client, error) {
+	dopts := transport.DialOptions{
+		Timeout: transport.DefaultDialTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(&dopts)
+	}
+
+	var conn net.Conn
+	var err error
+
+	// TODO: support dial option here rather than using internal config
+	if t.opts.Secure || t.opts.TLSConfig != nil {
+		config := t.opts.TLSConfig
+		if config == nil {
+			config = &tls.Config{
+				InsecureSkipVerify: true,
+			}
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dopts.Timeout}, "tcp", addr, config)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, dopts.Timeout)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tcpTransportClient{
+		tcpTransportSocket: newSocket(conn, t.opts.Timeout, t.maxMessageSize()),
+		dialOpts:           dopts,
+	}, nil
+}
+
+func (t *tcpTransport) Listen(addr string, opts ...transport.ListenOption) (transport.Listener, error) {
+	var options transport.ListenOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	timeout := t.opts.Timeout
+	listen := func(addr string) (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	}
+
+	if options.Context != nil {
+		if d, ok := options.Context.Value(listenTimeoutKey{}).(time.Duration); ok {
+			timeout = d
+		}
+		if ok, _ := options.Context.Value(reusePortKey{}).(bool); ok {
+			listen = listenReusePort
+		}
+	}
+
+	var l net.Listener
+	var err error
+
+	if t.opts.Secure || t.opts.TLSConfig != nil {
+		config := t.opts.TLSConfig
+
+		fn := func(addr string) (net.Listener, error) {
+			if config == nil {
+				hosts := []string{addr}
+
+				// check if its a valid host:port
+				if host, _, err := net.SplitHostPort(addr); err == nil {
+					if len(host) == 0 {
+						hosts = maddr.IPs()
+					} else {
+						hosts = []string{host}
+					}
+				}
+
+				// generate a certificate
+				cert, err := mls.Certificate(hosts...)
+				if err != nil {
+					return nil, err
+				}
+				config = &tls.Config{Certificates: []tls.Certificate{cert}}
+			}
+
+			l, err := listen(addr)
+			if err != nil {
+				return nil, err
+			}
+			return tls.NewListener(l, config), nil
+		}
+
+		l, err = mnet.Listen(addr, fn)
+	} else {
+		l, err = mnet.Listen(addr, listen)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tcpTransportListener{
+		listener: l,
+		timeout:  timeout,
+		maxSize:  t.maxMessageSize(),
+	}, nil
+}
+
+func (t *tcpTransport) Init(opts ...transport.Option) error {
+	for _, o := range opts {
+		o(&t.opts)
+	}
+	return nil
+}
+
+func (t *tcpTransport) Options() transport.Options {
+	return t.opts
+}
+
+func (t *tcpTransport) String() string {
+	return "tcp"
+}
+
+func NewTransport(opts ...transport.Option) transport.Transport {
+	var options transport.Options
+	for _, o := range opts {
+		o(&options)
+	}
+	return &tcpTransport{opts: options}
+}
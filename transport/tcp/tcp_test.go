@@ -0,0 +1,161 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"common/transport"
+)
+
+func echo(sock transport.Socket) {
+	defer sock.Close()
+	for {
+		var m transport.Message
+		if err := sock.Recv(&m); err != nil {
+			return
+		}
+		if err := sock.Send(&m); err != nil {
+			return
+		}
+	}
+}
+
+func testTransport(t *testing.T, tr transport.Transport) {
+	l, err := tr.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go l.Accept(echo)
+
+	roundTrip(t, tr, l.Addr())
+}
+
+func roundTrip(t *testing.T, tr transport.Transport, addr string) {
+	c, err := tr.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		m := &transport.Message{
+			Header: map[string]string{"Content-Type": "application/json", "Micro-Id": fmt.Sprint(i)},
+			Body:   []byte(fmt.Sprintf(`{"message": "%d"}`, i)),
+		}
+		if err := c.Send(m); err != nil {
+			t.Fatal(err)
+		}
+
+		var rsp transport.Message
+		if err := c.Recv(&rsp); err != nil {
+			t.Fatal(err)
+		}
+		if string(rsp.Body) != string(m.Body) || rsp.Header["Micro-Id"] != m.Header["Micro-Id"] {
+			t.Fatalf("unexpected echo %v", rsp)
+		}
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	testTransport(t, NewTransport())
+}
+
+func TestTCPTransportSecure(t *testing.T) {
+	testTransport(t, NewTransport(transport.Secure(true)))
+}
+
+func TestTCPTransportReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only used on linux")
+	}
+
+	tr := NewTransport()
+
+	l1, err := tr.Listen("127.0.0.1:0", ReusePort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	// a second listener shares the port
+	l2, err := tr.Listen(l1.Addr(), ReusePort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+
+	go l1.Accept(echo)
+	go l2.Accept(echo)
+
+	roundTrip(t, tr, l1.Addr())
+}
+
+func TestTCPTransportListenTimeout(t *testing.T) {
+	tr := NewTransport()
+
+	l, err := tr.Listen("127.0.0.1:0", ListenTimeout(time.Millisecond*50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	go l.Accept(func(sock transport.Socket) {
+		defer sock.Close()
+		var m transport.Message
+		errs <- sock.Recv(&m)
+	})
+
+	c, err := tr.Dial(l.Addr(), transport.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Fatalf("expected a timeout error, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("server socket did not time out")
+	}
+}
+
+func TestFrame(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	in := &transport.Message{
+		Header: map[string]string{"Foo": "bar", "Empty": ""},
+		Body:   []byte("hello"),
+	}
+
+	if err := writeFrame(w, in, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+
+	var out transport.Message
+	if err := readFrame(bufio.NewReader(bytes.NewReader(b.Bytes())), &out, DefaultMaxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Body) != "hello" || out.Header["Foo"] != "bar" || len(out.Header) != 2 {
+		t.Fatalf("unexpected message %v", out)
+	}
+
+	// frames over the limit are rejected on both sides
+	if err := writeFrame(w, in, 8); err == nil {
+		t.Fatal("expected an error writing an oversized frame")
+	}
+	if err := readFrame(bufio.NewReader(bytes.NewReader(b.Bytes())), &out, 8); err == nil {
+		t.Fatal("expected an error reading an oversized frame")
+	}
+}
@@ -18,6 +18,10 @@ type Options struct {
 	Selector selector.Strategy
 	Destination   string
 	RouteTag	  string
+	// HashHeader is the header holding a session id, requests
+	// with it are routed by consistent hashing on Hash
+	HashHeader string
+	Hash       *selector.Hash
 
 	// Other options for implementations can be stored in a context. like selector_ttl.
 	Context context.Context
@@ -46,4 +50,17 @@ func WithRouteTag(d string) Option {
 	return func(o *Options) {
 		o.RouteTag = d
 	}
-}
\ No newline at end of file
+}
+
+func WithHashHeader(h string) Option {
+	return func(o *Options) {
+		o.HashHeader = h
+	}
+}
+
+// WithHash sets the ring of WithHashHeader, selector.DefaultHash by default
+func WithHash(h *selector.Hash) Option {
+	return func(o *Options) {
+		o.Hash = h
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"common/log/log"
 	"common/rcache"
 	"common/registry"
+	"common/selector"
 	"errors"
 	"fmt"
 	"net/http"
@@ -105,6 +106,16 @@ func (r *roundShardTripper)  roundTrip(s []*registry.Service, req *http.Request)
 	// select the one with roundBinSelect
 	next := r.opts.Selector(s)
 
+	// requests of a session stick to the node owning it
+	var hash *selector.Hash
+	if key := req.Header.Get(r.opts.HashHeader); len(r.opts.HashHeader) > 0 && len(key) > 0 {
+		hash = r.opts.Hash
+		if hash == nil {
+			hash = selector.DefaultHash
+		}
+		next = hash.Strategy(key)(s)
+	}
+
 	// rudimentary retry 3 times , may be the same one.
 	for i := 0; i < 3; i++ {
 		n, err := next()
@@ -120,6 +131,11 @@ func (r *roundShardTripper)  roundTrip(s []*registry.Service, req *http.Request)
 
 		// w, err := r.rt.RoundTrip(req)
 		w, err := r.rt.RoundTrip(req)
+		if hash != nil {
+			hash.Done(n)
+		}
+		// feed the load aware strategies, e.g. selector.P2C
+		selector.DefaultBalancer.Mark(n, err)
 		if err != nil {
 			// de register
 			// de register to fix?..